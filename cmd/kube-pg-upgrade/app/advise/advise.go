@@ -0,0 +1,89 @@
+package advise
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/advisor"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/table"
+)
+
+type adviseOptions struct {
+	namespace     string
+	allNamespaces bool
+	selector      string
+	// output is the format of the advice, table or json
+	output string
+	// releasesFile replaces the embedded release table
+	releasesFile string
+	eolWarning   time.Duration
+}
+
+// NewAdviseCmd returns the Cobra advise sub command
+func NewAdviseCmd() *cobra.Command {
+	opts := &adviseOptions{}
+	cmd := &cobra.Command{
+		Use:   "advise",
+		Args:  cobra.NoArgs,
+		Short: "Recommend an upgrade target for the postgres databases",
+		Long: `Compare the major version of every postgres database found by the inventory with the PostgreSQL release table. Versions past or near their end-of-life are flagged, and the latest supported major version with an upgrade image path is recommended as target, with the notable breaking changes up to that version.
+
+The release table is embedded in kube-pg-upgrade, use --releases-file to use an updated table.`,
+		Example: `# advise on the postgres databases in all namespaces
+kube-pg-upgrade advise --all-namespaces
+
+# advise with an updated release table, as JSON
+kube-pg-upgrade advise -n databases --releases-file releases.json -o json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.output != "table" && opts.output != "json" {
+				return fmt.Errorf("unsupported output format %q, must be table or json", opts.output)
+			}
+			releases, err := advisor.LoadReleases(opts.releasesFile)
+			if err != nil {
+				return err
+			}
+			k8sClient, namespace, err := kubeclient.FromContext(cmd.Context()).NewClientAndNamespace()
+			if err != nil {
+				return err
+			}
+			if opts.namespace != "" {
+				namespace = opts.namespace
+			}
+			if opts.allNamespaces {
+				namespace = ""
+			}
+
+			items, err := pgupgrade.Inventory(cmd.Context(), k8sClient, namespace, opts.selector)
+			if err != nil {
+				return err
+			}
+			adv := &advisor.Advisor{Releases: releases, Now: time.Now(), EOLWarning: opts.eolWarning}
+			advice := adv.AdviseInventory(items)
+
+			if opts.output == "json" {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(advice)
+			}
+			header, rows := advisor.AdviceTable(advice)
+			table.Fprint(cmd.OutOrStdout(), header, rows)
+			if summary := advisor.BreakingChangesSummary(advice); summary != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "\n%s", summary)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "", "Namespace to advise on. Default is the configured namespace in your kubecontext.")
+	cmd.Flags().BoolVarP(&opts.allNamespaces, "all-namespaces", "A", false, "Advise on the workloads in all namespaces.")
+	cmd.Flags().StringVarP(&opts.selector, "selector", "l", "", "Label selector of the workloads to advise on. For example: app.kubernetes.io/name=postgresql")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "table", "Output format, table or json.")
+	cmd.Flags().StringVar(&opts.releasesFile, "releases-file", "", "JSON file with the PostgreSQL release table, replaces the embedded table.")
+	cmd.Flags().DurationVar(&opts.eolWarning, "eol-warning", 180*24*time.Hour, "Flag versions that reach their end-of-life within this duration as eol-soon.")
+	return cmd
+}
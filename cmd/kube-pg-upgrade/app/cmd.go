@@ -6,9 +6,14 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/advise"
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/controller"
 	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/databases/postgres"
 	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/docs"
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/inventory"
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/logging"
 	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/version"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
 )
 
 // Execute runs the kube-pg-upgrade application
@@ -19,20 +24,34 @@ func Execute() error {
 
 // NewACloudToolKitCmd returns cobra.Command to run the kube-pg-upgrade command
 func NewACloudToolKitCmd(in io.Reader, out, err io.Writer) *cobra.Command {
+	logOptions := &logging.Options{}
+	clientOptions := &kubeclient.Options{}
 	cmds := &cobra.Command{
 		Use:   "kube-pg-upgrade",
 		Short: "kube-pg-upgrade for upgrades Postgres on Kubernetes",
 		Long:  "kube-pg-upgrade for upgrades Postgres on Kubernetes deployed with the Bitnami or Docker Hub images",
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			logger, loggerErr := logOptions.NewLogger(err)
+			if loggerErr != nil {
+				return loggerErr
+			}
+			ctx := logging.NewContext(cmd.Context(), logger)
+			cmd.SetContext(kubeclient.NewContext(ctx, clientOptions))
 			return nil
 		},
 	}
 
 	cmds.ResetFlags()
+	logging.AddFlags(cmds.PersistentFlags(), logOptions)
+	kubeclient.AddFlags(cmds.PersistentFlags(), clientOptions)
 
 	cmds.AddCommand(version.NewVersionCmd())
 	cmds.AddCommand(docs.NewOpenDocs())
 	cmds.AddCommand(postgres.NewPostgresCmd())
+	cmds.AddCommand(postgres.NewMigrateCmd())
+	cmds.AddCommand(inventory.NewInventoryCmd())
+	cmds.AddCommand(advise.NewAdviseCmd())
+	cmds.AddCommand(controller.NewControllerCmd())
 
 	return cmds
 }
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/logging"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/controller"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/gitops"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/imagemirror"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/ptrs"
+)
+
+type controllerOptions struct {
+	namespace    string
+	workers      int
+	resyncPeriod time.Duration
+
+	upgradeImage         string
+	upgradeImageTemplate string
+	upgradeCommand       string
+	imageMirrorConfig    string
+	imagePullSecrets     []string
+
+	useJobs       bool
+	jobTTL        time.Duration
+	stuckTimeout  time.Duration
+	keepOnFailure bool
+
+	suspendGitOps    bool
+	argoCDNamespace  string
+	checkPermissions bool
+	// output is the format of the progress output, text or json
+	output string
+}
+
+// NewControllerCmd returns the Cobra controller sub command
+func NewControllerCmd() *cobra.Command {
+	opts := &controllerOptions{}
+	cmd := &cobra.Command{
+		Use:   "controller",
+		Args:  cobra.NoArgs,
+		Short: "Run the upgrades requested by PostgresUpgrade resources",
+		Long: `Watch PostgresUpgrade resources and upgrade the statefulset or pvc they target. The phase, conditions and timestamps of every upgrade are reported in the status of the resource.
+
+An upgrade runs once per generation of the spec, a failed upgrade is retried after the spec changes. An upgrade that was running when the controller stopped is resumed when it starts again.
+
+The PostgresUpgrade custom resource definition is in deploy/crds.`,
+		Example: `# run the upgrades requested in all namespaces, two at a time
+kube-pg-upgrade controller --workers 2
+
+# run the upgrades requested in a single namespace as jobs
+kube-pg-upgrade controller -n databases --use-jobs`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			switch opts.output {
+			case "", "text":
+				ctx = progress.WithReporter(ctx, progress.NewHumanReporter(os.Stdout))
+			case "json":
+				ctx = progress.WithReporter(ctx, progress.NewJSONReporter(os.Stdout))
+			default:
+				return fmt.Errorf("unsupported output format %q, must be text or json", opts.output)
+			}
+
+			settings, err := opts.toSettings()
+			if err != nil {
+				return err
+			}
+			logger := logging.FromContext(ctx)
+			settings.Logger = logger
+
+			restConfig, err := kubeclient.FromContext(ctx).RESTConfig()
+			if err != nil {
+				return err
+			}
+			k8sClient, err := kubeclient.GetClientWithConfig(restConfig)
+			if err != nil {
+				return err
+			}
+			dynamicClient, err := dynamic.NewForConfig(restConfig)
+			if err != nil {
+				return err
+			}
+			settings.DynamicClient = dynamicClient
+			settings.SuspendGitOps = opts.suspendGitOps
+			settings.ArgoCDNamespace = opts.argoCDNamespace
+			settings.CheckPermissions = opts.checkPermissions
+
+			return controller.New(dynamicClient, k8sClient, controller.Options{
+				Namespace:    opts.namespace,
+				Workers:      opts.workers,
+				ResyncPeriod: opts.resyncPeriod,
+				Settings:     settings,
+				Logger:       logger,
+			}).Run(ctx)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "", "Namespace to watch for PostgresUpgrade resources. Default is all namespaces.")
+	cmd.Flags().IntVar(&opts.workers, "workers", 1, "Number of upgrades running at the same time.")
+	cmd.Flags().DurationVar(&opts.resyncPeriod, "resync-period", 10*time.Minute, "How often all PostgresUpgrade resources are reconciled again, zero disables the resync.")
+	cmd.Flags().StringVar(&opts.upgradeImage, "upgrade-image", "tianon/postgres-upgrade", "Container image used to run pg_upgrade, unless set in the PostgresUpgrade.")
+	cmd.Flags().StringVar(&opts.upgradeImageTemplate, "upgrade-image-template", pgupgrade.DefaultUpgradeImageTemplate, "Go template for the upgrade image reference, unless set in the PostgresUpgrade. Available placeholders: {{ .Image }}, {{ .From }}, {{ .To }} and {{ .Distro }}.")
+	cmd.Flags().StringVar(&opts.upgradeCommand, "upgrade-command", strings.Join(pgupgrade.DefaultUpgradeCommand, " "), "Command running pg_upgrade in the upgrade image, including the initdb of the new cluster. Replaces the entrypoint of the image.")
+	cmd.Flags().StringVar(&opts.imageMirrorConfig, "image-mirror-config", "", "Path to a YAML file mapping image prefixes to registry mirrors, with optional digest pinning. Applied to every image used during the upgrades.")
+	cmd.Flags().StringSliceVar(&opts.imagePullSecrets, "image-pull-secret", nil, "Name of an image pull secret added to the upgrade pods. Can be repeated.")
+	cmd.Flags().BoolVar(&opts.useJobs, "use-jobs", false, "Run the upgrade steps as batch/v1 Jobs instead of bare pods, so a running job is adopted when an interrupted upgrade is resumed.")
+	cmd.Flags().DurationVar(&opts.jobTTL, "job-ttl", 24*time.Hour, "Time after which finished jobs are removed from the cluster, zero means jobs are kept. Requires --use-jobs.")
+	cmd.Flags().DurationVar(&opts.stuckTimeout, "stuck-timeout", podrunner.DefaultStuckTimeout, "How long an upgrade pod may be unschedulable or wait for its volumes before the upgrade fails. Image pull and configuration errors fail immediately.")
+	cmd.Flags().BoolVar(&opts.keepOnFailure, "keep-on-failure", false, "Keep failed upgrade pods, the scripts secret and the temporary volume for debugging.")
+	cmd.Flags().BoolVar(&opts.suspendGitOps, "suspend-gitops", true, "Suspend the sync of the Argo CD Application and the Flux HelmRelease and Kustomization of a statefulset during its upgrade. The sync is resumed afterwards, also when the upgrade fails.")
+	cmd.Flags().StringVar(&opts.argoCDNamespace, "argocd-namespace", gitops.DefaultArgoCDNamespace, "Namespace of the Argo CD Applications.")
+	cmd.Flags().BoolVar(&opts.checkPermissions, "check-permissions", true, "Check with SelfSubjectAccessReviews that all permissions an upgrade needs are granted before changing anything, and fail the upgrade listing the missing permissions.")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "text", "Output format of the progress of the upgrades, text or json.")
+	return cmd
+}
+
+func (o *controllerOptions) toSettings() (pgupgrade.PGUpgradeSettings, error) {
+	settings := pgupgrade.PGUpgradeSettings{
+		UpgradeImage:         o.upgradeImage,
+		UpgradeImageTemplate: o.upgradeImageTemplate,
+		UpgradeCommand:       strings.Fields(o.upgradeCommand),
+		ImagePullSecrets:     o.imagePullSecrets,
+		PGDataOld:            pgupgrade.DefaultPGDataOldTemplate,
+		PGDataNew:            pgupgrade.DefaultPGDataNewTemplate,
+		StuckTimeout:         o.stuckTimeout,
+		KeepOnFailure:        o.keepOnFailure,
+		UseJobs:              o.useJobs,
+		JobOptions:           podrunner.JobOptions{BackoffLimit: 2},
+	}
+	if o.jobTTL > 0 {
+		settings.JobOptions.TTLSecondsAfterFinished = ptrs.Int32(int32(o.jobTTL.Seconds()))
+	}
+	if o.imageMirrorConfig != "" {
+		mirrors, err := imagemirror.LoadConfig(o.imageMirrorConfig)
+		if err != nil {
+			return settings, err
+		}
+		settings.ImageMirrors = mirrors
+	}
+	return settings, nil
+}
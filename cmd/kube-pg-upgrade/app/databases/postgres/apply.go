@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/logging"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+)
+
+func AddPostgresApplyFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.StringVarP(&opts.planFile, "filename", "f", "", "YAML or JSON file with the upgrade plan.")
+	flagSet.StringVarP(&opts.namespace, "namespace", "n", "", "namespace of the targets without a namespace in the plan. Default is the configured namespace in your kubecontext.")
+	addUpgradeImageFlags(flagSet, opts)
+
+	// Hooks
+	addHookFlags(flagSet, opts)
+
+	// Jobs
+	addJobFlags(flagSet, opts)
+
+	// GitOps
+	addGitOpsFlags(flagSet, opts)
+
+	// Checks
+	flagSet.BoolVar(&opts.checkPermissions, "check-permissions", true, "Check with SelfSubjectAccessReviews that all permissions the upgrade needs are granted before changing anything, and print a table of the missing permissions.")
+	flagSet.BoolVar(&opts.checkExtensions, "check-extensions", false, "Check that all installed extensions and shared_preload_libraries are available in the upgrade image and the target image before migrating any data.")
+	flagSet.BoolVar(&opts.verifyData, "verify-data", false, "Compare the tables, row counts and sequence values of the old and the new cluster after pg_upgrade. Any difference fails the upgrade before the volumes are swapped.")
+	flagSet.BoolVar(&opts.verifyChecksums, "verify-checksums", false, "Also compare a checksum of the contents of every table. Implies --verify-data. Reads all data of both clusters, which can take a long time for large databases.")
+
+	// Other
+	flagSet.DurationVar(&opts.timeout, "timeout", 0*time.Second, "The length of time to wait for the whole plan before giving up, zero means infinite")
+	flagSet.StringVar(&opts.logDir, "log-dir", "", "Local directory the full logs of every upgrade pod are written to, one file per pod.")
+	flagSet.DurationVar(&opts.stuckTimeout, "stuck-timeout", podrunner.DefaultStuckTimeout, "How long an upgrade pod may be unschedulable or wait for its volumes before the upgrade fails. Image pull and configuration errors fail immediately.")
+	addOutputFlag(flagSet, opts)
+	addMetricsFlags(flagSet, opts)
+	flagSet.BoolVar(&opts.keepOnFailure, "keep-on-failure", false, "Keep failed upgrade pods, the scripts secret and the temporary volume for debugging, and print the kubectl commands to inspect them.")
+}
+
+//go:embed examples/apply.txt
+var pgUpgradeApplyExamples string
+
+// NewUpgradePostgresApplyCmd
+func NewUpgradePostgresApplyCmd(runOptions *postgresPGUpgradeOptions) *cobra.Command {
+	if runOptions == nil {
+		runOptions = newPostgresPGUpgradeOptions()
+	}
+
+	var cmd = &cobra.Command{
+		Use:   "apply",
+		Args:  cobra.NoArgs,
+		Short: "Upgrade the statefulsets and pvcs listed in an upgrade plan",
+		Long: `Upgrade the statefulsets and pvcs listed in an upgrade plan file. The plan lists the targets with their settings, the defaults of all targets and whether the targets are upgraded sequentially or in parallel. The whole plan is validated before any upgrade starts, and all problems are reported at once.
+
+The flags apply to every target, the settings in the plan take precedence. A failed upgrade does not stop the plan, the results of all upgrades are printed once the plan is done.`,
+		Example: pgUpgradeApplyExamples,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+
+			if runOptions.timeout > 0 {
+				timeoutctx, cancelTimeout := context.WithTimeoutCause(ctx, runOptions.timeout, fmt.Errorf("plan did not complete within configured timeout (%s)", runOptions.timeout.String()))
+				defer cancelTimeout()
+				ctx = timeoutctx
+			}
+
+			ctx, err := runOptions.withReporter(ctx)
+			if err != nil {
+				return err
+			}
+			plan, err := pgupgrade.LoadUpgradePlan(runOptions.planFile)
+			if err != nil {
+				return err
+			}
+			settings, err := runOptions.toSettings()
+			if err != nil {
+				return err
+			}
+			settings.Logger = logging.FromContext(ctx)
+
+			clientOptions := kubeclient.FromContext(ctx)
+			k8sClient, namespace, err := clientOptions.NewClientAndNamespace()
+			if err != nil {
+				return err
+			}
+			settings.DynamicClient, err = clientOptions.NewDynamicClient()
+			if err != nil {
+				return err
+			}
+			if runOptions.namespace != "" {
+				namespace = runOptions.namespace
+			}
+			targets := plan.BatchTargets(settings, namespace)
+			errs := []error{}
+			for i, target := range targets {
+				if err := target.Settings.Validate(); err != nil {
+					errs = append(errs, fmt.Errorf("targets[%d]: %w", i, err))
+				}
+			}
+			if err := errors.Join(errs...); err != nil {
+				return fmt.Errorf("invalid upgrade plan %q:\n%w", runOptions.planFile, err)
+			}
+			progress.Printf(ctx, "upgrading %d databases\n", len(targets))
+
+			registry := runOptions.newMetricsRegistry()
+			options := plan.BatchOptions()
+			options.WithTarget = func(ctx context.Context, target pgupgrade.BatchTarget) context.Context {
+				return withMetricsReporter(ctx, registry)
+			}
+			results := pgupgrade.RunBatch(ctx, k8sClient, targets, options)
+			pgupgrade.PrintBatchResults(ctx, results)
+			return errors.Join(pgupgrade.BatchError(results), runOptions.writeMetrics(ctx, registry))
+		},
+	}
+
+	AddPostgresApplyFlags(cmd.Flags(), runOptions)
+
+	cmd.MarkFlagRequired("filename")
+	return cmd
+}
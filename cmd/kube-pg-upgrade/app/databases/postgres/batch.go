@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/logging"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+)
+
+func AddPostgresBatchUpgradeFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.StringVarP(&opts.namespace, "namespace", "n", "", "namespace of the postgres instances. Default is the configured namespace in your kubecontext.")
+	flagSet.BoolVarP(&opts.allNamespaces, "all-namespaces", "A", false, "Upgrade the matching statefulsets in all namespaces.")
+	flagSet.StringVarP(&opts.selector, "selector", "l", "", "Label selector of the statefulsets to upgrade. For example: app.kubernetes.io/name=postgresql")
+	flagSet.IntVar(&opts.concurrency, "concurrency", 1, "Number of databases upgraded at the same time.")
+	flagSet.DurationVar(&opts.perDatabaseTimeout, "per-database-timeout", 0*time.Second, "The length of time to wait for the upgrade of a single database before giving up on it, zero means infinite")
+	addUpgradeImageFlags(flagSet, opts)
+
+	// PostgreSQL settings
+	flagSet.StringVarP(&opts.postgresUser, "user", "u", "", "user used for initdb")
+	flagSet.StringVarP(&opts.targetPostgresVersion, "version", "v", "", "target postgres major version. For example: 14, 15, 16, etc..")
+	flagSet.StringVar(&opts.currentPostgresVersion, "current-version", "", "current version of all postgres databases. Optional, will attempt auto discovery for every database if left empty. For example: 9.6, 14, 15, 16, etc..")
+	flagSet.StringVarP(&opts.extraInitDBArgs, "extra-initdb-args", "i", "", "provide any additional arguments for init-db, used for every database in addition to the auto detected arguments.")
+
+	// Disk settings
+	flagSet.StringVar(&opts.newPVCDiskSize, "size", "", "New size of every volume. Uses the size of the current volume if left empty. Example: 10G")
+	flagSet.StringVar(&opts.subPath, "subpath", "", "subpath used for mounting the pvc")
+
+	// Hooks
+	addHookFlags(flagSet, opts)
+
+	// Jobs
+	addJobFlags(flagSet, opts)
+
+	// GitOps
+	addGitOpsFlags(flagSet, opts)
+
+	// Checks
+	flagSet.BoolVar(&opts.checkPermissions, "check-permissions", true, "Check with SelfSubjectAccessReviews that all permissions the upgrade needs are granted before changing anything, and print a table of the missing permissions.")
+	flagSet.BoolVar(&opts.checkExtensions, "check-extensions", false, "Check that all installed extensions and shared_preload_libraries are available in the upgrade image and the target image before migrating any data.")
+	flagSet.BoolVar(&opts.verifyData, "verify-data", false, "Compare the tables, row counts and sequence values of the old and the new cluster after pg_upgrade. Any difference fails the upgrade before the volumes are swapped.")
+	flagSet.BoolVar(&opts.verifyChecksums, "verify-checksums", false, "Also compare a checksum of the contents of every table. Implies --verify-data. Reads all data of both clusters, which can take a long time for large databases.")
+	flagSet.StringVar(&opts.targetImage, "target-image", "", "Postgres image the databases will run with after the upgrade. Used by --check-extensions. For example: docker.io/bitnami/postgresql:16.4.0")
+
+	// Other
+	flagSet.DurationVar(&opts.timeout, "timeout", 0*time.Second, "The length of time to wait for the whole batch before giving up, zero means infinite")
+	flagSet.StringVar(&opts.logDir, "log-dir", "", "Local directory the full logs of every upgrade pod are written to, one file per pod.")
+	flagSet.DurationVar(&opts.stuckTimeout, "stuck-timeout", podrunner.DefaultStuckTimeout, "How long an upgrade pod may be unschedulable or wait for its volumes before the upgrade fails. Image pull and configuration errors fail immediately.")
+	addOutputFlag(flagSet, opts)
+	addMetricsFlags(flagSet, opts)
+	flagSet.BoolVar(&opts.keepOnFailure, "keep-on-failure", false, "Keep failed upgrade pods, the scripts secret and the temporary volume for debugging, and print the kubectl commands to inspect them.")
+}
+
+//go:embed examples/batch.txt
+var pgUpgradeBatchExamples string
+
+// NewUpgradePostgresBatchCmd
+func NewUpgradePostgresBatchCmd(runOptions *postgresPGUpgradeOptions) *cobra.Command {
+	if runOptions == nil {
+		runOptions = newPostgresPGUpgradeOptions()
+	}
+
+	var cmd = &cobra.Command{
+		Use:     "batch",
+		Args:    cobra.NoArgs,
+		Short:   "Upgrade every statefulset matching a label selector",
+		Long:    "Upgrade every statefulset matching a label selector, in the current namespace or all namespaces. A failed upgrade does not stop the batch, the results of all upgrades are printed once the batch is done.",
+		Example: pgUpgradeBatchExamples,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+
+			if runOptions.timeout > 0 {
+				timeoutctx, cancelTimeout := context.WithTimeoutCause(ctx, runOptions.timeout, fmt.Errorf("batch did not complete within configured timeout (%s)", runOptions.timeout.String()))
+				defer cancelTimeout()
+				ctx = timeoutctx
+			}
+
+			ctx, err := runOptions.withReporter(ctx)
+			if err != nil {
+				return err
+			}
+			settings, err := runOptions.toSettings()
+			if err != nil {
+				return err
+			}
+			settings.Logger = logging.FromContext(ctx)
+			if err := settings.Validate(); err != nil {
+				return err
+			}
+
+			clientOptions := kubeclient.FromContext(ctx)
+			k8sClient, namespace, err := clientOptions.NewClientAndNamespace()
+			if err != nil {
+				return err
+			}
+			settings.DynamicClient, err = clientOptions.NewDynamicClient()
+			if err != nil {
+				return err
+			}
+			if runOptions.namespace != "" {
+				namespace = runOptions.namespace
+			}
+			if runOptions.allNamespaces {
+				namespace = ""
+			}
+			targets, err := pgupgrade.FindStatefulSets(ctx, k8sClient, namespace, runOptions.selector)
+			if err != nil {
+				return err
+			}
+			if len(targets) == 0 {
+				return fmt.Errorf("no statefulsets match selector %q", runOptions.selector)
+			}
+			for i := range targets {
+				targets[i].Settings = settings
+			}
+			progress.Printf(ctx, "upgrading %d statefulsets to postgres %s\n", len(targets), settings.TargetPostgresVersion)
+
+			registry := runOptions.newMetricsRegistry()
+			results := pgupgrade.RunBatch(ctx, k8sClient, targets, pgupgrade.BatchOptions{
+				Concurrency:        runOptions.concurrency,
+				PerDatabaseTimeout: runOptions.perDatabaseTimeout,
+				WithTarget: func(ctx context.Context, target pgupgrade.BatchTarget) context.Context {
+					return withMetricsReporter(ctx, registry)
+				},
+			})
+			pgupgrade.PrintBatchResults(ctx, results)
+			return errors.Join(pgupgrade.BatchError(results), runOptions.writeMetrics(ctx, registry))
+		},
+	}
+
+	AddPostgresBatchUpgradeFlags(cmd.Flags(), runOptions)
+
+	cmd.MarkFlagRequired("version")
+	cmd.MarkFlagRequired("selector")
+	return cmd
+}
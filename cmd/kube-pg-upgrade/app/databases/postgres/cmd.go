@@ -11,13 +11,13 @@ func NewPostgresCmd() *cobra.Command {
 		Short:   "Perform a PG upgrade PostgreSQL running in Kubernetes",
 		Long:    "Perform a PG upgrade PostgreSQL running in Kubernetes",
 		Aliases: []string{"pg_upgrade", "pg-upgrade", "upgrade"},
-		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			return nil
-		},
 	}
 
 	cmds.ResetFlags()
 	cmds.AddCommand(NewUpgradePostgresStatefulSetCmd(nil))
 	cmds.AddCommand(NewUpgradePostgresPVCCmd(nil))
+	cmds.AddCommand(NewUpgradePostgresBatchCmd(nil))
+	cmds.AddCommand(NewUpgradePostgresApplyCmd(nil))
+	cmds.AddCommand(NewDebugPostgresCmd(nil))
 	return cmds
 }
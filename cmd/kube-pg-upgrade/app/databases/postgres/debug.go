@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	_ "embed"
+
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/logging"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+)
+
+func AddPostgresDebugFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.StringVarP(&opts.namespace, "namespace", "n", "", "namespace of the postgres instance. Default is the configured namespace in your kubecontext.")
+	addUpgradeImageFlags(flagSet, opts)
+
+	// PostgreSQL settings
+	flagSet.StringVarP(&opts.postgresUser, "user", "u", "", "user used for initdb")
+	flagSet.StringVarP(&opts.targetPostgresVersion, "version", "v", "", "target postgres major version of the failed upgrade. For example: 14, 15, 16, etc..")
+	flagSet.StringVar(&opts.currentPostgresVersion, "current-version", "", "current version of the postgres database. For example: 9.6, 14, 15, 16, etc..")
+
+	// Disk settings
+	flagSet.StringVar(&opts.subPath, "subpath", "", "subpath used for mounting the pvc")
+
+	// Other
+	flagSet.DurationVar(&opts.stuckTimeout, "stuck-timeout", podrunner.DefaultStuckTimeout, "How long the debug pod may be unschedulable or wait for its volumes before giving up.")
+	addOutputFlag(flagSet, opts)
+}
+
+//go:embed examples/debug.txt
+var pgUpgradeDebugExamples string
+
+// NewDebugPostgresCmd
+func NewDebugPostgresCmd(runOptions *postgresPGUpgradeOptions) *cobra.Command {
+	if runOptions == nil {
+		runOptions = newPostgresPGUpgradeOptions()
+	}
+
+	var cmd = &cobra.Command{
+		Use:     "debug <pvc>",
+		Args:    cobra.ExactArgs(1),
+		Short:   "Start a pod with the data directories of a failed upgrade mounted",
+		Long:    "Start a pod using the upgrade image, with the pvc and the temporary pvc of a failed upgrade mounted on the same paths as during pg_upgrade. The pod sleeps until it is deleted.",
+		Example: pgUpgradeDebugExamples,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := runOptions.withReporter(cmd.Context())
+			if err != nil {
+				return err
+			}
+			settings, err := runOptions.toSettings()
+			if err != nil {
+				return err
+			}
+			settings.SourcePVCName = args[0]
+			settings.Logger = logging.FromContext(ctx)
+			upgrader, err := pgupgrade.NewPGUpgradeRunner(runOptions.namespace, kubeclient.FromContext(ctx), settings)
+			if err != nil {
+				return err
+			}
+			_, err = upgrader.RunDebugPod(ctx)
+			return err
+		},
+	}
+
+	AddPostgresDebugFlags(cmd.Flags(), runOptions)
+
+	cmd.MarkFlagRequired("version")
+	cmd.MarkFlagRequired("current-version")
+	return cmd
+}
@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/metrics"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+	flag "github.com/spf13/pflag"
+)
+
+// pushTimeout limits how long pushing the metrics may take once the upgrade is done
+const pushTimeout = 30 * time.Second
+
+func addMetricsFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.StringVar(&opts.metricsTextfile, "metrics-textfile", "", "Write the metrics of the upgrade to this file in the Prometheus text format, for the node-exporter textfile collector. The file name must end with .prom to be collected.")
+	flagSet.StringVar(&opts.pushgatewayURL, "pushgateway-url", "", "Push the metrics of the upgrade to this Pushgateway once the upgrade is done. For example: http://pushgateway.monitoring:9091")
+	flagSet.StringVar(&opts.pushgatewayJob, "pushgateway-job", "kube-pg-upgrade", "Job name the metrics are pushed under, the metrics previously pushed for this job are replaced.")
+}
+
+// withMetrics returns a context recording the metrics of the upgrade, when a metrics output is configured.
+// The returned registry is nil otherwise.
+func (o *postgresPGUpgradeOptions) withMetrics(ctx context.Context) (context.Context, *metrics.Registry) {
+	registry := o.newMetricsRegistry()
+	return withMetricsReporter(ctx, registry), registry
+}
+
+// newMetricsRegistry returns a registry for the metrics of the upgrades, or nil when no metrics output is configured
+func (o *postgresPGUpgradeOptions) newMetricsRegistry() *metrics.Registry {
+	if o.metricsTextfile == "" && o.pushgatewayURL == "" {
+		return nil
+	}
+	return metrics.NewRegistry()
+}
+
+// withMetricsReporter returns a context recording the metrics of a single upgrade in registry, if set
+func withMetricsReporter(ctx context.Context, registry *metrics.Registry) context.Context {
+	if registry == nil {
+		return ctx
+	}
+	return progress.WithReporter(ctx, progress.Tee{progress.FromContext(ctx), registry.NewReporter()})
+}
+
+// writeMetrics writes the recorded metrics to the configured textfile and Pushgateway
+func (o *postgresPGUpgradeOptions) writeMetrics(ctx context.Context, registry *metrics.Registry) error {
+	if registry == nil {
+		return nil
+	}
+	var errs []error
+	if o.metricsTextfile != "" {
+		errs = append(errs, registry.WriteTextfile(o.metricsTextfile))
+	}
+	if o.pushgatewayURL != "" {
+		// the upgrade context may have timed out already
+		pushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pushTimeout)
+		defer cancel()
+		errs = append(errs, registry.Push(pushCtx, nil, o.pushgatewayURL, o.pushgatewayJob))
+	}
+	return errors.Join(errs...)
+}
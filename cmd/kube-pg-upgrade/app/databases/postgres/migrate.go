@@ -0,0 +1,130 @@
+package postgres
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/logging"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+)
+
+// NewMigrateCmd returns cobra.Command to run the kube-pg-upgrade migrate subcommand
+func NewMigrateCmd() *cobra.Command {
+	cmds := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate a PostgreSQL statefulset to an operator",
+		Long:  "Migrate a PostgreSQL statefulset to an operator, upgrading its data on the way",
+	}
+
+	cmds.ResetFlags()
+	cmds.AddCommand(NewMigrateCNPGCmd(nil))
+	return cmds
+}
+
+func AddPostgresCNPGMigrationFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.StringVarP(&opts.namespace, "namespace", "n", "", "namespace of the postgres instance. Default is the configured namespace in your kubecontext.")
+	addUpgradeImageFlags(flagSet, opts)
+
+	// PostgreSQL settings
+	flagSet.StringVarP(&opts.targetPostgresVersion, "version", "v", "", "target postgres major version. For example: 14, 15, 16, etc..")
+	flagSet.StringVar(&opts.currentPostgresVersion, "current-version", "", "current version of the postgres database. Optional, will attempt auto discovery if left empty. For example: 9.6, 14, 15, 16, etc..")
+	flagSet.StringVarP(&opts.extraInitDBArgs, "extra-initdb-args", "i", "", "provide any additional arguments for init-db. Use the same arguments that were provided when the database was originally created. See https://www.postgresql.org/docs/current/pgupgrade.html. Otherwise will attempt to auto detect.")
+
+	// Disk settings
+	flagSet.StringVar(&opts.newPVCDiskSize, "size", "", "New size. Example: 10G")
+	flagSet.StringVar(&opts.subPath, "subpath", "", "subpath used for mounting the pvc")
+	flagSet.StringVar(&opts.sourcePVCName, "source-pvc-name", "", "The name of the Persistent Volume Claim with the current postgres data. Optional, will attempt auto discovery if left empty.")
+
+	// CloudNativePG
+	flagSet.StringVar(&opts.cnpgMigration.ClusterName, "cluster-name", "", "Name of the CloudNativePG Cluster. Defaults to the name of the statefulset.")
+	flagSet.IntVar(&opts.cnpgMigration.Instances, "instances", 1, "Number of instances of the Cluster.")
+	flagSet.StringVar(&opts.cnpgMigration.ImageName, "image-name", "", "PostgreSQL image of the Cluster. Defaults to the CloudNativePG image of the target version.")
+	flagSet.StringVar(&opts.cnpgMigration.VolumeSnapshotClass, "volume-snapshot-class", "", "VolumeSnapshotClass used for the snapshot of the upgraded volume. Defaults to the default class of the cluster.")
+	flagSet.StringVarP(&opts.cnpgMigration.ManifestFile, "filename", "f", "", "File the manifests of the Cluster and the secrets with its credentials are written to.")
+	flagSet.BoolVar(&opts.cnpgMigration.Apply, "apply", false, "Create the Cluster and the secrets with its credentials.")
+
+	// Hooks
+	addHookFlags(flagSet, opts)
+
+	// Jobs
+	addJobFlags(flagSet, opts)
+
+	// GitOps
+	addGitOpsFlags(flagSet, opts)
+
+	// Checks
+	flagSet.BoolVar(&opts.checkPermissions, "check-permissions", true, "Check with SelfSubjectAccessReviews that all permissions the upgrade needs are granted before changing anything, and print a table of the missing permissions.")
+	flagSet.BoolVar(&opts.checkExtensions, "check-extensions", false, "Check that all installed extensions and shared_preload_libraries are available in the upgrade image and the target image before migrating any data.")
+	flagSet.BoolVar(&opts.verifyData, "verify-data", false, "Compare the tables, row counts and sequence values of the old and the new cluster after pg_upgrade. Any difference fails the upgrade before the Cluster is created.")
+	flagSet.BoolVar(&opts.verifyChecksums, "verify-checksums", false, "Also compare a checksum of the contents of every table. Implies --verify-data. Reads all data of both clusters, which can take a long time for large databases.")
+
+	// Other
+	flagSet.DurationVar(&opts.timeout, "timeout", 0*time.Second, "The length of time to wait before giving up, zero means infinite")
+	flagSet.StringVar(&opts.logDir, "log-dir", "", "Local directory the full logs of every upgrade pod are written to, one file per pod.")
+	flagSet.DurationVar(&opts.stuckTimeout, "stuck-timeout", podrunner.DefaultStuckTimeout, "How long an upgrade pod may be unschedulable or wait for its volumes before the upgrade fails. Image pull and configuration errors fail immediately.")
+	addOutputFlag(flagSet, opts)
+	addMetricsFlags(flagSet, opts)
+	flagSet.BoolVar(&opts.keepOnFailure, "keep-on-failure", false, "Keep failed upgrade pods, the scripts secret and the temporary volume for debugging, and print the kubectl commands to inspect them.")
+}
+
+//go:embed examples/migrate-cnpg.txt
+var migrateCNPGExamples string
+
+// NewMigrateCNPGCmd
+func NewMigrateCNPGCmd(runOptions *postgresPGUpgradeOptions) *cobra.Command {
+	if runOptions == nil {
+		runOptions = newPostgresPGUpgradeOptions()
+	}
+
+	var cmd = &cobra.Command{
+		Use:     "cnpg <statefulset>",
+		Args:    cobra.ExactArgs(1),
+		Aliases: []string{"cloudnative-pg"},
+		Short:   "Upgrade a statefulset into a CloudNativePG Cluster",
+		Long: `Upgrade the data of a statefulset into a new pvc, in the layout of CloudNativePG, and create a CloudNativePG Cluster bootstrapped from a VolumeSnapshot of it. The credentials are taken from the secrets of the statefulset.
+
+The statefulset is left scaled down with its original pvc and data, scale it up again to roll back.`,
+		Example: migrateCNPGExamples,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+
+			if runOptions.timeout > 0 {
+				timeoutctx, cancelTimeout := context.WithTimeoutCause(ctx, runOptions.timeout, fmt.Errorf("migration did not complete within configured timeout (%s)", runOptions.timeout.String()))
+				defer cancelTimeout()
+				ctx = timeoutctx
+			}
+
+			ctx, err := runOptions.withReporter(ctx)
+			if err != nil {
+				return err
+			}
+			settings, err := runOptions.toSettings()
+			if err != nil {
+				return err
+			}
+			settings.Logger = logging.FromContext(ctx)
+			upgrader, err := pgupgrade.NewPGUpgradeRunner(runOptions.namespace, kubeclient.FromContext(ctx), settings)
+			if err != nil {
+				return err
+			}
+			ctx, registry := runOptions.withMetrics(ctx)
+			err = upgrader.RunCNPGMigration(ctx, args[0], runOptions.cnpgMigration)
+			return errors.Join(err, runOptions.writeMetrics(ctx, registry))
+		},
+	}
+
+	AddPostgresCNPGMigrationFlags(cmd.Flags(), runOptions)
+
+	cmd.MarkFlagRequired("version")
+	cmd.MarkFlagsOneRequired("filename", "apply")
+
+	return cmd
+}
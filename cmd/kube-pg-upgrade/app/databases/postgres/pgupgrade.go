@@ -3,10 +3,20 @@ package postgres
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/containerinfra/kube-pg-upgrade/cmd/kube-pg-upgrade/app/logging"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/gitops"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/imagemirror"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
 	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/ptrs"
 	"github.com/spf13/cobra"
 	flag "github.com/spf13/pflag"
 )
@@ -14,11 +24,35 @@ import (
 type postgresPGUpgradeOptions struct {
 	namespace string
 
-	upgradeImage string
+	upgradeImage         string
+	upgradeImageTemplate string
+	upgradeImageDistro   string
+	upgradeCommand       string
+	pgBinOld             string
+	pgBinNew             string
+	pgDataOld            string
+	pgDataNew            string
+
+	// imageMirrorConfig is the path to a config file that maps images to registry mirrors
+	imageMirrorConfig string
+	imagePullSecrets  []string
 
 	extraInitDBArgs string
 	newPVCDiskSize  string
 	timeout         time.Duration
+	// stuckTimeout is how long an upgrade pod may be pending before the upgrade fails
+	stuckTimeout time.Duration
+	// output is the format of the progress output, text or json
+	output string
+
+	// metricsTextfile is the node-exporter textfile the metrics are written to
+	metricsTextfile string
+	// pushgatewayURL and pushgatewayJob configure pushing the metrics to a Pushgateway
+	pushgatewayURL string
+	pushgatewayJob string
+
+	// keepOnFailure leaves failed upgrade pods and volumes in place for debugging
+	keepOnFailure bool
 
 	// nextPostgresVersion is the current postgres version of the database
 	// Will attempt auto detection if empty
@@ -34,15 +68,166 @@ type postgresPGUpgradeOptions struct {
 	sourcePVCName string
 	targetPVCName string
 	subPath       string
+
+	checkExtensions bool
+	targetImage     string
+	verifyData      bool
+	verifyChecksums bool
+
+	// checkPermissions reviews the permissions required by the upgrade before it starts
+	checkPermissions bool
+
+	// helmValuesFile is the file the values for the next helm upgrade are written to
+	helmValuesFile string
+
+	// suspendGitOps suspends the sync of the Argo CD Applications and Flux objects of the statefulset
+	suspendGitOps   bool
+	argoCDNamespace string
+
+	preHooks  []string
+	postHooks []string
+
+	logDir string
+
+	// selector, allNamespaces, concurrency and perDatabaseTimeout configure a batch upgrade
+	selector           string
+	allNamespaces      bool
+	concurrency        int
+	perDatabaseTimeout time.Duration
+
+	// planFile is the upgrade plan of the apply command
+	planFile string
+
+	// cnpgMigration configures the migrate cnpg command
+	cnpgMigration pgupgrade.CNPGMigration
+
+	useJobs           bool
+	jobBackoffLimit   int32
+	jobActiveDeadline time.Duration
+	jobTTL            time.Duration
 }
 
 func newPostgresPGUpgradeOptions() *postgresPGUpgradeOptions {
 	return &postgresPGUpgradeOptions{}
 }
 
+func (o *postgresPGUpgradeOptions) toSettings() (pgupgrade.PGUpgradeSettings, error) {
+	settings := pgupgrade.PGUpgradeSettings{
+		UpgradeImage:         o.upgradeImage,
+		UpgradeImageTemplate: o.upgradeImageTemplate,
+		UpgradeImageDistro:   o.upgradeImageDistro,
+		UpgradeCommand:       strings.Fields(o.upgradeCommand),
+		ImagePullSecrets:     o.imagePullSecrets,
+
+		PGBinOld:  o.pgBinOld,
+		PGBinNew:  o.pgBinNew,
+		PGDataOld: o.pgDataOld,
+		PGDataNew: o.pgDataNew,
+
+		InitDBUser:             o.postgresUser,
+		CurrentPostgresVersion: o.currentPostgresVersion,
+		TargetPostgresVersion:  o.targetPostgresVersion,
+		InitDBArgs:             o.extraInitDBArgs,
+
+		DiskSize:      o.newPVCDiskSize,
+		TargetPVCName: o.targetPVCName,
+		SourcePVCName: o.sourcePVCName,
+		SubPath:       o.subPath,
+
+		CheckExtensions: o.checkExtensions,
+		TargetImage:     o.targetImage,
+		HelmValuesFile:  o.helmValuesFile,
+		VerifyData:      o.verifyData || o.verifyChecksums,
+		VerifyChecksums: o.verifyChecksums,
+
+		PreHooks:  newHooks(o.preHooks),
+		PostHooks: newHooks(o.postHooks),
+
+		LogDir:        o.logDir,
+		StuckTimeout:  o.stuckTimeout,
+		KeepOnFailure: o.keepOnFailure,
+
+		UseJobs: o.useJobs,
+		JobOptions: podrunner.JobOptions{
+			BackoffLimit: o.jobBackoffLimit,
+		},
+	}
+	if o.jobActiveDeadline > 0 {
+		settings.JobOptions.ActiveDeadlineSeconds = ptrs.Int64(int64(o.jobActiveDeadline.Seconds()))
+	}
+	if o.jobTTL > 0 {
+		settings.JobOptions.TTLSecondsAfterFinished = ptrs.Int32(int32(o.jobTTL.Seconds()))
+	}
+
+	if o.imageMirrorConfig != "" {
+		mirrors, err := imagemirror.LoadConfig(o.imageMirrorConfig)
+		if err != nil {
+			return settings, err
+		}
+		settings.ImageMirrors = mirrors
+	}
+	settings.SuspendGitOps = o.suspendGitOps
+	settings.CheckPermissions = o.checkPermissions
+	settings.ArgoCDNamespace = o.argoCDNamespace
+	return settings, nil
+}
+
+func newHooks(sources []string) []pgupgrade.Hook {
+	hooks := make([]pgupgrade.Hook, 0, len(sources))
+	for _, source := range sources {
+		hooks = append(hooks, pgupgrade.Hook{Source: source})
+	}
+	return hooks
+}
+
+func addHookFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.StringArrayVar(&opts.preHooks, "pre-hook", nil, "Script executed after the embedded prepare script, with the old and new data directories mounted on /old and /new. Either a local file or configmap:<name>/<key>. Rendered as Go template with the upgrade settings. Can be repeated, hooks run in order.")
+	flagSet.StringArrayVar(&opts.postHooks, "post-hook", nil, "Script executed after the embedded post upgrade script, with the upgraded data directory mounted on /new. Either a local file or configmap:<name>/<key>. Rendered as Go template with the upgrade settings. Can be repeated, hooks run in order.")
+}
+
+func addJobFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.BoolVar(&opts.useJobs, "use-jobs", false, "Run the upgrade steps as batch/v1 Jobs instead of bare pods, so they are retried after evictions and a record is kept in the cluster. A running job is adopted when the upgrade is restarted.")
+	flagSet.Int32Var(&opts.jobBackoffLimit, "job-backoff-limit", 2, "Number of retries of a job before the upgrade fails. Requires --use-jobs.")
+	flagSet.DurationVar(&opts.jobActiveDeadline, "job-active-deadline", 0*time.Second, "Maximum duration of a job including retries, zero means no deadline. Requires --use-jobs.")
+	flagSet.DurationVar(&opts.jobTTL, "job-ttl", 24*time.Hour, "Time after which finished jobs are removed from the cluster, zero means jobs are kept. Requires --use-jobs.")
+}
+
+func addGitOpsFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.BoolVar(&opts.suspendGitOps, "suspend-gitops", true, "Suspend the sync of the Argo CD Application and the Flux HelmRelease and Kustomization of the statefulset during the upgrade, so they do not scale it back up. The sync is resumed afterwards, also when the upgrade fails.")
+	flagSet.StringVar(&opts.argoCDNamespace, "argocd-namespace", gitops.DefaultArgoCDNamespace, "Namespace of the Argo CD Applications.")
+}
+
+func addOutputFlag(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.StringVarP(&opts.output, "output", "o", "text", "Output format, text or json. With json every progress event, such as the start and end of a phase and the log lines of the upgrade pods, is written to stdout as a single line of JSON.")
+}
+
+// withReporter returns a context reporting progress in the configured output format
+func (o *postgresPGUpgradeOptions) withReporter(ctx context.Context) (context.Context, error) {
+	switch o.output {
+	case "", "text":
+		return progress.WithReporter(ctx, progress.NewHumanReporter(os.Stdout)), nil
+	case "json":
+		return progress.WithReporter(ctx, progress.NewJSONReporter(os.Stdout)), nil
+	}
+	return ctx, fmt.Errorf("unsupported output format %q, must be text or json", o.output)
+}
+
+func addUpgradeImageFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
+	flagSet.StringVar(&opts.upgradeImage, "upgrade-image", "tianon/postgres-upgrade", "Container image used to run pg_upgrade.")
+	flagSet.StringVar(&opts.upgradeImageTemplate, "upgrade-image-template", pgupgrade.DefaultUpgradeImageTemplate, "Go template for the upgrade image reference. Available placeholders: {{ .Image }}, {{ .From }}, {{ .To }} and {{ .Distro }}.")
+	flagSet.StringVar(&opts.upgradeImageDistro, "upgrade-image-distro", "", "Distribution of the upgrade image, available as {{ .Distro }} in the templates. For example: bookworm, alpine")
+	flagSet.StringVar(&opts.upgradeCommand, "upgrade-command", strings.Join(pgupgrade.DefaultUpgradeCommand, " "), "Command running pg_upgrade in the upgrade image, including the initdb of the new cluster. Replaces the entrypoint of the image, set it to the entrypoint and command of images not based on tianon/postgres-upgrade.")
+	flagSet.StringVar(&opts.pgBinOld, "pgbin-old", "", "Location of the old postgres binaries in the upgrade image (PGBINOLD). Supports the same placeholders as --upgrade-image-template. Uses the image default if left empty.")
+	flagSet.StringVar(&opts.pgBinNew, "pgbin-new", "", "Location of the new postgres binaries in the upgrade image (PGBINNEW). Supports the same placeholders as --upgrade-image-template. Uses the image default if left empty.")
+	flagSet.StringVar(&opts.pgDataOld, "pgdata-old", pgupgrade.DefaultPGDataOldTemplate, "Path the old data directory is mounted on in the upgrade container (PGDATAOLD). Supports the same placeholders as --upgrade-image-template.")
+	flagSet.StringVar(&opts.pgDataNew, "pgdata-new", pgupgrade.DefaultPGDataNewTemplate, "Path the new data directory is mounted on in the upgrade container (PGDATANEW). Supports the same placeholders as --upgrade-image-template.")
+	flagSet.StringVar(&opts.imageMirrorConfig, "image-mirror-config", "", "Path to a YAML file mapping image prefixes to registry mirrors, with optional digest pinning. Applied to every image used during the upgrade.")
+	flagSet.StringSliceVar(&opts.imagePullSecrets, "image-pull-secret", nil, "Name of an image pull secret added to the upgrade pods. Can be repeated.")
+}
+
 func AddPostgresStatefulSetUpgradeFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
 	flagSet.StringVarP(&opts.namespace, "namespace", "n", "", "namespace of the postgres instance. Default is the configured namespace in your kubecontext.")
-	flagSet.StringVar(&opts.upgradeImage, "upgrade-image", "tianon/postgres-upgrade", "Container image used to run pg_upgrade.")
+	addUpgradeImageFlags(flagSet, opts)
 
 	// PostgreSQL settings
 	flagSet.StringVarP(&opts.postgresUser, "user", "u", "", "user used for initdb")
@@ -56,13 +241,37 @@ func AddPostgresStatefulSetUpgradeFlags(flagSet *flag.FlagSet, opts *postgresPGU
 	flagSet.StringVar(&opts.sourcePVCName, "source-pvc-name", "", "The name of the Persistent Volume Claim with the current postgres data. Optional, will attempt auto discovery if left empty.")
 	flagSet.StringVar(&opts.targetPVCName, "target-pvc-name", "", "Target name of Persistent Volume Claim that will serve as the target for the upgraded postgres data. This is an optional setting, will use the source PVC name by default.")
 
+	// Hooks
+	addHookFlags(flagSet, opts)
+
+	// Jobs
+	addJobFlags(flagSet, opts)
+
+	// Checks
+	flagSet.BoolVar(&opts.checkPermissions, "check-permissions", true, "Check with SelfSubjectAccessReviews that all permissions the upgrade needs are granted before changing anything, and print a table of the missing permissions.")
+	flagSet.BoolVar(&opts.checkExtensions, "check-extensions", false, "Check that all installed extensions and shared_preload_libraries are available in the upgrade image and the target image before migrating any data.")
+	flagSet.BoolVar(&opts.verifyData, "verify-data", false, "Compare the tables, row counts and sequence values of the old and the new cluster after pg_upgrade. Any difference fails the upgrade before the volumes are swapped.")
+	flagSet.BoolVar(&opts.verifyChecksums, "verify-checksums", false, "Also compare a checksum of the contents of every table. Implies --verify-data. Reads all data of both clusters, which can take a long time for large databases.")
+	flagSet.StringVar(&opts.targetImage, "target-image", "", "Postgres image the database will run with after the upgrade. Used by --check-extensions, and its tag is used in the helm values when the statefulset is managed by Helm. For example: docker.io/bitnami/postgresql:16.4.0")
+
+	// GitOps
+	addGitOpsFlags(flagSet, opts)
+
+	// Helm
+	flagSet.StringVar(&opts.helmValuesFile, "helm-values-file", "", "When the statefulset is managed by a Helm release, write the values for the next helm upgrade, with the new image tag and the existing claim, to this file.")
+
 	// Other
 	flagSet.DurationVar(&opts.timeout, "timeout", 0*time.Second, "The length of time to wait before giving up, zero means infinite")
+	flagSet.StringVar(&opts.logDir, "log-dir", "", "Local directory the full logs of every upgrade pod are written to, one file per pod.")
+	flagSet.DurationVar(&opts.stuckTimeout, "stuck-timeout", podrunner.DefaultStuckTimeout, "How long an upgrade pod may be unschedulable or wait for its volumes before the upgrade fails. Image pull and configuration errors fail immediately.")
+	addOutputFlag(flagSet, opts)
+	addMetricsFlags(flagSet, opts)
+	flagSet.BoolVar(&opts.keepOnFailure, "keep-on-failure", false, "Keep failed upgrade pods, the scripts secret and the temporary volume for debugging, and print the kubectl commands to inspect them.")
 }
 
 func AddPostgresPVCUpgradeFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOptions) {
 	flagSet.StringVarP(&opts.namespace, "namespace", "n", "", "namespace of the postgres instance. Default is the configured namespace in your kubecontext.")
-	flagSet.StringVar(&opts.upgradeImage, "upgrade-image", "tianon/postgres-upgrade", "Container image used to run pg_upgrade.")
+	addUpgradeImageFlags(flagSet, opts)
 
 	// PostgreSQL settings
 	flagSet.StringVarP(&opts.postgresUser, "user", "u", "", "user used for initdb")
@@ -75,8 +284,26 @@ func AddPostgresPVCUpgradeFlags(flagSet *flag.FlagSet, opts *postgresPGUpgradeOp
 	flagSet.StringVar(&opts.subPath, "subpath", "", "subpath used for mounting the pvc")
 	flagSet.StringVar(&opts.targetPVCName, "target-pvc-name", "", "Target name of Persistent Volume Claim that will serve as the target for the upgraded postgres data. This is an optional setting, will use the source PVC name by default.")
 
+	// Hooks
+	addHookFlags(flagSet, opts)
+
+	// Jobs
+	addJobFlags(flagSet, opts)
+
+	// Checks
+	flagSet.BoolVar(&opts.checkPermissions, "check-permissions", true, "Check with SelfSubjectAccessReviews that all permissions the upgrade needs are granted before changing anything, and print a table of the missing permissions.")
+	flagSet.BoolVar(&opts.checkExtensions, "check-extensions", false, "Check that all installed extensions and shared_preload_libraries are available in the upgrade image and the target image before migrating any data.")
+	flagSet.BoolVar(&opts.verifyData, "verify-data", false, "Compare the tables, row counts and sequence values of the old and the new cluster after pg_upgrade. Any difference fails the upgrade before the volumes are swapped.")
+	flagSet.BoolVar(&opts.verifyChecksums, "verify-checksums", false, "Also compare a checksum of the contents of every table. Implies --verify-data. Reads all data of both clusters, which can take a long time for large databases.")
+	flagSet.StringVar(&opts.targetImage, "target-image", "", "Postgres image the database will run with after the upgrade. Used by --check-extensions. For example: docker.io/bitnami/postgresql:16.4.0")
+
 	// Other
 	flagSet.DurationVar(&opts.timeout, "timeout", 0*time.Second, "The length of time to wait before giving up, zero means infinite")
+	flagSet.StringVar(&opts.logDir, "log-dir", "", "Local directory the full logs of every upgrade pod are written to, one file per pod.")
+	flagSet.DurationVar(&opts.stuckTimeout, "stuck-timeout", podrunner.DefaultStuckTimeout, "How long an upgrade pod may be unschedulable or wait for its volumes before the upgrade fails. Image pull and configuration errors fail immediately.")
+	addOutputFlag(flagSet, opts)
+	addMetricsFlags(flagSet, opts)
+	flagSet.BoolVar(&opts.keepOnFailure, "keep-on-failure", false, "Keep failed upgrade pods, the scripts secret and the temporary volume for debugging, and print the kubectl commands to inspect them.")
 }
 
 //go:embed examples/upgrade.txt
@@ -105,23 +332,22 @@ func NewUpgradePostgresStatefulSetCmd(runOptions *postgresPGUpgradeOptions) *cob
 				ctx = timeoutctx
 			}
 
-			upgrader, err := pgupgrade.NewPGUpgradeRunner(runOptions.namespace, pgupgrade.PGUpgradeSettings{
-				UpgradeImage: runOptions.upgradeImage,
-
-				InitDBUser:             runOptions.postgresUser,
-				CurrentPostgresVersion: runOptions.currentPostgresVersion,
-				TargetPostgresVersion:  runOptions.targetPostgresVersion,
-				InitDBArgs:             runOptions.extraInitDBArgs,
-
-				DiskSize:      runOptions.newPVCDiskSize,
-				TargetPVCName: runOptions.targetPVCName,
-				SourcePVCName: runOptions.sourcePVCName,
-				SubPath:       runOptions.subPath,
-			})
+			ctx, err := runOptions.withReporter(ctx)
+			if err != nil {
+				return err
+			}
+			settings, err := runOptions.toSettings()
+			if err != nil {
+				return err
+			}
+			settings.Logger = logging.FromContext(ctx)
+			upgrader, err := pgupgrade.NewPGUpgradeRunner(runOptions.namespace, kubeclient.FromContext(ctx), settings)
 			if err != nil {
 				return err
 			}
-			return upgrader.RunPGUpgradeForDatabaseStatefulSet(ctx, args[0])
+			ctx, registry := runOptions.withMetrics(ctx)
+			err = upgrader.RunPGUpgradeForDatabaseStatefulSet(ctx, args[0])
+			return errors.Join(err, runOptions.writeMetrics(ctx, registry))
 		},
 	}
 
@@ -158,23 +384,23 @@ func NewUpgradePostgresPVCCmd(runOptions *postgresPGUpgradeOptions) *cobra.Comma
 				ctx = timeoutctx
 			}
 
-			upgrader, err := pgupgrade.NewPGUpgradeRunner(runOptions.namespace, pgupgrade.PGUpgradeSettings{
-				UpgradeImage: runOptions.upgradeImage,
-
-				InitDBUser:             runOptions.postgresUser,
-				CurrentPostgresVersion: runOptions.currentPostgresVersion,
-				TargetPostgresVersion:  runOptions.targetPostgresVersion,
-				InitDBArgs:             runOptions.extraInitDBArgs,
-
-				DiskSize:      runOptions.newPVCDiskSize,
-				TargetPVCName: runOptions.targetPVCName,
-				SourcePVCName: args[0],
-				SubPath:       runOptions.subPath,
-			})
+			ctx, err := runOptions.withReporter(ctx)
+			if err != nil {
+				return err
+			}
+			settings, err := runOptions.toSettings()
+			if err != nil {
+				return err
+			}
+			settings.Logger = logging.FromContext(ctx)
+			settings.SourcePVCName = args[0]
+			upgrader, err := pgupgrade.NewPGUpgradeRunner(runOptions.namespace, kubeclient.FromContext(ctx), settings)
 			if err != nil {
 				return err
 			}
-			return upgrader.RunPGUpgradeForDatabasePVC(ctx)
+			ctx, registry := runOptions.withMetrics(ctx)
+			err = upgrader.RunPGUpgradeForDatabasePVC(ctx)
+			return errors.Join(err, runOptions.writeMetrics(ctx, registry))
 		},
 	}
 
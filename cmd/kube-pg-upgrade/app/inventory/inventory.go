@@ -0,0 +1,70 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/table"
+)
+
+type inventoryOptions struct {
+	namespace     string
+	allNamespaces bool
+	selector      string
+	// output is the format of the inventory, table or json
+	output string
+}
+
+// NewInventoryCmd returns the Cobra inventory sub command
+func NewInventoryCmd() *cobra.Command {
+	opts := &inventoryOptions{}
+	cmd := &cobra.Command{
+		Use:   "inventory",
+		Args:  cobra.NoArgs,
+		Short: "List the statefulsets and deployments running postgres",
+		Long:  "List the statefulsets and deployments running postgres, with the detected major version and the data volume. The postgres container is found in the same way as during an upgrade.",
+		Example: `# list the postgres databases in all namespaces
+kube-pg-upgrade inventory --all-namespaces
+
+# list the Bitnami postgres databases of a namespace as JSON
+kube-pg-upgrade inventory -n databases -l app.kubernetes.io/name=postgresql -o json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.output != "table" && opts.output != "json" {
+				return fmt.Errorf("unsupported output format %q, must be table or json", opts.output)
+			}
+			k8sClient, namespace, err := kubeclient.FromContext(cmd.Context()).NewClientAndNamespace()
+			if err != nil {
+				return err
+			}
+			if opts.namespace != "" {
+				namespace = opts.namespace
+			}
+			if opts.allNamespaces {
+				namespace = ""
+			}
+
+			items, err := pgupgrade.Inventory(cmd.Context(), k8sClient, namespace, opts.selector)
+			if err != nil {
+				return err
+			}
+			if opts.output == "json" {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(items)
+			}
+			header, rows := pgupgrade.InventoryTable(items)
+			table.Fprint(cmd.OutOrStdout(), header, rows)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "", "Namespace to list. Default is the configured namespace in your kubecontext.")
+	cmd.Flags().BoolVarP(&opts.allNamespaces, "all-namespaces", "A", false, "List the workloads in all namespaces.")
+	cmd.Flags().StringVarP(&opts.selector, "selector", "l", "", "Label selector of the workloads to list. For example: app.kubernetes.io/name=postgresql")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "table", "Output format, table or json.")
+	return cmd
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Options configures the diagnostic logs of the tool
+type Options struct {
+	// Verbosity is 0 for warnings and errors, 1 to include info and 2 or higher to include debug logs
+	Verbosity int
+	// Format is text or json
+	Format string
+}
+
+func AddFlags(flagSet *flag.FlagSet, opts *Options) {
+	flagSet.IntVar(&opts.Verbosity, "verbosity", 0, "Verbosity of the diagnostic logs written to stderr: 0 for warnings and errors, 1 to include info and 2 to include debug logs.")
+	flagSet.StringVar(&opts.Format, "log-format", "text", "Format of the diagnostic logs, text or json.")
+}
+
+// NewLogger returns a logger writing to out in the configured format and verbosity
+func (o *Options) NewLogger(out io.Writer) (*slog.Logger, error) {
+	level := slog.LevelWarn
+	switch {
+	case o.Verbosity >= 2:
+		level = slog.LevelDebug
+	case o.Verbosity == 1:
+		level = slog.LevelInfo
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	switch o.Format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(out, handlerOptions)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(out, handlerOptions)), nil
+	}
+	return nil, fmt.Errorf("unsupported log format %q, must be text or json", o.Format)
+}
+
+type loggerKey struct{}
+
+// NewContext returns a context carrying the logger, for the subcommands to inject into the packages they use
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger of the context, or the default logger when none was set
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -19,7 +19,7 @@ func NewVersionCmd() *cobra.Command {
 		Short: "Print version information",
 		Long:  `version information`,
 		Run: func(cmd *cobra.Command, args []string) {
-			versionpkg.Print()
+			versionpkg.Fprint(cmd.OutOrStdout())
 		},
 	}
 
@@ -0,0 +1,190 @@
+package advisor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+)
+
+// Support status of a major version
+const (
+	StatusSupported = "supported"
+	StatusEOLSoon   = "eol-soon"
+	StatusEOL       = "eol"
+	StatusUnknown   = "unknown"
+)
+
+// BreakingChange is a notable incompatibility introduced in a major version
+type BreakingChange struct {
+	Version string `json:"version"`
+	Change  string `json:"change"`
+}
+
+// Advice is the recommendation for a major version
+type Advice struct {
+	Version string `json:"version"`
+	Status  string `json:"status"`
+	// EOL is the end-of-life date of the version, nil when the version is not in the release table
+	EOL *Date `json:"eol,omitempty"`
+	// Target is the recommended version to upgrade to, empty when there is none
+	Target          string           `json:"target,omitempty"`
+	BreakingChanges []BreakingChange `json:"breakingChanges,omitempty"`
+	// Note explains why there is no target
+	Note string `json:"note,omitempty"`
+}
+
+// WorkloadAdvice is the recommendation for a workload in the inventory
+type WorkloadAdvice struct {
+	pgupgrade.InventoryItem
+	Advice Advice `json:"advice"`
+}
+
+// Advisor compares postgres major versions against a release table
+type Advisor struct {
+	Releases *Releases
+	// Now is the date the end-of-life dates are compared with
+	Now time.Time
+	// EOLWarning is how long before its end-of-life a version is reported as eol-soon
+	EOLWarning time.Duration
+}
+
+// Advise returns the support status of the major version, the recommended target and the breaking changes up to the target.
+// The target is the latest supported major version that has an upgrade image path from version.
+func (a *Advisor) Advise(version string) Advice {
+	advice := Advice{Version: version, Status: StatusUnknown}
+	if version == "" {
+		advice.Note = "the major version could not be detected from the image"
+		return advice
+	}
+	current, err := parseMajorVersion(version)
+	release := a.Releases.Get(version)
+	if err != nil || release == nil {
+		advice.Note = fmt.Sprintf("postgres %s is not in the release table", version)
+		return advice
+	}
+
+	eol := release.EOL
+	advice.EOL = &eol
+	switch {
+	case !a.Now.Before(release.EOL.Time):
+		advice.Status = StatusEOL
+	case a.Now.Add(a.EOLWarning).After(release.EOL.Time):
+		advice.Status = StatusEOLSoon
+	default:
+		advice.Status = StatusSupported
+	}
+
+	var target *Release
+	var targetVersion []int
+	newer := false
+	for i := range a.Releases.Releases {
+		candidate := &a.Releases.Releases[i]
+		candidateVersion, _ := parseMajorVersion(candidate.Version)
+		if compareMajorVersions(candidateVersion, current) <= 0 || a.Now.Before(candidate.Released.Time) {
+			continue
+		}
+		newer = true
+		if !a.Now.Before(candidate.EOL.Time) || !candidate.CanUpgrade(version) {
+			continue
+		}
+		if target == nil || compareMajorVersions(candidateVersion, targetVersion) > 0 {
+			target, targetVersion = candidate, candidateVersion
+		}
+	}
+	switch {
+	case target != nil:
+		advice.Target = target.Version
+		advice.BreakingChanges = a.breakingChanges(current, targetVersion)
+	case newer:
+		advice.Note = fmt.Sprintf("no upgrade image from postgres %s to a supported version, upgrade in multiple steps", version)
+	default:
+		advice.Note = "latest major version"
+	}
+	return advice
+}
+
+// breakingChanges returns the breaking changes of the versions newer than from, up to and including to, oldest first
+func (a *Advisor) breakingChanges(from, to []int) []BreakingChange {
+	releases := []Release{}
+	for _, release := range a.Releases.Releases {
+		version, _ := parseMajorVersion(release.Version)
+		if compareMajorVersions(version, from) > 0 && compareMajorVersions(version, to) <= 0 {
+			releases = append(releases, release)
+		}
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		x, _ := parseMajorVersion(releases[i].Version)
+		y, _ := parseMajorVersion(releases[j].Version)
+		return compareMajorVersions(x, y) < 0
+	})
+
+	changes := []BreakingChange{}
+	for _, release := range releases {
+		for _, change := range release.BreakingChanges {
+			changes = append(changes, BreakingChange{Version: release.Version, Change: change})
+		}
+	}
+	return changes
+}
+
+// AdviseInventory returns the advice for every workload in the inventory
+func (a *Advisor) AdviseInventory(items []pgupgrade.InventoryItem) []WorkloadAdvice {
+	result := make([]WorkloadAdvice, 0, len(items))
+	for _, item := range items {
+		result = append(result, WorkloadAdvice{InventoryItem: item, Advice: a.Advise(item.MajorVersion)})
+	}
+	return result
+}
+
+// AdviceTable returns the header and the rows of a table with the advice for every workload
+func AdviceTable(items []WorkloadAdvice) ([]string, [][]string) {
+	header := []string{"namespace", "kind", "name", "version", "status", "eol", "target", "breaking changes", "note"}
+	rows := make([][]string, 0, len(items))
+	for _, item := range items {
+		eol := ""
+		if item.Advice.EOL != nil {
+			eol = item.Advice.EOL.Format(dateLayout)
+		}
+		breakingChanges := ""
+		if item.Advice.Target != "" {
+			breakingChanges = fmt.Sprint(len(item.Advice.BreakingChanges))
+		}
+		rows = append(rows, []string{
+			item.Namespace,
+			item.Kind,
+			item.Name,
+			item.Advice.Version,
+			item.Advice.Status,
+			eol,
+			item.Advice.Target,
+			breakingChanges,
+			item.Advice.Note,
+		})
+	}
+	return header, rows
+}
+
+// BreakingChangesSummary returns the breaking changes of every distinct upgrade in the advice, one upgrade per paragraph
+func BreakingChangesSummary(items []WorkloadAdvice) string {
+	seen := map[string]bool{}
+	var summary strings.Builder
+	for _, item := range items {
+		advice := item.Advice
+		key := advice.Version + "->" + advice.Target
+		if advice.Target == "" || len(advice.BreakingChanges) == 0 || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if summary.Len() > 0 {
+			summary.WriteString("\n")
+		}
+		fmt.Fprintf(&summary, "notable changes from postgres %s to %s:\n", advice.Version, advice.Target)
+		for _, change := range advice.BreakingChanges {
+			fmt.Fprintf(&summary, "  - [%s] %s\n", change.Version, change.Change)
+		}
+	}
+	return summary.String()
+}
@@ -0,0 +1,110 @@
+package advisor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+)
+
+func newTestAdvisor(t *testing.T) *Advisor {
+	releases, err := DefaultReleases()
+	require.NoError(t, err)
+	return &Advisor{
+		Releases:   releases,
+		Now:        time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+		EOLWarning: 180 * 24 * time.Hour,
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	advisor := newTestAdvisor(t)
+
+	tests := []struct {
+		version string
+		status  string
+		target  string
+		note    string
+	}{
+		{version: "9.6", status: StatusEOL, target: "14"},
+		{version: "11", status: StatusEOL, target: "16"},
+		{version: "12", status: StatusEOL, target: "17"},
+		{version: "14", status: StatusEOLSoon, target: "18"},
+		{version: "16", status: StatusSupported, target: "18"},
+		{version: "18", status: StatusSupported, note: "latest major version"},
+		{version: "9.1", status: StatusUnknown, note: "postgres 9.1 is not in the release table"},
+		{version: "", status: StatusUnknown, note: "the major version could not be detected from the image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			advice := advisor.Advise(tt.version)
+			assert.Equal(t, tt.status, advice.Status)
+			assert.Equal(t, tt.target, advice.Target)
+			assert.Equal(t, tt.note, advice.Note)
+		})
+	}
+}
+
+func TestAdviseWithoutUpgradePath(t *testing.T) {
+	advisor := newTestAdvisor(t)
+	advisor.Now = time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	advice := advisor.Advise("12")
+	assert.Equal(t, StatusEOL, advice.Status)
+	assert.Empty(t, advice.Target, "all versions with an upgrade path from 12 are end-of-life")
+	assert.Contains(t, advice.Note, "upgrade in multiple steps")
+}
+
+func TestAdviseBreakingChanges(t *testing.T) {
+	advisor := newTestAdvisor(t)
+
+	advice := advisor.Advise("16")
+	require.Equal(t, "18", advice.Target)
+	versions := []string{}
+	for _, change := range advice.BreakingChanges {
+		versions = append(versions, change.Version)
+	}
+	assert.Equal(t, []string{"17", "17", "17", "18", "18", "18"}, versions, "the changes of 17 and 18, oldest first")
+}
+
+func TestLoadReleases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "releases.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"releases": [
+		{"version": "16", "released": "2023-09-14", "eol": "2028-11-09"},
+		{"version": "19", "released": "2026-09-24", "eol": "2031-11-13", "upgradeFrom": ["16"], "breakingChanges": ["a change"]}
+	]}`), 0o600))
+
+	releases, err := LoadReleases(path)
+	require.NoError(t, err)
+	advisor := newTestAdvisor(t)
+	advisor.Releases = releases
+	advice := advisor.Advise("16")
+	assert.Equal(t, "19", advice.Target)
+	assert.Equal(t, []BreakingChange{{Version: "19", Change: "a change"}}, advice.BreakingChanges)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"releases": [{"version": "16", "released": "14-09-2023", "eol": "2028-11-09"}]}`), 0o600))
+	_, err = LoadReleases(path)
+	assert.ErrorContains(t, err, "must be formatted as YYYY-MM-DD")
+}
+
+func TestAdviceTable(t *testing.T) {
+	advisor := newTestAdvisor(t)
+	items := advisor.AdviseInventory([]pgupgrade.InventoryItem{
+		{Namespace: "default", Kind: pgupgrade.KindStatefulSet, Name: "database-postgresql", MajorVersion: "14"},
+		{Namespace: "default", Kind: pgupgrade.KindStatefulSet, Name: "other-postgresql", MajorVersion: "14"},
+	})
+
+	header, rows := AdviceTable(items)
+	assert.Len(t, header, 9)
+	assert.Equal(t, []string{"default", "StatefulSet", "database-postgresql", "14", StatusEOLSoon, "2026-11-12", "18", "12", ""}, rows[0])
+
+	summary := BreakingChangesSummary(items)
+	assert.Contains(t, summary, "notable changes from postgres 14 to 18:\n  - [15] ")
+	assert.Equal(t, 1, strings.Count(summary, "notable changes"), "an upgrade is summarized once")
+}
@@ -0,0 +1,150 @@
+package advisor
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed releases.json
+var embeddedReleases []byte
+
+const dateLayout = "2006-01-02"
+
+// Date is a calendar day, formatted as 2006-01-02 in JSON
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Format(dateLayout))
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return fmt.Errorf("invalid date %q, must be formatted as YYYY-MM-DD: %w", value, err)
+	}
+	d.Time = parsed
+	return nil
+}
+
+// Release is a postgres major version
+type Release struct {
+	Version  string `json:"version"`
+	Released Date   `json:"released"`
+	// EOL is the date the version stops receiving fixes
+	EOL Date `json:"eol"`
+	// UpgradeFrom are the major versions an upgrade image exists for to upgrade to this version
+	UpgradeFrom []string `json:"upgradeFrom"`
+	// BreakingChanges are the notable incompatibilities when upgrading to this version from the previous major
+	BreakingChanges []string `json:"breakingChanges"`
+}
+
+// Releases is the table of postgres major versions
+type Releases struct {
+	Releases []Release `json:"releases"`
+}
+
+// DefaultReleases returns the release table embedded in the binary
+func DefaultReleases() (*Releases, error) {
+	return ParseReleases(embeddedReleases)
+}
+
+// LoadReleases returns the release table in path, or the embedded table when path is empty
+func LoadReleases(path string) (*Releases, error) {
+	if path == "" {
+		return DefaultReleases()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read releases file: %w", err)
+	}
+	releases, err := ParseReleases(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid releases file %q: %w", path, err)
+	}
+	return releases, nil
+}
+
+// ParseReleases parses a JSON release table
+func ParseReleases(data []byte) (*Releases, error) {
+	releases := &Releases{}
+	if err := json.Unmarshal(data, releases); err != nil {
+		return nil, err
+	}
+	for _, release := range releases.Releases {
+		if _, err := parseMajorVersion(release.Version); err != nil {
+			return nil, err
+		}
+		if release.EOL.IsZero() || release.Released.IsZero() {
+			return nil, fmt.Errorf("release %q must have a released and an eol date", release.Version)
+		}
+	}
+	return releases, nil
+}
+
+// Get returns the release of the major version, or nil when it is not in the table
+func (r *Releases) Get(version string) *Release {
+	for i := range r.Releases {
+		if r.Releases[i].Version == version {
+			return &r.Releases[i]
+		}
+	}
+	return nil
+}
+
+// CanUpgrade returns true when an upgrade image exists from the major version to the release
+func (r *Release) CanUpgrade(from string) bool {
+	for _, version := range r.UpgradeFrom {
+		if version == from {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMajorVersion returns the components of a major version, 9.6 for releases before 10 and 16 after
+func parseMajorVersion(version string) ([]int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid major version %q", version)
+	}
+	components := make([]int, len(parts))
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid major version %q", version)
+		}
+		components[i] = value
+	}
+	return components, nil
+}
+
+// compareMajorVersions returns -1, 0 or 1 when a is older than, equal to or newer than b
+func compareMajorVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,183 @@
+package cnpg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultImageRepository is the repository of the PostgreSQL images of CloudNativePG, tagged by version
+	DefaultImageRepository = "ghcr.io/cloudnative-pg/postgresql"
+	// DefaultPostgresUID and DefaultPostgresGID are the user and group CloudNativePG runs PostgreSQL as
+	DefaultPostgresUID = 26
+	DefaultPostgresGID = 26
+	// PGDataSubPath is the directory of the data directory on the volumes of CloudNativePG
+	PGDataSubPath = "pgdata"
+	// SuperuserName is the superuser CloudNativePG manages the instances with
+	SuperuserName = "postgres"
+)
+
+var (
+	ClusterResource = schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"}
+	secretResource  = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+)
+
+// ClusterOptions are the settings of a CloudNativePG Cluster bootstrapped from a VolumeSnapshot of an upgraded volume
+type ClusterOptions struct {
+	Name      string
+	Namespace string
+	// Instances defaults to 1
+	Instances int
+	// ImageName defaults to the image of DefaultImageRepository for the PostgresVersion
+	ImageName       string
+	PostgresVersion string
+	// PostgresUID and PostgresGID own the data directory, default to DefaultPostgresUID and DefaultPostgresGID
+	PostgresUID int64
+	PostgresGID int64
+
+	StorageClass string
+	Size         string
+	// VolumeSnapshot is the snapshot of the volume with the upgraded data directory in PGDataSubPath
+	VolumeSnapshot string
+
+	Credentials Credentials
+}
+
+func (o ClusterOptions) superuserSecretName() string {
+	return o.Name + "-superuser"
+}
+
+func (o ClusterOptions) appSecretName() string {
+	return o.Name + "-app"
+}
+
+// Manifests returns the Cluster and the secrets with its credentials
+func Manifests(opts ClusterOptions) ([]*unstructured.Unstructured, error) {
+	if opts.Name == "" {
+		return nil, fmt.Errorf("cluster name must not be empty")
+	}
+	if opts.VolumeSnapshot == "" {
+		return nil, fmt.Errorf("volume snapshot must not be empty")
+	}
+	imageName := opts.ImageName
+	if imageName == "" {
+		if opts.PostgresVersion == "" {
+			return nil, fmt.Errorf("postgres version or image name must be set")
+		}
+		imageName = fmt.Sprintf("%s:%s", DefaultImageRepository, opts.PostgresVersion)
+	}
+	instances := opts.Instances
+	if instances == 0 {
+		instances = 1
+	}
+	uid, gid := opts.PostgresUID, opts.PostgresGID
+	if uid == 0 {
+		uid = DefaultPostgresUID
+	}
+	if gid == 0 {
+		gid = DefaultPostgresGID
+	}
+
+	storage := map[string]any{"size": opts.Size}
+	if opts.StorageClass != "" {
+		storage["storageClass"] = opts.StorageClass
+	}
+	recovery := map[string]any{
+		"volumeSnapshots": map[string]any{
+			"storage": map[string]any{
+				"name":     opts.VolumeSnapshot,
+				"kind":     "VolumeSnapshot",
+				"apiGroup": volumeSnapshotResource.Group,
+			},
+		},
+	}
+	spec := map[string]any{
+		"instances":   int64(instances),
+		"imageName":   imageName,
+		"postgresUID": uid,
+		"postgresGID": gid,
+		"storage":     storage,
+		"bootstrap":   map[string]any{"recovery": recovery},
+	}
+
+	objects := []*unstructured.Unstructured{}
+	if opts.Credentials.SuperuserPassword != "" {
+		objects = append(objects, newBasicAuthSecret(opts.Namespace, opts.superuserSecretName(), SuperuserName, opts.Credentials.SuperuserPassword))
+		spec["enableSuperuserAccess"] = true
+		spec["superuserSecret"] = map[string]any{"name": opts.superuserSecretName()}
+	}
+	if opts.Credentials.Username != "" {
+		objects = append(objects, newBasicAuthSecret(opts.Namespace, opts.appSecretName(), opts.Credentials.Username, opts.Credentials.Password))
+		recovery["owner"] = opts.Credentials.Username
+		recovery["database"] = orDefault(opts.Credentials.Database, opts.Credentials.Username)
+		recovery["secret"] = map[string]any{"name": opts.appSecretName()}
+	}
+
+	cluster := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	cluster.SetAPIVersion(ClusterResource.GroupVersion().String())
+	cluster.SetKind("Cluster")
+	cluster.SetNamespace(opts.Namespace)
+	cluster.SetName(opts.Name)
+	return append(objects, cluster), nil
+}
+
+func newBasicAuthSecret(namespace, name, username, password string) *unstructured.Unstructured {
+	secret := &unstructured.Unstructured{Object: map[string]any{
+		"type":       "kubernetes.io/basic-auth",
+		"stringData": map[string]any{"username": username, "password": password},
+	}}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	secret.SetNamespace(namespace)
+	secret.SetName(name)
+	return secret
+}
+
+// ToYAML returns the objects as a multi-document YAML manifest
+func ToYAML(objects []*unstructured.Unstructured) ([]byte, error) {
+	var manifest bytes.Buffer
+	for i, object := range objects {
+		data, err := yaml.Marshal(object.Object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s %s: %w", object.GetKind(), object.GetName(), err)
+		}
+		if i > 0 {
+			manifest.WriteString("---\n")
+		}
+		manifest.Write(data)
+	}
+	return manifest.Bytes(), nil
+}
+
+// Apply creates the objects returned by Manifests
+func Apply(ctx context.Context, dynamicClient dynamic.Interface, objects []*unstructured.Unstructured) error {
+	for _, object := range objects {
+		resource := ClusterResource
+		if object.GetKind() == "Secret" {
+			resource = secretResource
+		}
+		_, err := dynamicClient.Resource(resource).Namespace(object.GetNamespace()).Create(ctx, object, metav1.CreateOptions{})
+		if kubeerrors.IsAlreadyExists(err) {
+			return fmt.Errorf("%s %s/%s already exists", object.GetKind(), object.GetNamespace(), object.GetName())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create %s %s/%s: %w", object.GetKind(), object.GetNamespace(), object.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,200 @@
+package cnpg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		ClusterResource:        "ClusterList",
+		secretResource:         "SecretList",
+		volumeSnapshotResource: "VolumeSnapshotList",
+	}, objects...)
+}
+
+func TestManifests(t *testing.T) {
+	objects, err := Manifests(ClusterOptions{
+		Name:            "orders",
+		Namespace:       "databases",
+		PostgresVersion: "16",
+		StorageClass:    "standard",
+		Size:            "10Gi",
+		VolumeSnapshot:  "orders-import",
+		Credentials:     Credentials{SuperuserPassword: "secret", Username: "orders", Password: "orders-secret"},
+	})
+	require.NoError(t, err)
+
+	manifest, err := ToYAML(objects)
+	require.NoError(t, err)
+	assert.Equal(t, `apiVersion: v1
+kind: Secret
+metadata:
+  name: orders-superuser
+  namespace: databases
+stringData:
+  password: secret
+  username: postgres
+type: kubernetes.io/basic-auth
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: orders-app
+  namespace: databases
+stringData:
+  password: orders-secret
+  username: orders
+type: kubernetes.io/basic-auth
+---
+apiVersion: postgresql.cnpg.io/v1
+kind: Cluster
+metadata:
+  name: orders
+  namespace: databases
+spec:
+  bootstrap:
+    recovery:
+      database: orders
+      owner: orders
+      secret:
+        name: orders-app
+      volumeSnapshots:
+        storage:
+          apiGroup: snapshot.storage.k8s.io
+          kind: VolumeSnapshot
+          name: orders-import
+  enableSuperuserAccess: true
+  imageName: ghcr.io/cloudnative-pg/postgresql:16
+  instances: 1
+  postgresGID: 26
+  postgresUID: 26
+  storage:
+    size: 10Gi
+    storageClass: standard
+  superuserSecret:
+    name: orders-superuser
+`, string(manifest))
+
+	objects, err = Manifests(ClusterOptions{Name: "orders", ImageName: "registry/postgresql:16.4", VolumeSnapshot: "orders-import"})
+	require.NoError(t, err)
+	require.Len(t, objects, 1, "no secrets without credentials")
+	_, found, _ := unstructured.NestedFieldNoCopy(objects[0].Object, "spec", "bootstrap", "recovery", "owner")
+	assert.False(t, found)
+
+	_, err = Manifests(ClusterOptions{Name: "orders", VolumeSnapshot: "orders-import"})
+	assert.Error(t, err, "the image is unknown without a version")
+}
+
+func TestApply(t *testing.T) {
+	client := newTestDynamicClient()
+	objects, err := Manifests(ClusterOptions{
+		Name:            "orders",
+		Namespace:       "databases",
+		PostgresVersion: "16",
+		VolumeSnapshot:  "orders-import",
+		Credentials:     Credentials{SuperuserPassword: "secret"},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, Apply(context.Background(), client, objects))
+	_, err = client.Resource(ClusterResource).Namespace("databases").Get(context.Background(), "orders", metav1.GetOptions{})
+	require.NoError(t, err)
+	_, err = client.Resource(secretResource).Namespace("databases").Get(context.Background(), "orders-superuser", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	assert.ErrorContains(t, Apply(context.Background(), client, objects), "already exists")
+}
+
+func TestFindCredentials(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "databases", Name: "orders-postgresql"},
+		Data:       map[string][]byte{"postgres-password": []byte("secret"), "password": []byte("orders-secret")},
+	})
+	secretRef := func(key string) *v1.EnvVarSource {
+		return &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "orders-postgresql"}, Key: key}}
+	}
+
+	tests := map[string]struct {
+		podSpec   v1.PodSpec
+		container v1.Container
+		want      Credentials
+	}{
+		"bitnami": {
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "POSTGRESQL_USERNAME", Value: "orders"},
+				{Name: "POSTGRESQL_DATABASE", Value: "shop"},
+				{Name: "POSTGRESQL_PASSWORD", ValueFrom: secretRef("password")},
+				{Name: "POSTGRESQL_POSTGRES_PASSWORD", ValueFrom: secretRef("postgres-password")},
+			}},
+			want: Credentials{SuperuserPassword: "secret", Username: "orders", Password: "orders-secret", Database: "shop"},
+		},
+		"bitnami password files": {
+			podSpec: v1.PodSpec{Volumes: []v1.Volume{{Name: "postgresql-password", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "orders-postgresql"}}}}},
+			container: v1.Container{
+				Env: []v1.EnvVar{
+					{Name: "POSTGRES_USER", Value: "orders"},
+					{Name: "POSTGRES_PASSWORD_FILE", Value: "/opt/bitnami/postgresql/secrets/password"},
+					{Name: "POSTGRES_POSTGRES_PASSWORD_FILE", Value: "/opt/bitnami/postgresql/secrets/postgres-password"},
+				},
+				VolumeMounts: []v1.VolumeMount{{Name: "postgresql-password", MountPath: "/opt/bitnami/postgresql/secrets/"}},
+			},
+			want: Credentials{SuperuserPassword: "secret", Username: "orders", Password: "orders-secret"},
+		},
+		"postgres user only": {
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "POSTGRES_USER", Value: "postgres"},
+				{Name: "POSTGRES_PASSWORD", Value: "plain"},
+			}},
+			want: Credentials{SuperuserPassword: "plain"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			credentials, err := FindCredentials(context.Background(), k8sClient, "databases", test.podSpec, test.container)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, credentials)
+		})
+	}
+
+	_, err := FindCredentials(context.Background(), k8sClient, "databases", v1.PodSpec{}, v1.Container{Env: []v1.EnvVar{
+		{Name: "POSTGRESQL_PASSWORD_FILE", Value: "/secrets/password"},
+	}})
+	assert.ErrorContains(t, err, "not mounted from a secret")
+}
+
+func TestVolumeSnapshot(t *testing.T) {
+	snapshotPollInterval = time.Millisecond
+	client := newTestDynamicClient()
+	ctx := context.Background()
+
+	require.NoError(t, CreateVolumeSnapshot(ctx, client, "databases", "orders-import", "orders-import", "csi-snapclass"))
+	require.NoError(t, CreateVolumeSnapshot(ctx, client, "databases", "orders-import", "orders-import", "csi-snapclass"), "an existing snapshot is reused")
+
+	snapshot, err := client.Resource(volumeSnapshotResource).Namespace("databases").Get(ctx, "orders-import", metav1.GetOptions{})
+	require.NoError(t, err)
+	className, _, _ := unstructured.NestedString(snapshot.Object, "spec", "volumeSnapshotClassName")
+	assert.Equal(t, "csi-snapclass", className)
+
+	require.NoError(t, unstructured.SetNestedField(snapshot.Object, true, "status", "readyToUse"))
+	_, err = client.Resource(volumeSnapshotResource).Namespace("databases").Update(ctx, snapshot, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, WaitForVolumeSnapshot(ctx, client, "databases", "orders-import"))
+
+	require.NoError(t, unstructured.SetNestedField(snapshot.Object, map[string]any{"message": "snapshot class not found"}, "status", "error"))
+	require.NoError(t, unstructured.SetNestedField(snapshot.Object, false, "status", "readyToUse"))
+	_, err = client.Resource(volumeSnapshotResource).Namespace("databases").Update(ctx, snapshot, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.ErrorContains(t, WaitForVolumeSnapshot(ctx, client, "databases", "orders-import"), "snapshot class not found")
+}
@@ -0,0 +1,124 @@
+package cnpg
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Credentials are the users of a database, mapped to the secrets of a Cluster
+type Credentials struct {
+	// SuperuserPassword is the password of the postgres user, optional
+	SuperuserPassword string
+	// Username, Password and Database are the application user and its database, the user is empty when the
+	// database only has the postgres user
+	Username string
+	Password string
+	Database string
+}
+
+// environment variables of the Bitnami images, followed by the ones of the older Bitnami and the Docker Hub images
+var (
+	superuserPasswordEnv = []string{"POSTGRESQL_POSTGRES_PASSWORD", "POSTGRES_POSTGRES_PASSWORD"}
+	usernameEnv          = []string{"POSTGRESQL_USERNAME", "POSTGRES_USER"}
+	passwordEnv          = []string{"POSTGRESQL_PASSWORD", "POSTGRES_PASSWORD"}
+	databaseEnv          = []string{"POSTGRESQL_DATABASE", "POSTGRES_DB", "POSTGRES_DATABASE"}
+)
+
+// FindCredentials returns the credentials of a postgres container, read from its environment variables, the secrets
+// they refer to, or the secrets mounted for their _FILE variants
+func FindCredentials(ctx context.Context, k8sClient kubernetes.Interface, namespace string, podSpec v1.PodSpec, container v1.Container) (Credentials, error) {
+	resolver := &envResolver{k8sClient: k8sClient, namespace: namespace, podSpec: podSpec, container: container, secrets: map[string]*v1.Secret{}}
+	credentials := Credentials{}
+	for _, field := range []struct {
+		value *string
+		names []string
+	}{
+		{&credentials.SuperuserPassword, superuserPasswordEnv},
+		{&credentials.Username, usernameEnv},
+		{&credentials.Password, passwordEnv},
+		{&credentials.Database, databaseEnv},
+	} {
+		value, err := resolver.lookup(ctx, field.names...)
+		if err != nil {
+			return Credentials{}, err
+		}
+		*field.value = value
+	}
+	if credentials.Username == "" || credentials.Username == SuperuserName {
+		// the password belongs to the postgres user
+		credentials.SuperuserPassword = orDefault(credentials.SuperuserPassword, credentials.Password)
+		return Credentials{SuperuserPassword: credentials.SuperuserPassword}, nil
+	}
+	return credentials, nil
+}
+
+type envResolver struct {
+	k8sClient kubernetes.Interface
+	namespace string
+	podSpec   v1.PodSpec
+	container v1.Container
+	secrets   map[string]*v1.Secret
+}
+
+// lookup returns the value of the first environment variable that is set
+func (r *envResolver) lookup(ctx context.Context, names ...string) (string, error) {
+	for _, name := range names {
+		for _, env := range r.container.Env {
+			switch {
+			case env.Name == name && env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil:
+				return r.secretValue(ctx, env.ValueFrom.SecretKeyRef.Name, env.ValueFrom.SecretKeyRef.Key)
+			case env.Name == name && env.Value != "":
+				return env.Value, nil
+			case env.Name == name+"_FILE" && env.Value != "":
+				return r.fileValue(ctx, env.Value)
+			}
+		}
+	}
+	return "", nil
+}
+
+// fileValue returns the value of a file mounted from a secret volume
+func (r *envResolver) fileValue(ctx context.Context, path string) (string, error) {
+	for _, mount := range r.container.VolumeMounts {
+		relative, err := filepath.Rel(mount.MountPath, path)
+		if err != nil || strings.HasPrefix(relative, "..") {
+			continue
+		}
+		for _, volume := range r.podSpec.Volumes {
+			if volume.Name != mount.Name || volume.Secret == nil {
+				continue
+			}
+			key := filepath.Join(mount.SubPath, relative)
+			for _, item := range volume.Secret.Items {
+				if item.Path == key {
+					key = item.Key
+				}
+			}
+			return r.secretValue(ctx, volume.Secret.SecretName, key)
+		}
+	}
+	return "", fmt.Errorf("file %q is not mounted from a secret", path)
+}
+
+func (r *envResolver) secretValue(ctx context.Context, name, key string) (string, error) {
+	secret, ok := r.secrets[name]
+	if !ok {
+		var err error
+		secret, err = r.k8sClient.CoreV1().Secrets(r.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get secret %q: %w", name, err)
+		}
+		r.secrets[name] = secret
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %q does not contain key %q", name, key)
+	}
+	return string(value), nil
+}
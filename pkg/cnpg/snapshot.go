@@ -0,0 +1,54 @@
+package cnpg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+var volumeSnapshotResource = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}
+
+// snapshotPollInterval is how often the status of a VolumeSnapshot is checked
+var snapshotPollInterval = 5 * time.Second
+
+// CreateVolumeSnapshot creates a VolumeSnapshot of the pvc, an existing snapshot with the same name is reused. The
+// default VolumeSnapshotClass is used when className is empty.
+func CreateVolumeSnapshot(ctx context.Context, dynamicClient dynamic.Interface, namespace, name, pvcName, className string) error {
+	spec := map[string]any{"source": map[string]any{"persistentVolumeClaimName": pvcName}}
+	if className != "" {
+		spec["volumeSnapshotClassName"] = className
+	}
+	snapshot := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	snapshot.SetAPIVersion(volumeSnapshotResource.GroupVersion().String())
+	snapshot.SetKind("VolumeSnapshot")
+	snapshot.SetNamespace(namespace)
+	snapshot.SetName(name)
+
+	_, err := dynamicClient.Resource(volumeSnapshotResource).Namespace(namespace).Create(ctx, snapshot, metav1.CreateOptions{})
+	if err != nil && !kubeerrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create volume snapshot %q of pvc %q: %w", name, pvcName, err)
+	}
+	return nil
+}
+
+// WaitForVolumeSnapshot waits until the VolumeSnapshot is ready to use, and fails when the snapshot reports an error
+func WaitForVolumeSnapshot(ctx context.Context, dynamicClient dynamic.Interface, namespace, name string) error {
+	return wait.PollUntilContextCancel(ctx, snapshotPollInterval, true, func(ctx context.Context) (bool, error) {
+		snapshot, err := dynamicClient.Resource(volumeSnapshotResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to get volume snapshot %q: %w", name, err)
+		}
+		if message, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); found && message != "" {
+			return false, fmt.Errorf("volume snapshot %q failed: %s", name, message)
+		}
+		ready, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+		return ready, nil
+	})
+}
@@ -0,0 +1,355 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+)
+
+// Options configure the controller
+type Options struct {
+	// Namespace is the namespace watched for PostgresUpgrades, all namespaces when empty
+	Namespace string
+	// Workers is the number of upgrades running at the same time, defaults to 1
+	Workers int
+	// ResyncPeriod is how often all PostgresUpgrades are reconciled again, zero disables the resync
+	ResyncPeriod time.Duration
+	// Settings are the base settings of every upgrade, the spec of a PostgresUpgrade takes precedence
+	Settings pgupgrade.PGUpgradeSettings
+	// Logger receives the diagnostic logs of the controller, they are discarded when nil
+	Logger *slog.Logger
+}
+
+// Controller runs the upgrades requested by PostgresUpgrade resources and reports their progress in the status.
+//
+// A PostgresUpgrade is upgraded once per generation of its spec: a succeeded or failed upgrade is not retried until
+// the spec changes. An upgrade that was running when the controller stopped is resumed, when the workload has
+// already been upgraded to the target version the upgrade succeeds without running it again. An upgrade that was
+// interrupted while its volumes were switched around fails instead, its volumes have to be recovered by hand.
+type Controller struct {
+	dynamicClient dynamic.Interface
+	k8sClient     kubernetes.Interface
+	options       Options
+	logger        *slog.Logger
+	queue         workqueue.RateLimitingInterface
+
+	// upgrade runs the upgrade of a target, replaced in tests
+	upgrade func(ctx context.Context, target pgupgrade.BatchTarget) pgupgrade.BatchResult
+	now     func() time.Time
+}
+
+// New returns a controller for the PostgresUpgrades of options.Namespace
+func New(dynamicClient dynamic.Interface, k8sClient kubernetes.Interface, options Options) *Controller {
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	return &Controller{
+		dynamicClient: dynamicClient,
+		k8sClient:     k8sClient,
+		options:       options,
+		logger:        logger,
+		queue:         workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		upgrade: func(ctx context.Context, target pgupgrade.BatchTarget) pgupgrade.BatchResult {
+			return pgupgrade.RunBatch(ctx, k8sClient, []pgupgrade.BatchTarget{target}, pgupgrade.BatchOptions{})[0]
+		},
+		now: time.Now,
+	}
+}
+
+// Run watches the PostgresUpgrades until ctx is cancelled. Upgrades that are running when ctx is cancelled are
+// interrupted and resumed by the next run of the controller.
+func (c *Controller) Run(ctx context.Context) error {
+	defer c.queue.ShutDown()
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamicClient, c.options.ResyncPeriod, c.options.Namespace, nil)
+	informer := factory.ForResource(PostgresUpgradeResource).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj any) { c.enqueue(obj) },
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch postgres upgrades: %w", err)
+	}
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync postgres upgrades: %w", context.Cause(ctx))
+	}
+	c.logger.Info("watching postgres upgrades", "namespace", c.options.Namespace, "workers", c.options.Workers)
+
+	done := make(chan struct{})
+	for i := 0; i < c.options.Workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for c.processNextItem(ctx) {
+			}
+		}()
+	}
+	<-ctx.Done()
+	c.queue.ShutDown()
+	for i := 0; i < c.options.Workers; i++ {
+		<-done
+	}
+	return nil
+}
+
+func (c *Controller) enqueue(obj any) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		c.logger.Warn("failed to get the key of a postgres upgrade", "error", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(string)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		c.queue.Forget(item)
+		return true
+	}
+	if err := c.Reconcile(ctx, namespace, name); err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		c.logger.Warn("failed to reconcile postgres upgrade, retrying", "namespace", namespace, "name", name, "error", err)
+		c.queue.AddRateLimited(item)
+		return true
+	}
+	c.queue.Forget(item)
+	return true
+}
+
+// Reconcile runs the upgrade of the PostgresUpgrade, unless the current generation of its spec has already been
+// upgraded or failed
+func (c *Controller) Reconcile(ctx context.Context, namespace, name string) error {
+	upgrade, err := c.get(ctx, namespace, name)
+	if kubeerrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if upgrade.DeletionTimestamp != nil {
+		return nil
+	}
+	status := upgrade.Status
+	generation := upgrade.Generation
+	if status.ObservedGeneration == generation && (status.Phase == PhaseSucceeded || status.Phase == PhaseFailed) {
+		return nil
+	}
+	resumed := status.ObservedGeneration == generation && status.Phase == PhaseRunning
+	logger := c.logger.With("namespace", namespace, "name", name, "generation", generation)
+
+	target := upgrade.Spec.planTarget(namespace)
+	if err := target.Validate(); err != nil {
+		logger.Warn("invalid postgres upgrade", "error", err)
+		return c.updateStatus(ctx, namespace, name, func(status *PostgresUpgradeStatus) {
+			status.Phase = PhaseFailed
+			status.ObservedGeneration = generation
+			status.Message = err.Error()
+			status.CompletionTime = c.timestamp()
+			c.setCondition(status, generation, ConditionValid, metav1.ConditionFalse, ReasonInvalidSpec, err.Error())
+			c.setCondition(status, generation, ConditionRunning, metav1.ConditionFalse, ReasonInvalidSpec, "the spec is invalid")
+			c.setCondition(status, generation, ConditionSucceeded, metav1.ConditionFalse, ReasonInvalidSpec, "the spec is invalid")
+		})
+	}
+
+	upgradedTo, upgradedFrom := c.upgradedVersions(ctx, namespace, upgrade.Spec)
+	if upgradedTo == upgrade.Spec.TargetVersion {
+		message := fmt.Sprintf("%s %s is already upgraded to postgres %s", upgrade.Spec.Target.Kind, upgrade.Spec.Target.Name, upgradedTo)
+		logger.Info(message)
+		return c.updateStatus(ctx, namespace, name, func(status *PostgresUpgradeStatus) {
+			c.setSucceeded(status, generation, ReasonAlreadyUpgraded, message)
+			if status.FromVersion == "" {
+				status.FromVersion = upgradedFrom
+			}
+		})
+	}
+
+	if switching := meta.FindStatusCondition(status.Conditions, ConditionVolumesSwitching); resumed && switching != nil &&
+		switching.Status == metav1.ConditionTrue && switching.ObservedGeneration == generation {
+		// the data of the workload may already have been replaced, running the upgrade again would upgrade it twice
+		message := fmt.Sprintf("the upgrade was interrupted while switching the volumes, %s. It is not resumed: check the persistent volume claims and the retained persistent volumes, recover the data by hand and change the spec to upgrade again", switching.Message)
+		logger.Warn("not resuming postgres upgrade interrupted while switching the volumes")
+		return c.updateStatus(ctx, namespace, name, func(status *PostgresUpgradeStatus) {
+			status.Phase = PhaseFailed
+			status.Message = message
+			status.CompletionTime = c.timestamp()
+			c.setCondition(status, generation, ConditionRunning, metav1.ConditionFalse, ReasonInterruptedSwitch, "the upgrade was interrupted while switching the volumes")
+			c.setCondition(status, generation, ConditionSucceeded, metav1.ConditionFalse, ReasonInterruptedSwitch, pgupgrade.Truncate(message, 1024))
+		})
+	}
+
+	reason, message := ReasonUpgradeStarted, fmt.Sprintf("upgrading %s %s to postgres %s", upgrade.Spec.Target.Kind, upgrade.Spec.Target.Name, upgrade.Spec.TargetVersion)
+	if resumed {
+		reason = ReasonUpgradeResumed
+	}
+	err = c.updateStatus(ctx, namespace, name, func(status *PostgresUpgradeStatus) {
+		status.Phase = PhaseRunning
+		status.ObservedGeneration = generation
+		status.Message = message
+		if !resumed || status.StartTime == nil {
+			status.StartTime = c.timestamp()
+		}
+		status.CompletionTime = nil
+		status.FromVersion = ""
+		c.setCondition(status, generation, ConditionValid, metav1.ConditionTrue, ReasonValidSpec, "the spec is valid")
+		c.setCondition(status, generation, ConditionRunning, metav1.ConditionTrue, reason, message)
+		meta.RemoveStatusCondition(&status.Conditions, ConditionSucceeded)
+		meta.RemoveStatusCondition(&status.Conditions, ConditionVolumesSwitching)
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info(message, "resumed", resumed)
+
+	batchTarget := c.batchTarget(namespace, target, upgrade.Spec)
+	batchTarget.Settings.BeforeSwitchVolumes = func(ctx context.Context, sourcePVCName, targetPVCName string) error {
+		// recorded before anything is changed, so an interrupted switch is never mistaken for an upgrade to resume
+		return c.updateStatus(ctx, namespace, name, func(status *PostgresUpgradeStatus) {
+			c.setCondition(status, generation, ConditionVolumesSwitching, metav1.ConditionTrue, ReasonSwitchingVolumes,
+				fmt.Sprintf("the upgraded data of pvc %q is switched to pvc %q", sourcePVCName, targetPVCName))
+		})
+	}
+	result := c.upgrade(ctx, batchTarget)
+	if ctx.Err() != nil {
+		// the controller is stopping, the upgrade is resumed by its next run
+		return context.Cause(ctx)
+	}
+	return c.updateStatus(ctx, namespace, name, func(status *PostgresUpgradeStatus) {
+		status.FromVersion = result.FromVersion
+		if meta.FindStatusCondition(status.Conditions, ConditionVolumesSwitching) != nil {
+			c.setCondition(status, generation, ConditionVolumesSwitching, metav1.ConditionFalse, ReasonUpgradeFinished, "the upgrade has finished")
+		}
+		if result.Err != nil {
+			logger.Warn("postgres upgrade failed", "error", result.Err)
+			status.Phase = PhaseFailed
+			status.ObservedGeneration = generation
+			status.Message = result.Err.Error()
+			status.CompletionTime = c.timestamp()
+			c.setCondition(status, generation, ConditionRunning, metav1.ConditionFalse, ReasonUpgradeFinished, "the upgrade failed")
+			c.setCondition(status, generation, ConditionSucceeded, metav1.ConditionFalse, ReasonUpgradeFailed, pgupgrade.Truncate(result.Err.Error(), 1024))
+			return
+		}
+		message := fmt.Sprintf("upgraded from postgres %s to %s in %s", result.FromVersion, upgrade.Spec.TargetVersion, result.Duration.Round(time.Second))
+		logger.Info(message)
+		c.setSucceeded(status, generation, ReasonUpgraded, message)
+	})
+}
+
+// batchTarget returns the target of the upgrade, with the spec applied to the settings of the controller
+func (c *Controller) batchTarget(namespace string, target pgupgrade.PlanTarget, spec PostgresUpgradeSpec) pgupgrade.BatchTarget {
+	settings := c.options.Settings
+	settings.CheckExtensions = settings.CheckExtensions || spec.CheckExtensions
+	settings.VerifyData = settings.VerifyData || spec.VerifyData || spec.VerifyChecksums
+	settings.VerifyChecksums = settings.VerifyChecksums || spec.VerifyChecksums
+	plan := &pgupgrade.UpgradePlan{Targets: []pgupgrade.PlanTarget{target}}
+	return plan.BatchTargets(settings, namespace)[0]
+}
+
+// upgradedVersions returns the versions of the last upgrade recorded in the annotations of the upgraded workload, the
+// statefulset or the target pvc
+func (c *Controller) upgradedVersions(ctx context.Context, namespace string, spec PostgresUpgradeSpec) (string, string) {
+	var annotations map[string]string
+	switch spec.Target.Kind {
+	case pgupgrade.KindStatefulSet:
+		sts, err := c.k8sClient.AppsV1().StatefulSets(namespace).Get(ctx, spec.Target.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", ""
+		}
+		annotations = sts.Annotations
+	case pgupgrade.KindPersistentVolumeClaim:
+		pvc, err := c.k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, spec.TargetPVC, metav1.GetOptions{})
+		if err != nil {
+			return "", ""
+		}
+		annotations = pvc.Annotations
+	}
+	return annotations[pgupgrade.AnnotationUpgradedTo], annotations[pgupgrade.AnnotationUpgradedFrom]
+}
+
+func (c *Controller) setSucceeded(status *PostgresUpgradeStatus, generation int64, reason, message string) {
+	status.Phase = PhaseSucceeded
+	status.ObservedGeneration = generation
+	status.Message = message
+	status.CompletionTime = c.timestamp()
+	c.setCondition(status, generation, ConditionValid, metav1.ConditionTrue, ReasonValidSpec, "the spec is valid")
+	c.setCondition(status, generation, ConditionRunning, metav1.ConditionFalse, ReasonUpgradeFinished, "the upgrade succeeded")
+	c.setCondition(status, generation, ConditionSucceeded, metav1.ConditionTrue, reason, message)
+}
+
+func (c *Controller) setCondition(status *PostgresUpgradeStatus, generation int64, conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		ObservedGeneration: generation,
+		LastTransitionTime: *c.timestamp(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+func (c *Controller) timestamp() *metav1.Time {
+	now := metav1.NewTime(c.now().UTC().Truncate(time.Second))
+	return &now
+}
+
+func (c *Controller) get(ctx context.Context, namespace, name string) (*PostgresUpgrade, error) {
+	obj, err := c.dynamicClient.Resource(PostgresUpgradeResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	upgrade := &PostgresUpgrade{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, upgrade); err != nil {
+		return nil, fmt.Errorf("invalid postgres upgrade %s/%s: %w", namespace, name, err)
+	}
+	return upgrade, nil
+}
+
+// updateStatus applies update to the latest version of the status of the PostgresUpgrade, retrying on conflicts
+func (c *Controller) updateStatus(ctx context.Context, namespace, name string, update func(status *PostgresUpgradeStatus)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		upgrade, err := c.get(ctx, namespace, name)
+		if err != nil {
+			return err
+		}
+		update(&upgrade.Status)
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(upgrade)
+		if err != nil {
+			return err
+		}
+		_, err = c.dynamicClient.Resource(PostgresUpgradeResource).Namespace(namespace).UpdateStatus(ctx, &unstructured.Unstructured{Object: object}, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update the status of postgres upgrade %s/%s: %w", namespace, name, err)
+		}
+		return nil
+	})
+}
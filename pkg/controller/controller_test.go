@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+)
+
+var testNow = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func newTestPostgresUpgrade(t *testing.T, spec PostgresUpgradeSpec, status PostgresUpgradeStatus) *unstructured.Unstructured {
+	upgrade := &PostgresUpgrade{
+		TypeMeta:   metav1.TypeMeta{APIVersion: PostgresUpgradeResource.GroupVersion().String(), Kind: PostgresUpgradeKind},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "orders", Generation: 1},
+		Spec:       spec,
+		Status:     status,
+	}
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(upgrade)
+	require.NoError(t, err)
+	return &unstructured.Unstructured{Object: object}
+}
+
+type testController struct {
+	*Controller
+	upgrades []pgupgrade.BatchTarget
+}
+
+func newTestController(t *testing.T, result pgupgrade.BatchResult, objects ...runtime.Object) *testController {
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{PostgresUpgradeResource: PostgresUpgradeKind + "List"})
+	for _, object := range objects {
+		u := object.(*unstructured.Unstructured)
+		_, err := dynamicClient.Resource(PostgresUpgradeResource).Namespace(u.GetNamespace()).Create(context.Background(), u, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	k8sClient := fake.NewSimpleClientset(&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "orders-postgresql"}})
+
+	c := &testController{}
+	c.Controller = New(dynamicClient, k8sClient, Options{Settings: pgupgrade.PGUpgradeSettings{LogDir: "logs"}})
+	c.now = func() time.Time { return testNow }
+	c.upgrade = func(ctx context.Context, target pgupgrade.BatchTarget) pgupgrade.BatchResult {
+		c.upgrades = append(c.upgrades, target)
+		result.Target = target
+		return result
+	}
+	return c
+}
+
+func (c *testController) status(t *testing.T) PostgresUpgradeStatus {
+	upgrade, err := c.get(context.Background(), "team-a", "orders")
+	require.NoError(t, err)
+	return upgrade.Status
+}
+
+var ordersSpec = PostgresUpgradeSpec{
+	Target:          UpgradeTarget{Kind: pgupgrade.KindStatefulSet, Name: "orders-postgresql"},
+	TargetVersion:   "16",
+	CheckExtensions: true,
+}
+
+func TestReconcile(t *testing.T) {
+	c := newTestController(t, pgupgrade.BatchResult{FromVersion: "15", Duration: time.Minute},
+		newTestPostgresUpgrade(t, ordersSpec, PostgresUpgradeStatus{}))
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	require.Len(t, c.upgrades, 1)
+	target := c.upgrades[0]
+	assert.Equal(t, "team-a", target.Namespace)
+	assert.Equal(t, "orders-postgresql", target.StatefulSet)
+	assert.Equal(t, "16", target.Settings.TargetPostgresVersion)
+	assert.True(t, target.Settings.CheckExtensions, "the spec is applied to the settings")
+	assert.Equal(t, "logs", target.Settings.LogDir, "the settings of the controller apply to every upgrade")
+
+	status := c.status(t)
+	assert.Equal(t, PhaseSucceeded, status.Phase)
+	assert.Equal(t, int64(1), status.ObservedGeneration)
+	assert.Equal(t, "15", status.FromVersion)
+	assert.Equal(t, "upgraded from postgres 15 to 16 in 1m0s", status.Message)
+	assert.True(t, testNow.Equal(status.StartTime.Time))
+	assert.True(t, testNow.Equal(status.CompletionTime.Time))
+	assert.True(t, meta.IsStatusConditionTrue(status.Conditions, ConditionValid))
+	assert.True(t, meta.IsStatusConditionFalse(status.Conditions, ConditionRunning))
+	assert.Equal(t, ReasonUpgraded, meta.FindStatusCondition(status.Conditions, ConditionSucceeded).Reason)
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	assert.Len(t, c.upgrades, 1, "a generation is upgraded once")
+}
+
+func TestReconcileRetriesNewGeneration(t *testing.T) {
+	object := newTestPostgresUpgrade(t, ordersSpec, PostgresUpgradeStatus{Phase: PhaseFailed, ObservedGeneration: 1})
+	object.SetGeneration(2)
+	c := newTestController(t, pgupgrade.BatchResult{FromVersion: "15"}, object)
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	assert.Len(t, c.upgrades, 1, "a changed spec is upgraded again")
+	assert.Equal(t, int64(2), c.status(t).ObservedGeneration)
+}
+
+func TestReconcileFailed(t *testing.T) {
+	c := newTestController(t, pgupgrade.BatchResult{FromVersion: "15", Err: errors.New("pg_upgrade failed")},
+		newTestPostgresUpgrade(t, ordersSpec, PostgresUpgradeStatus{}))
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	status := c.status(t)
+	assert.Equal(t, PhaseFailed, status.Phase)
+	assert.Equal(t, "pg_upgrade failed", status.Message)
+	assert.NotNil(t, status.CompletionTime)
+	assert.Equal(t, ReasonUpgradeFailed, meta.FindStatusCondition(status.Conditions, ConditionSucceeded).Reason)
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	assert.Len(t, c.upgrades, 1, "a failed upgrade is not retried until the spec changes")
+}
+
+func TestReconcileInvalidSpec(t *testing.T) {
+	spec := ordersSpec
+	spec.Target.Kind = "Deployment"
+	spec.TargetVersion = ""
+	c := newTestController(t, pgupgrade.BatchResult{}, newTestPostgresUpgrade(t, spec, PostgresUpgradeStatus{}))
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	assert.Empty(t, c.upgrades)
+	status := c.status(t)
+	assert.Equal(t, PhaseFailed, status.Phase)
+	assert.Contains(t, status.Message, `kind: unsupported kind "Deployment"`)
+	assert.Contains(t, status.Message, "targetVersion: must be set")
+	assert.Equal(t, ReasonInvalidSpec, meta.FindStatusCondition(status.Conditions, ConditionValid).Reason)
+}
+
+func TestReconcileResumesRunningUpgrade(t *testing.T) {
+	startTime := metav1.NewTime(testNow.Add(-time.Hour))
+	c := newTestController(t, pgupgrade.BatchResult{FromVersion: "15"},
+		newTestPostgresUpgrade(t, ordersSpec, PostgresUpgradeStatus{Phase: PhaseRunning, ObservedGeneration: 1, StartTime: &startTime}))
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	assert.Len(t, c.upgrades, 1)
+	status := c.status(t)
+	assert.Equal(t, PhaseSucceeded, status.Phase)
+	assert.True(t, startTime.Equal(status.StartTime), "the start time of a resumed upgrade is kept")
+}
+
+func TestReconcileAlreadyUpgraded(t *testing.T) {
+	c := newTestController(t, pgupgrade.BatchResult{},
+		newTestPostgresUpgrade(t, ordersSpec, PostgresUpgradeStatus{Phase: PhaseRunning, ObservedGeneration: 1}))
+	_, err := c.k8sClient.AppsV1().StatefulSets("team-a").Update(context.Background(), &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "team-a", Name: "orders-postgresql",
+		Annotations: map[string]string{pgupgrade.AnnotationUpgradedFrom: "15", pgupgrade.AnnotationUpgradedTo: "16"},
+	}}, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	assert.Empty(t, c.upgrades, "the upgrade completed before the controller restarted")
+	status := c.status(t)
+	assert.Equal(t, PhaseSucceeded, status.Phase)
+	assert.Equal(t, "15", status.FromVersion)
+	assert.Equal(t, ReasonAlreadyUpgraded, meta.FindStatusCondition(status.Conditions, ConditionSucceeded).Reason)
+}
+
+func TestReconcileInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newTestController(t, pgupgrade.BatchResult{}, newTestPostgresUpgrade(t, ordersSpec, PostgresUpgradeStatus{}))
+	c.upgrade = func(ctx context.Context, target pgupgrade.BatchTarget) pgupgrade.BatchResult {
+		cancel()
+		return pgupgrade.BatchResult{Err: context.Canceled}
+	}
+
+	assert.Error(t, c.Reconcile(ctx, "team-a", "orders"))
+	assert.Equal(t, PhaseRunning, c.status(t).Phase, "an interrupted upgrade is resumed by the next run of the controller")
+
+	resumed := false
+	c.upgrade = func(ctx context.Context, target pgupgrade.BatchTarget) pgupgrade.BatchResult {
+		resumed = true
+		require.NoError(t, target.Settings.BeforeSwitchVolumes(ctx, "data-orders-postgresql-0", "data-orders-postgresql-0"))
+		return pgupgrade.BatchResult{FromVersion: "15"}
+	}
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	assert.True(t, resumed, "an upgrade interrupted before switching the volumes is resumed")
+	status := c.status(t)
+	assert.Equal(t, PhaseSucceeded, status.Phase)
+	assert.True(t, meta.IsStatusConditionFalse(status.Conditions, ConditionVolumesSwitching))
+}
+
+func TestReconcileInterruptedWhileSwitchingVolumes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newTestController(t, pgupgrade.BatchResult{}, newTestPostgresUpgrade(t, ordersSpec, PostgresUpgradeStatus{}))
+	upgrades := 0
+	c.upgrade = func(ctx context.Context, target pgupgrade.BatchTarget) pgupgrade.BatchResult {
+		upgrades++
+		require.NoError(t, target.Settings.BeforeSwitchVolumes(ctx, "data-orders-postgresql-0", "data-orders-postgresql-0"))
+		cancel()
+		return pgupgrade.BatchResult{Err: context.Canceled}
+	}
+
+	assert.Error(t, c.Reconcile(ctx, "team-a", "orders"))
+	status := c.status(t)
+	assert.Equal(t, PhaseRunning, status.Phase)
+	assert.True(t, meta.IsStatusConditionTrue(status.Conditions, ConditionVolumesSwitching))
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	assert.Equal(t, 1, upgrades, "an upgrade interrupted while switching the volumes is not run again")
+	status = c.status(t)
+	assert.Equal(t, PhaseFailed, status.Phase)
+	assert.Contains(t, status.Message, `pvc "data-orders-postgresql-0"`)
+	assert.Contains(t, status.Message, "recover the data by hand")
+	assert.NotNil(t, status.CompletionTime)
+	assert.Equal(t, ReasonInterruptedSwitch, meta.FindStatusCondition(status.Conditions, ConditionSucceeded).Reason)
+
+	require.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+	assert.Equal(t, 1, upgrades, "a failed upgrade is not retried until the spec changes")
+}
+
+func TestReconcileNotFound(t *testing.T) {
+	c := newTestController(t, pgupgrade.BatchResult{})
+	assert.NoError(t, c.Reconcile(context.Background(), "team-a", "orders"))
+}
+
+func TestRun(t *testing.T) {
+	c := newTestController(t, pgupgrade.BatchResult{FromVersion: "15"}, newTestPostgresUpgrade(t, ordersSpec, PostgresUpgradeStatus{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+
+	assert.Eventually(t, func() bool { return c.status(t).Phase == PhaseSucceeded }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	assert.Len(t, c.upgrades, 1, "the status updates of the controller do not start the upgrade again")
+}
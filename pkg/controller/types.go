@@ -0,0 +1,128 @@
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/pgupgrade"
+)
+
+// PostgresUpgradeResource is the resource of the PostgresUpgrade custom resource definition
+var PostgresUpgradeResource = schema.GroupVersionResource{
+	Group:    "kube-pg-upgrade.containerinfra.com",
+	Version:  "v1alpha1",
+	Resource: "postgresupgrades",
+}
+
+// PostgresUpgradeKind is the kind of the PostgresUpgrade custom resource
+const PostgresUpgradeKind = "PostgresUpgrade"
+
+// Phases of a PostgresUpgrade
+const (
+	PhaseRunning   = "Running"
+	PhaseSucceeded = "Succeeded"
+	PhaseFailed    = "Failed"
+)
+
+// Condition types of a PostgresUpgrade
+const (
+	// ConditionValid is false when the spec is invalid
+	ConditionValid = "Valid"
+	// ConditionRunning is true while the upgrade runs
+	ConditionRunning = "Running"
+	// ConditionSucceeded is true once the upgrade succeeded, false when it failed
+	ConditionSucceeded = "Succeeded"
+	// ConditionVolumesSwitching is true from the moment the volumes are switched around until the upgrade has finished,
+	// an upgrade interrupted in that time is not resumed
+	ConditionVolumesSwitching = "VolumesSwitching"
+)
+
+// Reasons of the conditions of a PostgresUpgrade
+const (
+	ReasonInvalidSpec       = "InvalidSpec"
+	ReasonValidSpec         = "ValidSpec"
+	ReasonUpgradeStarted    = "UpgradeStarted"
+	ReasonUpgradeResumed    = "UpgradeResumed"
+	ReasonUpgradeFinished   = "UpgradeFinished"
+	ReasonUpgraded          = "Upgraded"
+	ReasonAlreadyUpgraded   = "AlreadyUpgraded"
+	ReasonUpgradeFailed     = "UpgradeFailed"
+	ReasonSwitchingVolumes  = "SwitchingVolumes"
+	ReasonInterruptedSwitch = "InterruptedWhileSwitchingVolumes"
+)
+
+// PostgresUpgrade requests the upgrade of a statefulset or pvc in the namespace of the resource
+type PostgresUpgrade struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PostgresUpgradeSpec   `json:"spec"`
+	Status PostgresUpgradeStatus `json:"status,omitempty"`
+}
+
+// PostgresUpgradeSpec is the workload to upgrade with the settings of the upgrade, empty settings are discovered in the
+// same way as with the flags of the upgrade commands
+type PostgresUpgradeSpec struct {
+	Target UpgradeTarget `json:"target"`
+
+	TargetVersion        string `json:"targetVersion"`
+	CurrentVersion       string `json:"currentVersion,omitempty"`
+	User                 string `json:"user,omitempty"`
+	InitDBArgs           string `json:"initdbArgs,omitempty"`
+	Size                 string `json:"size,omitempty"`
+	SubPath              string `json:"subPath,omitempty"`
+	Container            string `json:"container,omitempty"`
+	UpgradeImage         string `json:"upgradeImage,omitempty"`
+	UpgradeImageTemplate string `json:"upgradeImageTemplate,omitempty"`
+	UpgradeImageDistro   string `json:"upgradeImageDistro,omitempty"`
+	TargetImage          string `json:"targetImage,omitempty"`
+	// TargetPVC is the pvc the upgraded data is written to, required for pvc targets
+	TargetPVC string `json:"targetPVC,omitempty"`
+
+	CheckExtensions bool `json:"checkExtensions,omitempty"`
+	VerifyData      bool `json:"verifyData,omitempty"`
+	VerifyChecksums bool `json:"verifyChecksums,omitempty"`
+}
+
+// UpgradeTarget is the statefulset or pvc to upgrade
+type UpgradeTarget struct {
+	// Kind is StatefulSet or PersistentVolumeClaim
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// PostgresUpgradeStatus is the observed state of the upgrade
+type PostgresUpgradeStatus struct {
+	Phase string `json:"phase,omitempty"`
+	// ObservedGeneration is the generation of the spec the status belongs to
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	StartTime          *metav1.Time       `json:"startTime,omitempty"`
+	CompletionTime     *metav1.Time       `json:"completionTime,omitempty"`
+	// FromVersion is the postgres version before the upgrade
+	FromVersion string `json:"fromVersion,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+// planTarget returns the spec as the target of an upgrade plan, so it is validated and applied in the same way
+func (s PostgresUpgradeSpec) planTarget(namespace string) pgupgrade.PlanTarget {
+	return pgupgrade.PlanTarget{
+		Kind: s.Target.Kind,
+		Name: s.Target.Name,
+		PlanSettings: pgupgrade.PlanSettings{
+			Namespace:            namespace,
+			CurrentVersion:       s.CurrentVersion,
+			TargetVersion:        s.TargetVersion,
+			User:                 s.User,
+			InitDBArgs:           s.InitDBArgs,
+			Size:                 s.Size,
+			SubPath:              s.SubPath,
+			Container:            s.Container,
+			UpgradeImage:         s.UpgradeImage,
+			UpgradeImageTemplate: s.UpgradeImageTemplate,
+			UpgradeImageDistro:   s.UpgradeImageDistro,
+			TargetImage:          s.TargetImage,
+			TargetPVC:            s.TargetPVC,
+		},
+	}
+}
@@ -0,0 +1,305 @@
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// DefaultArgoCDNamespace is the namespace Argo CD Applications are looked up in
+	DefaultArgoCDNamespace = "argocd"
+
+	annotationPrefix = "kube-pg-upgrade.containerinfra.com/"
+	// AnnotationSuspendedAutomatedSync stores the automated sync policy of an Argo CD Application while its sync is
+	// suspended
+	AnnotationSuspendedAutomatedSync = annotationPrefix + "suspended-automated-sync"
+	// AnnotationSuspended marks a Flux object suspended by kube-pg-upgrade, objects suspended by someone else are
+	// not resumed
+	AnnotationSuspended = annotationPrefix + "suspended"
+
+	argoCDTrackingIDAnnotation  = "argocd.argoproj.io/tracking-id"
+	argoCDInstanceLabel         = "app.kubernetes.io/instance"
+	fluxHelmNameLabel           = "helm.toolkit.fluxcd.io/name"
+	fluxHelmNamespaceLabel      = "helm.toolkit.fluxcd.io/namespace"
+	fluxKustomizeNameLabel      = "kustomize.toolkit.fluxcd.io/name"
+	fluxKustomizeNamespaceLabel = "kustomize.toolkit.fluxcd.io/namespace"
+)
+
+// Kinds of the GitOps objects that sync a workload
+const (
+	KindArgoCDApplication = "Application"
+	KindFluxHelmRelease   = "HelmRelease"
+	KindFluxKustomization = "Kustomization"
+)
+
+// resources are the API versions of every kind, the first version served by the cluster is used
+var resources = map[string][]schema.GroupVersionResource{
+	KindArgoCDApplication: {
+		{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"},
+	},
+	KindFluxHelmRelease: {
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2", Resource: "helmreleases"},
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2beta2", Resource: "helmreleases"},
+		{Group: "helm.toolkit.fluxcd.io", Version: "v2beta1", Resource: "helmreleases"},
+	},
+	KindFluxKustomization: {
+		{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Resource: "kustomizations"},
+		{Group: "kustomize.toolkit.fluxcd.io", Version: "v1beta2", Resource: "kustomizations"},
+	},
+}
+
+// Owner is a GitOps object that syncs a workload, and could undo changes to it such as scaling it down
+type Owner struct {
+	Kind      string
+	Namespace string
+	Name      string
+
+	// destination is the namespace the owner must deploy to, set when the owner is only known by a label that
+	// other tools set as well
+	destination string
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("%s %s/%s", o.Kind, o.Namespace, o.Name)
+}
+
+// GroupResource returns the api group and resource of the kind of the owner
+func (o Owner) GroupResource() schema.GroupResource {
+	if versions := resources[o.Kind]; len(versions) > 0 {
+		return versions[0].GroupResource()
+	}
+	return schema.GroupResource{}
+}
+
+// FindOwners returns the Argo CD Applications and Flux HelmReleases and Kustomizations that sync the object, based on
+// the labels and annotations they set on the objects they manage. Only owners that exist are returned. Kinds the
+// cluster does not serve are skipped using discoveryClient, looking them up may be forbidden instead of not found.
+func FindOwners(ctx context.Context, dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface, object metav1.ObjectMeta, argoCDNamespace string) ([]Owner, error) {
+	candidates := []Owner{}
+	if application := argoCDApplication(object, argoCDNamespace); application != nil {
+		candidates = append(candidates, *application)
+	}
+	if name := object.Labels[fluxHelmNameLabel]; name != "" {
+		candidates = append(candidates, Owner{Kind: KindFluxHelmRelease, Namespace: orDefault(object.Labels[fluxHelmNamespaceLabel], object.Namespace), Name: name})
+	}
+	if name := object.Labels[fluxKustomizeNameLabel]; name != "" {
+		candidates = append(candidates, Owner{Kind: KindFluxKustomization, Namespace: orDefault(object.Labels[fluxKustomizeNamespaceLabel], object.Namespace), Name: name})
+	}
+
+	owners := []Owner{}
+	for _, candidate := range candidates {
+		ok, err := served(discoveryClient, candidate.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover the api of %s: %w", candidate.Kind, err)
+		}
+		if !ok {
+			continue
+		}
+		owner, _, err := get(ctx, dynamicClient, candidate)
+		if kubeerrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", candidate, err)
+		}
+		if candidate.destination != "" {
+			destination, _, _ := unstructured.NestedString(owner.Object, "spec", "destination", "namespace")
+			if destination != candidate.destination {
+				continue
+			}
+		}
+		candidate.destination = ""
+		owners = append(owners, candidate)
+	}
+	return owners, nil
+}
+
+// argoCDApplication returns the Argo CD Application from the tracking annotation, or the instance label used by the
+// default label tracking of Argo CD
+func argoCDApplication(object metav1.ObjectMeta, argoCDNamespace string) *Owner {
+	namespace := orDefault(argoCDNamespace, DefaultArgoCDNamespace)
+	// <application>:<group>/<kind>:<namespace>/<name>, the application is <namespace>_<name> for applications
+	// outside of the Argo CD namespace
+	if trackingID := object.Annotations[argoCDTrackingIDAnnotation]; trackingID != "" {
+		name, _, _ := strings.Cut(trackingID, ":")
+		if applicationNamespace, applicationName, ok := strings.Cut(name, "_"); ok {
+			return &Owner{Kind: KindArgoCDApplication, Namespace: applicationNamespace, Name: applicationName}
+		}
+		return &Owner{Kind: KindArgoCDApplication, Namespace: namespace, Name: name}
+	}
+	// Helm charts set the same label to the name of the release
+	if name := object.Labels[argoCDInstanceLabel]; name != "" {
+		return &Owner{Kind: KindArgoCDApplication, Namespace: namespace, Name: name, destination: object.Namespace}
+	}
+	return nil
+}
+
+// Suspend suspends the sync of every owner and returns a function resuming them. Owners that were already suspended
+// by someone else are left alone. When suspending an owner fails, the owners suspended so far are resumed.
+func Suspend(ctx context.Context, dynamicClient dynamic.Interface, owners []Owner) (resume func(ctx context.Context) error, err error) {
+	suspended := []Owner{}
+	resume = func(ctx context.Context) error {
+		errs := []error{}
+		for _, owner := range suspended {
+			if err := resumeOwner(ctx, dynamicClient, owner); err != nil {
+				errs = append(errs, fmt.Errorf("failed to resume the sync of %s: %w", owner, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	for _, owner := range owners {
+		ok, err := suspendOwner(ctx, dynamicClient, owner)
+		if err != nil {
+			err = fmt.Errorf("failed to suspend the sync of %s: %w", owner, err)
+			return nil, errors.Join(err, resume(context.WithoutCancel(ctx)))
+		}
+		if ok {
+			suspended = append(suspended, owner)
+		}
+	}
+	return resume, nil
+}
+
+// suspendOwner suspends the sync of the owner, it returns false when the sync was suspended by someone else. An owner
+// still suspended by an interrupted upgrade is returned as suspended, so it is resumed.
+func suspendOwner(ctx context.Context, dynamicClient dynamic.Interface, owner Owner) (bool, error) {
+	object, resource, err := get(ctx, dynamicClient, owner)
+	if err != nil {
+		return false, err
+	}
+	annotations := object.GetAnnotations()
+
+	var patch map[string]any
+	switch owner.Kind {
+	case KindArgoCDApplication:
+		if _, ok := annotations[AnnotationSuspendedAutomatedSync]; ok {
+			return true, nil
+		}
+		automated, found, err := unstructured.NestedFieldNoCopy(object.Object, "spec", "syncPolicy", "automated")
+		if err != nil || !found || automated == nil {
+			// no automated sync, nothing reverts the scale down
+			return false, err
+		}
+		stored, err := json.Marshal(automated)
+		if err != nil {
+			return false, err
+		}
+		patch = map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{AnnotationSuspendedAutomatedSync: string(stored)}},
+			"spec":     map[string]any{"syncPolicy": map[string]any{"automated": nil}},
+		}
+	default:
+		if _, ok := annotations[AnnotationSuspended]; ok {
+			return true, nil
+		}
+		if suspended, _, _ := unstructured.NestedBool(object.Object, "spec", "suspend"); suspended {
+			return false, nil
+		}
+		patch = map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{AnnotationSuspended: "true"}},
+			"spec":     map[string]any{"suspend": true},
+		}
+	}
+	return true, mergePatch(ctx, dynamicClient, resource, owner, patch)
+}
+
+// resumeOwner restores the sync of an owner suspended by suspendOwner
+func resumeOwner(ctx context.Context, dynamicClient dynamic.Interface, owner Owner) error {
+	object, resource, err := get(ctx, dynamicClient, owner)
+	if err != nil {
+		return err
+	}
+	annotations := object.GetAnnotations()
+
+	var patch map[string]any
+	switch owner.Kind {
+	case KindArgoCDApplication:
+		stored, ok := annotations[AnnotationSuspendedAutomatedSync]
+		if !ok {
+			return nil
+		}
+		var automated any
+		if err := json.Unmarshal([]byte(stored), &automated); err != nil {
+			return fmt.Errorf("invalid annotation %s: %w", AnnotationSuspendedAutomatedSync, err)
+		}
+		patch = map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{AnnotationSuspendedAutomatedSync: nil}},
+			"spec":     map[string]any{"syncPolicy": map[string]any{"automated": automated}},
+		}
+	default:
+		if _, ok := annotations[AnnotationSuspended]; !ok {
+			return nil
+		}
+		patch = map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{AnnotationSuspended: nil}},
+			"spec":     map[string]any{"suspend": nil},
+		}
+	}
+	return mergePatch(ctx, dynamicClient, resource, owner, patch)
+}
+
+// served returns whether the cluster serves any api version of the kind
+func served(discoveryClient discovery.DiscoveryInterface, kind string) (bool, error) {
+	for _, resource := range resources[kind] {
+		list, err := discoveryClient.ServerResourcesForGroupVersion(resource.GroupVersion().String())
+		if kubeerrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, apiResource := range list.APIResources {
+			if apiResource.Name == resource.Resource {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// get returns the owner, using the first API version of its kind that is served by the cluster
+func get(ctx context.Context, dynamicClient dynamic.Interface, owner Owner) (*unstructured.Unstructured, schema.GroupVersionResource, error) {
+	var lastErr error
+	for _, resource := range resources[owner.Kind] {
+		object, err := dynamicClient.Resource(resource).Namespace(owner.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err == nil {
+			return object, resource, nil
+		}
+		// also returned when the cluster does not serve this version
+		if !kubeerrors.IsNotFound(err) {
+			return nil, resource, err
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, schema.GroupVersionResource{}, fmt.Errorf("unsupported kind %q", owner.Kind)
+	}
+	return nil, schema.GroupVersionResource{}, lastErr
+}
+
+func mergePatch(ctx context.Context, dynamicClient dynamic.Interface, resource schema.GroupVersionResource, owner Owner, patch map[string]any) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = dynamicClient.Resource(resource).Namespace(owner.Namespace).Patch(ctx, owner.Name, types.MergePatchType, data, metav1.PatchOptions{})
+	return err
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,181 @@
+package gitops
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	applications   = resources[KindArgoCDApplication][0]
+	helmReleases   = resources[KindFluxHelmRelease][1]
+	kustomizations = resources[KindFluxKustomization][0]
+)
+
+func newTestObject(resource schema.GroupVersionResource, kind, namespace, name string, spec map[string]any) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	object.SetAPIVersion(resource.GroupVersion().String())
+	object.SetKind(kind)
+	object.SetNamespace(namespace)
+	object.SetName(name)
+	return object
+}
+
+func newTestClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	listKinds := map[schema.GroupVersionResource]string{}
+	for kind, versions := range resources {
+		for _, resource := range versions {
+			listKinds[resource] = kind + "List"
+		}
+	}
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+}
+
+// newTestDiscovery returns a discovery client serving the resources
+func newTestDiscovery(served ...schema.GroupVersionResource) *discoveryfake.FakeDiscovery {
+	discoveryClient := &discoveryfake.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	for _, resource := range served {
+		discoveryClient.Resources = append(discoveryClient.Resources, &metav1.APIResourceList{
+			GroupVersion: resource.GroupVersion().String(),
+			APIResources: []metav1.APIResource{{Name: resource.Resource, Namespaced: true}},
+		})
+	}
+	return discoveryClient
+}
+
+func getObject(t *testing.T, client *dynamicfake.FakeDynamicClient, resource schema.GroupVersionResource, namespace, name string) *unstructured.Unstructured {
+	object, err := client.Resource(resource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return object
+}
+
+func TestFindOwners(t *testing.T) {
+	client := newTestClient(
+		newTestObject(applications, KindArgoCDApplication, "argocd", "orders", map[string]any{"destination": map[string]any{"namespace": "databases"}}),
+		newTestObject(applications, KindArgoCDApplication, "team-a", "billing", nil),
+		newTestObject(applications, KindArgoCDApplication, "argocd", "other", map[string]any{"destination": map[string]any{"namespace": "other"}}),
+		newTestObject(helmReleases, KindFluxHelmRelease, "flux-system", "orders", nil),
+		newTestObject(kustomizations, KindFluxKustomization, "databases", "apps", nil),
+	)
+
+	discoveryClient := newTestDiscovery(applications, helmReleases, kustomizations)
+
+	owners, err := FindOwners(context.Background(), client, discoveryClient, metav1.ObjectMeta{
+		Namespace: "databases",
+		Labels: map[string]string{
+			argoCDInstanceLabel:         "orders",
+			fluxHelmNameLabel:           "orders",
+			fluxHelmNamespaceLabel:      "flux-system",
+			fluxKustomizeNameLabel:      "apps",
+			fluxKustomizeNamespaceLabel: "",
+		},
+	}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []Owner{
+		{Kind: KindArgoCDApplication, Namespace: "argocd", Name: "orders"},
+		{Kind: KindFluxHelmRelease, Namespace: "flux-system", Name: "orders"},
+		{Kind: KindFluxKustomization, Namespace: "databases", Name: "apps"},
+	}, owners, "the helm release of flux is found by the second api version")
+
+	owners, err = FindOwners(context.Background(), client, discoveryClient, metav1.ObjectMeta{
+		Namespace:   "databases",
+		Annotations: map[string]string{argoCDTrackingIDAnnotation: "team-a_billing:apps/StatefulSet:databases/billing-postgresql"},
+	}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []Owner{{Kind: KindArgoCDApplication, Namespace: "team-a", Name: "billing"}}, owners)
+
+	owners, err = FindOwners(context.Background(), client, discoveryClient, metav1.ObjectMeta{
+		Namespace: "databases",
+		Labels:    map[string]string{argoCDInstanceLabel: "other"},
+	}, "")
+	require.NoError(t, err)
+	assert.Empty(t, owners, "a helm release label matching an application that deploys elsewhere")
+
+	forbidden := newTestClient()
+	forbidden.PrependReactor("get", "applications", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kubeerrors.NewForbidden(applications.GroupResource(), "orders", errors.New("denied"))
+	})
+	object := metav1.ObjectMeta{Namespace: "databases", Labels: map[string]string{argoCDInstanceLabel: "orders"}}
+	owners, err = FindOwners(context.Background(), forbidden, newTestDiscovery(helmReleases), object, "")
+	require.NoError(t, err)
+	assert.Empty(t, owners, "kinds the cluster does not serve are skipped")
+	_, err = FindOwners(context.Background(), forbidden, discoveryClient, object, "")
+	assert.True(t, kubeerrors.IsForbidden(err), "a forbidden lookup fails, the owner may still sync the object: %v", err)
+}
+
+func TestSuspendAndResume(t *testing.T) {
+	automated := map[string]any{"prune": true, "selfHeal": true}
+	client := newTestClient(
+		newTestObject(applications, KindArgoCDApplication, "argocd", "orders", map[string]any{"syncPolicy": map[string]any{"automated": automated}}),
+		newTestObject(helmReleases, KindFluxHelmRelease, "databases", "orders", map[string]any{"interval": "5m"}),
+		newTestObject(kustomizations, KindFluxKustomization, "databases", "apps", map[string]any{"suspend": true}),
+	)
+	owners := []Owner{
+		{Kind: KindArgoCDApplication, Namespace: "argocd", Name: "orders"},
+		{Kind: KindFluxHelmRelease, Namespace: "databases", Name: "orders"},
+		{Kind: KindFluxKustomization, Namespace: "databases", Name: "apps"},
+	}
+
+	resume, err := Suspend(context.Background(), client, owners)
+	require.NoError(t, err)
+
+	application := getObject(t, client, applications, "argocd", "orders")
+	_, found, _ := unstructured.NestedFieldNoCopy(application.Object, "spec", "syncPolicy", "automated")
+	assert.False(t, found, "automated sync is disabled")
+	assert.JSONEq(t, `{"prune": true, "selfHeal": true}`, application.GetAnnotations()[AnnotationSuspendedAutomatedSync])
+	suspended, _, _ := unstructured.NestedBool(getObject(t, client, helmReleases, "databases", "orders").Object, "spec", "suspend")
+	assert.True(t, suspended)
+
+	require.NoError(t, resume(context.Background()))
+	application = getObject(t, client, applications, "argocd", "orders")
+	restored, _, _ := unstructured.NestedMap(application.Object, "spec", "syncPolicy", "automated")
+	assert.Equal(t, automated, restored)
+	assert.NotContains(t, application.GetAnnotations(), AnnotationSuspendedAutomatedSync)
+	helmRelease := getObject(t, client, helmReleases, "databases", "orders")
+	_, found, _ = unstructured.NestedFieldNoCopy(helmRelease.Object, "spec", "suspend")
+	assert.False(t, found)
+	assert.NotContains(t, helmRelease.GetAnnotations(), AnnotationSuspended)
+	suspended, _, _ = unstructured.NestedBool(getObject(t, client, kustomizations, "databases", "apps").Object, "spec", "suspend")
+	assert.True(t, suspended, "a kustomization suspended by someone else stays suspended")
+}
+
+func TestSuspendResumesAfterInterruptedUpgrade(t *testing.T) {
+	helmRelease := newTestObject(helmReleases, KindFluxHelmRelease, "databases", "orders", map[string]any{"suspend": true})
+	helmRelease.SetAnnotations(map[string]string{AnnotationSuspended: "true"})
+	client := newTestClient(helmRelease)
+
+	resume, err := Suspend(context.Background(), client, []Owner{{Kind: KindFluxHelmRelease, Namespace: "databases", Name: "orders"}})
+	require.NoError(t, err)
+	require.NoError(t, resume(context.Background()))
+	_, found, _ := unstructured.NestedFieldNoCopy(getObject(t, client, helmReleases, "databases", "orders").Object, "spec", "suspend")
+	assert.False(t, found, "the helm release suspended by the interrupted upgrade is resumed")
+}
+
+func TestSuspendFailureResumesSuspendedOwners(t *testing.T) {
+	client := newTestClient(
+		newTestObject(helmReleases, KindFluxHelmRelease, "databases", "orders", nil),
+		newTestObject(kustomizations, KindFluxKustomization, "databases", "apps", nil),
+	)
+	client.PrependReactor("patch", "kustomizations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	_, err := Suspend(context.Background(), client, []Owner{
+		{Kind: KindFluxHelmRelease, Namespace: "databases", Name: "orders"},
+		{Kind: KindFluxKustomization, Namespace: "databases", Name: "apps"},
+	})
+	assert.ErrorContains(t, err, "failed to suspend the sync of Kustomization databases/apps: forbidden")
+	_, found, _ := unstructured.NestedFieldNoCopy(getObject(t, client, helmReleases, "databases", "orders").Object, "spec", "suspend")
+	assert.False(t, found, "the helm release is resumed")
+}
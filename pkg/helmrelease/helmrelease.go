@@ -0,0 +1,202 @@
+package helmrelease
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// LabelManagedBy is Helm on the objects of a Helm release
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	// LabelInstance is the release name in the labels of most charts
+	LabelInstance = "app.kubernetes.io/instance"
+	// AnnotationReleaseName and AnnotationReleaseNamespace are set by Helm 3 on every object of a release
+	AnnotationReleaseName      = "meta.helm.sh/release-name"
+	AnnotationReleaseNamespace = "meta.helm.sh/release-namespace"
+
+	// releaseSecretType is the type of the secrets Helm 3 stores the revisions of a release in
+	releaseSecretType = "helm.sh/release.v1"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// Release is a revision of a Helm release
+type Release struct {
+	Name      string
+	Namespace string
+	// Revision is zero when the release secret could not be read
+	Revision     int
+	Status       string
+	Chart        string
+	ChartVersion string
+	AppVersion   string
+	// Values are the values supplied by the user, without the defaults of the chart
+	Values map[string]any
+}
+
+// helmRelease is the subset of the release stored by Helm that is used here
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+	Info      struct {
+		Status string `json:"status"`
+	} `json:"info"`
+	Chart struct {
+		Metadata struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			AppVersion string `json:"appVersion"`
+		} `json:"metadata"`
+	} `json:"chart"`
+	Config map[string]any `json:"config"`
+}
+
+// ReleaseName returns the name and namespace of the Helm release the object belongs to, ok is false when the object
+// is not managed by Helm
+func ReleaseName(object metav1.ObjectMeta) (name, namespace string, ok bool) {
+	if name := object.Annotations[AnnotationReleaseName]; name != "" {
+		namespace := object.Annotations[AnnotationReleaseNamespace]
+		if namespace == "" {
+			namespace = object.Namespace
+		}
+		return name, namespace, true
+	}
+	// objects created before Helm 3.2 only have the labels of the chart
+	if object.Labels[LabelManagedBy] != "Helm" && object.Labels["heritage"] != "Helm" {
+		return "", "", false
+	}
+	for _, label := range []string{LabelInstance, "release"} {
+		if name := object.Labels[label]; name != "" {
+			return name, object.Namespace, true
+		}
+	}
+	return "", "", false
+}
+
+// Find returns the latest deployed revision of the Helm release the object belongs to, or nil when the object is not
+// managed by Helm. When the release secret cannot be read the release is returned with only its name and namespace,
+// together with the error.
+func Find(ctx context.Context, k8sClient kubernetes.Interface, object metav1.ObjectMeta) (*Release, error) {
+	name, namespace, ok := ReleaseName(object)
+	if !ok {
+		return nil, nil
+	}
+	release := &Release{Name: name, Namespace: namespace}
+
+	secrets, err := k8sClient.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("owner=helm,name=%s", name),
+	})
+	if err != nil {
+		return release, fmt.Errorf("failed to list the secrets of helm release %s/%s: %w", namespace, name, err)
+	}
+	var latest *Release
+	for _, secret := range secrets.Items {
+		if string(secret.Type) != releaseSecretType {
+			continue
+		}
+		revision, err := Decode(secret.Data["release"])
+		if err != nil {
+			return release, fmt.Errorf("failed to decode helm release secret %s/%s: %w", namespace, secret.Name, err)
+		}
+		if latest == nil || revision.preferredOver(latest) {
+			latest = revision
+		}
+	}
+	if latest == nil {
+		return release, fmt.Errorf("no release secret found for helm release %s/%s", namespace, name)
+	}
+	return latest, nil
+}
+
+// preferredOver returns true when r is a later revision than other, a failed or pending revision is only used when
+// no revision has been deployed
+func (r *Release) preferredOver(other *Release) bool {
+	deployed, otherDeployed := r.Status == "deployed", other.Status == "deployed"
+	if deployed != otherDeployed {
+		return deployed
+	}
+	return r.Revision > other.Revision
+}
+
+// Decode decodes the release stored by Helm in the release key of a release secret: base64 encoded, gzipped JSON
+func Decode(data []byte) (*Release, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if bytes.HasPrefix(decoded, gzipMagic) {
+		reader, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		decoded, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip: %w", err)
+		}
+	}
+	stored := &helmRelease{}
+	if err := json.Unmarshal(decoded, stored); err != nil {
+		return nil, fmt.Errorf("invalid release: %w", err)
+	}
+	return &Release{
+		Name:         stored.Name,
+		Namespace:    stored.Namespace,
+		Revision:     stored.Version,
+		Status:       stored.Info.Status,
+		Chart:        stored.Chart.Metadata.Name,
+		ChartVersion: stored.Chart.Metadata.Version,
+		AppVersion:   stored.Chart.Metadata.AppVersion,
+		Values:       stored.Config,
+	}, nil
+}
+
+// String returns the release with its chart, for messages
+func (r *Release) String() string {
+	if r.Chart == "" {
+		return fmt.Sprintf("%s/%s", r.Namespace, r.Name)
+	}
+	return fmt.Sprintf("%s/%s (chart %s-%s, revision %d)", r.Namespace, r.Name, r.Chart, r.ChartVersion, r.Revision)
+}
+
+// ValuesPatch returns the values to pass to the next helm upgrade of the release, so it runs the image tag on the
+// existing claim. The claim is omitted when empty, for the claim created by the chart itself: setting it removes the
+// volumeClaimTemplates of the statefulset, which can not be changed. The Bitnami postgresql chart moved the
+// persistence values below primary in chart version 11.
+func (r *Release) ValuesPatch(imageTag, existingClaim string) ([]byte, error) {
+	values := map[string]any{"image": map[string]any{"tag": imageTag}}
+	if existingClaim == "" {
+		return yaml.Marshal(values)
+	}
+	persistence := map[string]any{"existingClaim": existingClaim}
+	if r.usesPrimaryValues() {
+		values["primary"] = map[string]any{"persistence": persistence}
+	} else {
+		values["persistence"] = persistence
+	}
+	return yaml.Marshal(values)
+}
+
+func (r *Release) usesPrimaryValues() bool {
+	if _, ok := r.Values["primary"]; ok {
+		return true
+	}
+	if _, ok := r.Values["persistence"]; ok {
+		return false
+	}
+	major, _, _ := strings.Cut(r.ChartVersion, ".")
+	version, err := strconv.Atoi(major)
+	return r.Chart == "postgresql" && err == nil && version >= 11
+}
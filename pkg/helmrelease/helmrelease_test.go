@@ -0,0 +1,129 @@
+package helmrelease
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// encodeRelease encodes a release in the same way as the secret storage driver of Helm
+func encodeRelease(t *testing.T, release map[string]any) []byte {
+	data, err := json.Marshal(release)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err = writer.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return []byte(base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+func newReleaseSecret(t *testing.T, revision int, status, chartVersion string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "databases",
+			Name:      fmt.Sprintf("sh.helm.release.v1.orders.v%d", revision),
+			Labels:    map[string]string{"owner": "helm", "name": "orders", "status": status},
+		},
+		Type: releaseSecretType,
+		Data: map[string][]byte{"release": encodeRelease(t, map[string]any{
+			"name":      "orders",
+			"namespace": "databases",
+			"version":   revision,
+			"info":      map[string]any{"status": status},
+			"chart":     map[string]any{"metadata": map[string]any{"name": "postgresql", "version": chartVersion, "appVersion": "11.14.0"}},
+			"config":    map[string]any{"auth": map[string]any{"database": "orders"}},
+		})},
+	}
+}
+
+func TestReleaseName(t *testing.T) {
+	tests := []struct {
+		name          string
+		object        metav1.ObjectMeta
+		wantName      string
+		wantNamespace string
+		wantOK        bool
+	}{
+		{
+			name: "annotations",
+			object: metav1.ObjectMeta{Namespace: "databases", Annotations: map[string]string{
+				AnnotationReleaseName: "orders", AnnotationReleaseNamespace: "releases",
+			}},
+			wantName: "orders", wantNamespace: "releases", wantOK: true,
+		},
+		{
+			name:     "labels",
+			object:   metav1.ObjectMeta{Namespace: "databases", Labels: map[string]string{LabelManagedBy: "Helm", LabelInstance: "orders"}},
+			wantName: "orders", wantNamespace: "databases", wantOK: true,
+		},
+		{
+			name:   "not managed by helm",
+			object: metav1.ObjectMeta{Namespace: "databases", Labels: map[string]string{LabelInstance: "orders"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, namespace, ok := ReleaseName(tt.object)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantNamespace, namespace)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(
+		newReleaseSecret(t, 1, "superseded", "10.16.2"),
+		newReleaseSecret(t, 2, "deployed", "11.9.13"),
+		newReleaseSecret(t, 3, "failed", "12.1.0"),
+	)
+	object := metav1.ObjectMeta{Namespace: "databases", Annotations: map[string]string{AnnotationReleaseName: "orders"}}
+
+	release, err := Find(context.Background(), k8sClient, object)
+	require.NoError(t, err)
+	assert.Equal(t, 2, release.Revision, "the deployed revision is used")
+	assert.Equal(t, "postgresql", release.Chart)
+	assert.Equal(t, "11.9.13", release.ChartVersion)
+	assert.Equal(t, map[string]any{"auth": map[string]any{"database": "orders"}}, release.Values)
+	assert.Equal(t, "databases/orders (chart postgresql-11.9.13, revision 2)", release.String())
+
+	release, err = Find(context.Background(), k8sClient, metav1.ObjectMeta{Namespace: "databases"})
+	assert.NoError(t, err)
+	assert.Nil(t, release, "not managed by helm")
+
+	release, err = Find(context.Background(), fake.NewSimpleClientset(), object)
+	assert.ErrorContains(t, err, "no release secret found")
+	assert.Equal(t, "databases/orders", release.String(), "the release is returned without the details of the secret")
+}
+
+func TestValuesPatch(t *testing.T) {
+	release := &Release{Chart: "postgresql", ChartVersion: "12.1.0"}
+	patch, err := release.ValuesPatch("15.4.0", "data-orders-postgresql-0")
+	require.NoError(t, err)
+	assert.Equal(t, "image:\n  tag: 15.4.0\nprimary:\n  persistence:\n    existingClaim: data-orders-postgresql-0\n", string(patch))
+
+	release = &Release{Chart: "postgresql", ChartVersion: "10.16.2"}
+	patch, err = release.ValuesPatch("15.4.0", "data-orders-postgresql-0")
+	require.NoError(t, err)
+	assert.Equal(t, "image:\n  tag: 15.4.0\npersistence:\n  existingClaim: data-orders-postgresql-0\n", string(patch), "before chart version 11 persistence is a top level value")
+
+	release = &Release{Chart: "postgresql", ChartVersion: "10.16.2", Values: map[string]any{"primary": map[string]any{}}}
+	patch, err = release.ValuesPatch("15.4.0", "data-orders-postgresql-0")
+	require.NoError(t, err)
+	assert.Contains(t, string(patch), "primary:", "the values of the release take precedence over the chart version")
+
+	patch, err = release.ValuesPatch("15.4.0", "")
+	require.NoError(t, err)
+	assert.Equal(t, "image:\n  tag: 15.4.0\n", string(patch), "the claim of the chart is not set as existing claim")
+}
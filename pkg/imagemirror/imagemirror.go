@@ -0,0 +1,141 @@
+package imagemirror
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultRegistry  = "docker.io"
+	defaultNamespace = "library"
+)
+
+// Config maps container image prefixes to mirror prefixes, so the images generated by
+// kube-pg-upgrade can be pulled from a registry mirror in air-gapped clusters.
+//
+// Example:
+//
+//	mirrors:
+//	  - source: docker.io/tianon/postgres-upgrade
+//	    mirror: registry.example.com/mirror/tianon/postgres-upgrade
+//	digests:
+//	  registry.example.com/mirror/tianon/postgres-upgrade:11-to-15: sha256:...
+type Config struct {
+	Mirrors []Mirror `json:"mirrors"`
+	// Digests optionally pins an image reference to a digest. The key is the image reference
+	// either before or after the mirror has been applied.
+	Digests map[string]string `json:"digests,omitempty"`
+}
+
+type Mirror struct {
+	// Source is the image prefix that will be replaced, for example docker.io/tianon
+	Source string `json:"source"`
+	// Mirror is the prefix used instead of the source prefix, for example registry.example.com/tianon
+	Mirror string `json:"mirror"`
+}
+
+// LoadConfig reads a mirror configuration from a YAML or JSON file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image mirror config %q: %w", path, err)
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse image mirror config %q: %w", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid image mirror config %q: %w", path, err)
+	}
+	return config, nil
+}
+
+func (c *Config) Validate() error {
+	for i, mirror := range c.Mirrors {
+		if mirror.Source == "" {
+			return fmt.Errorf("mirror %d: source must not be empty", i)
+		}
+		if mirror.Mirror == "" {
+			return fmt.Errorf("mirror %d: mirror must not be empty", i)
+		}
+	}
+	for image, digest := range c.Digests {
+		if !strings.Contains(digest, ":") {
+			return fmt.Errorf("digest %q for image %q must be in the form <algorithm>:<hex>", digest, image)
+		}
+	}
+	return nil
+}
+
+// Apply rewrites the image using the longest matching mirror prefix and pins it to a digest if one is configured.
+// Images that do not match any mirror are returned unchanged.
+func (c *Config) Apply(image string) string {
+	if c == nil {
+		return image
+	}
+
+	result := image
+	normalizedImage := Normalize(image)
+
+	mirrors := make([]Mirror, len(c.Mirrors))
+	copy(mirrors, c.Mirrors)
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		return len(normalizePrefix(mirrors[i].Source)) > len(normalizePrefix(mirrors[j].Source))
+	})
+	for _, mirror := range mirrors {
+		if remainder, ok := cutImagePrefix(normalizedImage, normalizePrefix(mirror.Source)); ok {
+			result = strings.TrimSuffix(mirror.Mirror, "/") + remainder
+			break
+		}
+	}
+
+	if strings.Contains(result, "@") {
+		return result
+	}
+	for _, key := range []string{result, image, normalizedImage} {
+		if digest, ok := c.Digests[key]; ok {
+			return result + "@" + digest
+		}
+	}
+	return result
+}
+
+// Normalize expands an image reference to its fully qualified form, for example
+// postgres:15 becomes docker.io/library/postgres:15.
+func Normalize(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if !found {
+		return defaultRegistry + "/" + defaultNamespace + "/" + image
+	}
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return defaultRegistry + "/" + image
+	}
+	return image
+}
+
+// normalizePrefix normalizes a mirror source, which may also be a registry host such as docker.io
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.Contains(prefix, "/") && (strings.ContainsAny(prefix, ".:") || prefix == "localhost") {
+		return prefix
+	}
+	return Normalize(prefix)
+}
+
+// cutImagePrefix returns the remainder of image after prefix, only matching on whole path
+// components so docker.io/tianon does not match docker.io/tianonx.
+func cutImagePrefix(image, prefix string) (string, bool) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	remainder, ok := strings.CutPrefix(image, prefix)
+	if !ok {
+		return "", false
+	}
+	if remainder == "" || strings.ContainsAny(remainder[:1], "/:@") {
+		return remainder, true
+	}
+	return "", false
+}
@@ -0,0 +1,35 @@
+package imagemirror
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "docker.io/library/postgres:15", Normalize("postgres:15"))
+	assert.Equal(t, "docker.io/tianon/postgres-upgrade:11-to-15", Normalize("tianon/postgres-upgrade:11-to-15"))
+	assert.Equal(t, "registry.example.com/tianon/postgres-upgrade", Normalize("registry.example.com/tianon/postgres-upgrade"))
+	assert.Equal(t, "localhost/postgres", Normalize("localhost/postgres"))
+}
+
+func TestApply(t *testing.T) {
+	config := &Config{
+		Mirrors: []Mirror{
+			{Source: "docker.io", Mirror: "mirror.example.com/dockerhub"},
+			{Source: "tianon/postgres-upgrade", Mirror: "registry.example.com/pg/upgrade"},
+		},
+		Digests: map[string]string{
+			"registry.example.com/pg/upgrade:11-to-15": "sha256:abc",
+		},
+	}
+
+	assert.Equal(t, "registry.example.com/pg/upgrade:11-to-15@sha256:abc", config.Apply("tianon/postgres-upgrade:11-to-15"))
+	assert.Equal(t, "registry.example.com/pg/upgrade:11-to-16", config.Apply("docker.io/tianon/postgres-upgrade:11-to-16"))
+	assert.Equal(t, "mirror.example.com/dockerhub/library/postgres:15", config.Apply("postgres:15"))
+	assert.Equal(t, "quay.io/example/postgres:15", config.Apply("quay.io/example/postgres:15"))
+	assert.Equal(t, "mirror.example.com/dockerhub/tianon/postgres-upgradex:1", config.Apply("tianon/postgres-upgradex:1"))
+
+	var empty *Config
+	assert.Equal(t, "postgres:15", empty.Apply("postgres:15"))
+}
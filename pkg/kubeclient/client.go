@@ -4,31 +4,31 @@ import (
 	"os"
 	"path/filepath"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// GetClientConfig returns the kubeconfig of the default Options
 func GetClientConfig() (clientcmd.ClientConfig, error) {
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	// if you want to change the loading rules (which files in which order), you can do so here
+	return (&Options{}).ClientConfig(), nil
+}
 
-	configOverrides := &clientcmd.ConfigOverrides{}
-	// if you want to change override values or bind them to flags, there are methods to help you
+// GetClientAndNamespace creates a new k8s client from the currently configured kubecontext and returns it with
+// the namespace of the kubecontext
+func GetClientAndNamespace() (*kubernetes.Clientset, string, error) {
+	return (&Options{}).NewClientAndNamespace()
+}
 
-	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides), nil
+// GetDynamicClient creates a new dynamic client from the currently configured kubecontext
+func GetDynamicClient() (dynamic.Interface, error) {
+	return (&Options{}).NewDynamicClient()
 }
 
 // GetClient creates a new k8s client object from the currently configured kubecontext
 func GetClient() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		config, err = clientcmd.BuildConfigFromFlags("", getKubeConfig())
-		if err != nil {
-			return nil, err
-		}
-	}
-	return kubernetes.NewForConfigOrDie(config), nil
+	return (&Options{}).NewClient()
 }
 
 func GetClientOrDie() *kubernetes.Clientset {
@@ -75,8 +75,8 @@ func GetClientWithConfig(kubeconfigFile *rest.Config) (*kubernetes.Clientset, er
 	return clientset, nil
 }
 
-// GetKubeConfig returns the currently configured kubeconfig file location
-// or error if non has been configured
+// GetKubeConfig returns the currently configured kubeconfig file location,
+// or an empty string if none has been configured
 func GetKubeConfig() string {
 	return getKubeConfig()
 }
@@ -89,7 +89,7 @@ func getKubeConfig() string {
 	if home := homeDir(); home != "" {
 		return filepath.Join(home, ".kube", "config")
 	}
-	panic("Missing kubeconfig configuration ...")
+	return ""
 }
 
 func homeDir() string {
@@ -0,0 +1,120 @@
+package kubeclient
+
+import (
+	"context"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Options selects the kubeconfig, context and identity of the clients, like the flags of kubectl. The zero value
+// uses the KUBECONFIG environment variable or ~/.kube/config, and falls back to the in-cluster config.
+type Options struct {
+	// Kubeconfig is the kubeconfig file, overriding the KUBECONFIG environment variable
+	Kubeconfig string
+	// Context and Cluster override the current context of the kubeconfig and its cluster
+	Context string
+	Cluster string
+	// Impersonate and ImpersonateGroups are the user and groups the requests are made as
+	Impersonate       string
+	ImpersonateGroups []string
+	// RequestTimeout is the timeout of a single request, zero means no timeout
+	RequestTimeout time.Duration
+	// QPS and Burst limit the requests to the API server, the client-go defaults are used when zero
+	QPS   float32
+	Burst int
+}
+
+func AddFlags(flagSet *flag.FlagSet, opts *Options) {
+	flagSet.StringVar(&opts.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file. Defaults to the KUBECONFIG environment variable or ~/.kube/config, and the in-cluster config when neither exists.")
+	flagSet.StringVar(&opts.Context, "context", "", "Name of the kubeconfig context to use.")
+	flagSet.StringVar(&opts.Cluster, "cluster", "", "Name of the kubeconfig cluster to use.")
+	flagSet.StringVar(&opts.Impersonate, "as", "", "Username to impersonate for the requests.")
+	flagSet.StringArrayVar(&opts.ImpersonateGroups, "as-group", nil, "Group to impersonate for the requests. Can be repeated.")
+	flagSet.DurationVar(&opts.RequestTimeout, "request-timeout", 0, "Timeout of a single request to the API server, including the log streams of the upgrade pods, zero means no timeout. Does not limit the duration of an upgrade, see --timeout.")
+	flagSet.Float32Var(&opts.QPS, "kube-api-qps", 0, "Maximum requests per second to the API server. Defaults to the client-go default of 5.")
+	flagSet.IntVar(&opts.Burst, "kube-api-burst", 0, "Maximum burst of requests to the API server. Defaults to the client-go default of 10.")
+}
+
+// ClientConfig returns the kubeconfig with the overrides of the options
+func (o *Options) ClientConfig() clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if o == nil {
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
+	}
+	loadingRules.ExplicitPath = o.Kubeconfig
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.Context}
+	overrides.Context.Cluster = o.Cluster
+	overrides.AuthInfo.Impersonate = o.Impersonate
+	overrides.AuthInfo.ImpersonateGroups = o.ImpersonateGroups
+	if o.RequestTimeout > 0 {
+		overrides.Timeout = o.RequestTimeout.String()
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+}
+
+// RESTConfig returns the config of the clients
+func (o *Options) RESTConfig() (*rest.Config, error) {
+	config, err := o.ClientConfig().ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if o != nil && o.QPS > 0 {
+		config.QPS = o.QPS
+	}
+	if o != nil && o.Burst > 0 {
+		config.Burst = o.Burst
+	}
+	return config, nil
+}
+
+// NewClient returns a client using the options
+func (o *Options) NewClient() (*kubernetes.Clientset, error) {
+	config, err := o.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return GetClientWithConfig(config)
+}
+
+// NewClientAndNamespace returns a client using the options and the namespace of the selected context
+func (o *Options) NewClientAndNamespace() (*kubernetes.Clientset, string, error) {
+	client, err := o.NewClient()
+	if err != nil {
+		return nil, "", err
+	}
+	namespace, _, err := o.ClientConfig().Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	return client, namespace, nil
+}
+
+// NewDynamicClient returns a dynamic client using the options
+func (o *Options) NewDynamicClient() (dynamic.Interface, error) {
+	config, err := o.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(config)
+}
+
+type optionsKey struct{}
+
+// NewContext returns a context carrying the options, for the subcommands to create their clients with
+func NewContext(ctx context.Context, opts *Options) context.Context {
+	return context.WithValue(ctx, optionsKey{}, opts)
+}
+
+// FromContext returns the options of the context, or the default options when none were set
+func FromContext(ctx context.Context) *Options {
+	if opts, ok := ctx.Value(optionsKey{}).(*Options); ok {
+		return opts
+	}
+	return &Options{}
+}
@@ -0,0 +1,84 @@
+package kubeclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+    namespace: development
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+    namespace: databases
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func TestOptions(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(testKubeconfig), 0o600))
+
+	opts := &Options{Kubeconfig: kubeconfig}
+	config, err := opts.RESTConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://dev.example.com", config.Host)
+	namespace, _, err := opts.ClientConfig().Namespace()
+	require.NoError(t, err)
+	assert.Equal(t, "development", namespace)
+
+	opts = &Options{
+		Kubeconfig:        kubeconfig,
+		Context:           "prod",
+		Impersonate:       "jane",
+		ImpersonateGroups: []string{"dba"},
+		RequestTimeout:    30 * time.Second,
+		QPS:               50,
+		Burst:             100,
+	}
+	config, err = opts.RESTConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com", config.Host)
+	assert.Equal(t, "jane", config.Impersonate.UserName)
+	assert.Equal(t, []string{"dba"}, config.Impersonate.Groups)
+	assert.Equal(t, 30*time.Second, config.Timeout)
+	assert.Equal(t, float32(50), config.QPS)
+	assert.Equal(t, 100, config.Burst)
+	_, namespace, err = opts.NewClientAndNamespace()
+	require.NoError(t, err)
+	assert.Equal(t, "databases", namespace)
+
+	config, err = (&Options{Kubeconfig: kubeconfig, Cluster: "prod"}).RESTConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com", config.Host, "the cluster overrides the cluster of the context")
+}
+
+func TestGetKubeConfigWithoutHome(t *testing.T) {
+	t.Setenv("KUBECONFIG", "")
+	t.Setenv("HOME", "")
+	t.Setenv("USERPROFILE", "")
+	os.Unsetenv("KUBECONFIG")
+	assert.Equal(t, "", GetKubeConfig())
+}
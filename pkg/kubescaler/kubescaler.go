@@ -13,8 +13,9 @@ type KubeScaler struct {
 	namespace string
 }
 
-func NewKubeScaler(namespace string) (*KubeScaler, error) {
-	k8sclient, err := kubeclient.GetClient()
+// NewKubeScaler returns a scaler with a client created from clientOptions, the default options are used when nil
+func NewKubeScaler(namespace string, clientOptions *kubeclient.Options) (*KubeScaler, error) {
+	k8sclient, err := clientOptions.NewClient()
 	if err != nil {
 		return nil, err
 	}
@@ -25,8 +26,8 @@ func NewKubeScaler(namespace string) (*KubeScaler, error) {
 	}, nil
 }
 
-func NewKubeScalerOrDie(namespace string) *KubeScaler {
-	client, err := NewKubeScaler(namespace)
+func NewKubeScalerOrDie(namespace string, clientOptions *kubeclient.Options) *KubeScaler {
+	client, err := NewKubeScaler(namespace, clientOptions)
 	if err != nil {
 		panic(err)
 	}
@@ -65,3 +66,11 @@ func (a *KubeScaler) ScaleStatefulSet(ctx context.Context, statefulSetName strin
 	}
 	return nil
 }
+
+func (a *KubeScaler) GetStatefulSetReplicas(ctx context.Context, statefulSetName string) (int32, error) {
+	scale, err := a.client.AppsV1().StatefulSets(a.namespace).GetScale(ctx, statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return scale.Spec.Replicas, nil
+}
@@ -11,10 +11,11 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
 	"github.com/containerinfra/kube-pg-upgrade/pkg/ptrs"
 )
 
-func GetDefaultStorageClassName(ctx context.Context, k8sclient *kubernetes.Clientset) (string, error) {
+func GetDefaultStorageClassName(ctx context.Context, k8sclient kubernetes.Interface) (string, error) {
 	storageClasses, err := k8sclient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return "", fmt.Errorf("error validating storage classes: %w", err)
@@ -32,7 +33,7 @@ func GetDefaultStorageClassName(ctx context.Context, k8sclient *kubernetes.Clien
 	return "", fmt.Errorf("no default storage class installed in cluster")
 }
 
-func ValidateStorageClassExists(ctx context.Context, client *kubernetes.Clientset, storageClassName string) error {
+func ValidateStorageClassExists(ctx context.Context, client kubernetes.Interface, storageClassName string) error {
 	_, err := client.StorageV1().StorageClasses().Get(ctx, storageClassName, metav1.GetOptions{})
 	if err != nil {
 		if kubeerrors.IsNotFound(err) {
@@ -55,7 +56,7 @@ func SetPVReclaimPolicy(ctx context.Context, k8sClient kubernetes.Interface, pvc
 	}
 
 	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
-		fmt.Printf("PV %s does not have %s as the reclaim policy, updating ...\n", pvc.Spec.VolumeName, policy)
+		progress.Printf(ctx, "PV %s does not have %s as the reclaim policy, updating ...\n", pvc.Spec.VolumeName, policy)
 		pv.Spec.PersistentVolumeReclaimPolicy = policy
 		_, err = k8sClient.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{})
 		if err != nil {
@@ -65,7 +66,7 @@ func SetPVReclaimPolicy(ctx context.Context, k8sClient kubernetes.Interface, pvc
 		// give kube some time to catch up
 		time.Sleep(1 * time.Second)
 	} else {
-		fmt.Printf("PV %s already has retain as the reclaim policy...\n", pvc.Spec.VolumeName)
+		progress.Printf(ctx, "PV %s already has retain as the reclaim policy...\n", pvc.Spec.VolumeName)
 	}
 
 	// give kube some time to catch up
@@ -108,7 +109,7 @@ func RemoveClaimRefOfPV(ctx context.Context, k8sClient kubernetes.Interface, pvc
 	if err != nil {
 		return fmt.Errorf("failed to remove claimref of persistent volume claim %q: %w", pv.Name, err)
 	}
-	fmt.Printf("removed the PV %s claim ref to %s...\n", pvc.Spec.VolumeName, pvc.Name)
+	progress.Printf(ctx, "removed the PV %s claim ref to %s...\n", pvc.Spec.VolumeName, pvc.Name)
 	return nil
 }
 
@@ -123,7 +124,7 @@ func SetClaimRefOfPV(ctx context.Context, k8sClient kubernetes.Interface, volume
 	if err != nil {
 		return fmt.Errorf("failed to update claimref persistent volume claim %q: %w", volumeName, err)
 	}
-	fmt.Printf("set the PV %s claim ref to %s in namespace %s...\n", volumeName, claimRef.Name, claimRef.Namespace)
+	progress.Printf(ctx, "set the PV %s claim ref to %s in namespace %s...\n", volumeName, claimRef.Name, claimRef.Namespace)
 	return nil
 }
 
@@ -147,7 +148,7 @@ func CreatePersistentVolumeClaim(ctx context.Context, k8sClient kubernetes.Inter
 		return fmt.Errorf("failed to create persistent volume claim %q: %w", pvcName, err)
 	}
 
-	fmt.Printf("created a new PVC %s in namespace %s...\n", pvcName, namespace)
+	progress.Printf(ctx, "created a new PVC %s in namespace %s...\n", pvcName, namespace)
 	return nil
 }
 
@@ -155,12 +156,12 @@ func WaitForPVCToBeDeleted(ctx context.Context, k8sClient kubernetes.Interface,
 	for {
 		_, err := k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvc, metav1.GetOptions{})
 		if err != nil && kubeerrors.IsNotFound(err) {
-			fmt.Printf("source pvc %s is deleted\n", pvc)
+			progress.Printf(ctx, "source pvc %s is deleted\n", pvc)
 			return nil
 		} else if err != nil {
 			return fmt.Errorf("error deleting source pvc: %s", err)
 		}
-		fmt.Printf("source pvc %s still in the proces of being deleted...\n", pvc)
+		progress.Printf(ctx, "source pvc %s still in the proces of being deleted...\n", pvc)
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -0,0 +1,175 @@
+// Package metrics collects metrics of upgrade runs and exposes them in the Prometheus text format, as a node-exporter
+// textfile or pushed to a Pushgateway.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Types of a metric in the Prometheus text format
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+)
+
+// Desc describes a metric
+type Desc struct {
+	Name string
+	Help string
+	Type string
+}
+
+// Metrics of upgrade runs
+var (
+	UpgradesTotal = Desc{
+		Name: "kube_pg_upgrade_upgrades_total",
+		Help: "Number of upgrades run, by namespace, postgres versions and result.",
+		Type: TypeCounter,
+	}
+	UpgradeInfo = Desc{
+		Name: "kube_pg_upgrade_upgrade_info",
+		Help: "Postgres versions of the upgrade of a database, always 1.",
+		Type: TypeGauge,
+	}
+	PhaseDurationSeconds = Desc{
+		Name: "kube_pg_upgrade_phase_duration_seconds",
+		Help: "Duration of a phase of the upgrade of a database.",
+		Type: TypeGauge,
+	}
+	PhaseFailed = Desc{
+		Name: "kube_pg_upgrade_phase_failed",
+		Help: "Whether a phase of the upgrade of a database failed.",
+		Type: TypeGauge,
+	}
+	DataDirectoryBytes = Desc{
+		Name: "kube_pg_upgrade_data_directory_bytes",
+		Help: "Size of the data directory before and after the upgrade of a database.",
+		Type: TypeGauge,
+	}
+	CopiedBytes = Desc{
+		Name: "kube_pg_upgrade_copied_bytes",
+		Help: "Number of bytes pg_upgrade copied to the new data directory of a database.",
+		Type: TypeGauge,
+	}
+	PodWaitSecondsTotal = Desc{
+		Name: "kube_pg_upgrade_pod_wait_seconds_total",
+		Help: "Time spent waiting for task pods to start, by phase of the upgrade of a database.",
+		Type: TypeCounter,
+	}
+)
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+type family struct {
+	desc    Desc
+	samples map[string]*sample
+}
+
+// Registry holds the current value of every metric. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Set sets the value of the metric with the given labels
+func (r *Registry) Set(desc Desc, labels map[string]string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.getSample(desc, labels).value = value
+}
+
+// Add adds value to the metric with the given labels
+func (r *Registry) Add(desc Desc, labels map[string]string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.getSample(desc, labels).value += value
+}
+
+func (r *Registry) getSample(desc Desc, labels map[string]string) *sample {
+	f, ok := r.families[desc.Name]
+	if !ok {
+		f = &family{desc: desc, samples: map[string]*sample{}}
+		r.families[desc.Name] = f
+	}
+	formatted := formatLabels(labels)
+	s, ok := f.samples[formatted]
+	if !ok {
+		s = &sample{labels: formatted}
+		f.samples[formatted] = s
+	}
+	return s
+}
+
+// Write writes all metrics in the Prometheus text format, sorted by name and labels
+func (r *Registry) Write(out io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, escapeHelp(f.desc.Help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.desc.Type)
+
+		samples := make([]*sample, 0, len(f.samples))
+		for _, s := range f.samples {
+			samples = append(samples, s)
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i].labels < samples[j].labels })
+		for _, s := range samples {
+			fmt.Fprintf(&b, "%s%s %s\n", name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// formatLabels returns the labels as {name="value",...} sorted by name, labels with an empty value are left out
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name, value := range labels {
+		if value != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(labels[name])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	registry := NewRegistry()
+	registry.Add(UpgradesTotal, map[string]string{"namespace": "db", "result": "success"}, 1)
+	registry.Add(UpgradesTotal, map[string]string{"result": "success", "namespace": "db"}, 1)
+	registry.Set(CopiedBytes, map[string]string{"namespace": "db", "database": "pg"}, 1.5e9)
+	registry.Set(PhaseDurationSeconds, map[string]string{"database": "a\"b\\c\nd", "phase": "", "namespace": "db"}, 0.25)
+
+	out := &strings.Builder{}
+	require.NoError(t, registry.Write(out))
+	assert.Equal(t, `# HELP kube_pg_upgrade_copied_bytes Number of bytes pg_upgrade copied to the new data directory of a database.
+# TYPE kube_pg_upgrade_copied_bytes gauge
+kube_pg_upgrade_copied_bytes{database="pg",namespace="db"} 1.5e+09
+# HELP kube_pg_upgrade_phase_duration_seconds Duration of a phase of the upgrade of a database.
+# TYPE kube_pg_upgrade_phase_duration_seconds gauge
+kube_pg_upgrade_phase_duration_seconds{database="a\"b\\c\nd",namespace="db"} 0.25
+# HELP kube_pg_upgrade_upgrades_total Number of upgrades run, by namespace, postgres versions and result.
+# TYPE kube_pg_upgrade_upgrades_total counter
+kube_pg_upgrade_upgrades_total{namespace="db",result="success"} 2
+`, out.String())
+}
+
+func TestRegistryWriteEmpty(t *testing.T) {
+	out := &strings.Builder{}
+	require.NoError(t, NewRegistry().Write(out))
+	assert.Empty(t, out.String())
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kube_pg_upgrade.prom")
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o644))
+
+	registry := NewRegistry()
+	registry.Set(CopiedBytes, nil, 10)
+	require.NoError(t, registry.WriteTextfile(path))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "kube_pg_upgrade_copied_bytes 10\n")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	// the temporary file has been renamed
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteTextfileMissingDirectory(t *testing.T) {
+	err := NewRegistry().WriteTextfile(filepath.Join(t.TempDir(), "missing", "kube_pg_upgrade.prom"))
+	assert.Error(t, err)
+}
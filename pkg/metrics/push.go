@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const textFormatContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Push replaces the metrics of job on the Pushgateway at gatewayURL with the metrics of the registry
+func (r *Registry) Push(ctx context.Context, client *http.Client, gatewayURL, job string) error {
+	if job == "" {
+		return fmt.Errorf("pushgateway job name must not be empty")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	body := &bytes.Buffer{}
+	if err := r.Write(body); err != nil {
+		return err
+	}
+	pushURL := strings.TrimSuffix(gatewayURL, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, pushURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to %q: %w", gatewayURL, err)
+	}
+	req.Header.Set("Content-Type", textFormatContentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to %q: %w", gatewayURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to push metrics to %q: %s: %s", gatewayURL, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPush(t *testing.T) {
+	var method, path, contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.EscapedPath()
+		contentType = r.Header.Get("Content-Type")
+		content, _ := io.ReadAll(r.Body)
+		body = string(content)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	registry := NewRegistry()
+	registry.Add(UpgradesTotal, map[string]string{"result": "success"}, 1)
+	require.NoError(t, registry.Push(context.Background(), server.Client(), server.URL+"/", "kube pg/upgrade"))
+
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/metrics/job/kube%20pg%2Fupgrade", path)
+	assert.Equal(t, textFormatContentType, contentType)
+	assert.Contains(t, body, "kube_pg_upgrade_upgrades_total{result=\"success\"} 1\n")
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "pushed metrics are invalid", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := NewRegistry().Push(context.Background(), server.Client(), server.URL, "kube-pg-upgrade")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 Bad Request: pushed metrics are invalid")
+
+	err = NewRegistry().Push(context.Background(), server.Client(), server.URL, "")
+	assert.Error(t, err)
+}
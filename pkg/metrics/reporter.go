@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+// Results of an upgrade
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Reporter records the metrics of a single upgrade from its progress events. Use a reporter per database, the
+// database is taken from the objects of the upgrade phase.
+type Reporter struct {
+	registry *Registry
+
+	mu          sync.Mutex
+	namespace   string
+	database    string
+	fromVersion string
+	toVersion   string
+}
+
+// NewReporter returns a reporter recording the metrics of an upgrade in the registry
+func (r *Registry) NewReporter() *Reporter {
+	return &Reporter{registry: r}
+}
+
+func (r *Reporter) Report(event progress.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch event.Type {
+	case progress.EventPhaseStart:
+		if event.Phase == progress.PhaseUpgrade {
+			r.namespace = event.Objects["namespace"]
+			r.database = event.Objects["statefulset"]
+			if r.database == "" {
+				r.database = event.Objects["pvc"]
+			}
+		}
+	case progress.EventPhaseEnd:
+		failed := 0.0
+		if event.Error != "" {
+			failed = 1
+		}
+		r.registry.Set(PhaseDurationSeconds, r.databaseLabels("phase", event.Phase), event.DurationSeconds)
+		r.registry.Set(PhaseFailed, r.databaseLabels("phase", event.Phase), failed)
+		if event.Phase == progress.PhaseUpgrade {
+			result := ResultSuccess
+			if event.Error != "" {
+				result = ResultFailure
+			}
+			r.registry.Add(UpgradesTotal, map[string]string{
+				"namespace":    r.namespace,
+				"from_version": r.fromVersion,
+				"to_version":   r.toVersion,
+				"result":       result,
+			}, 1)
+		}
+	case progress.EventMeasurement:
+		r.recordMeasurement(event)
+	}
+}
+
+func (r *Reporter) recordMeasurement(event progress.Event) {
+	if event.Value == nil {
+		return
+	}
+	value := *event.Value
+	switch event.Measurement {
+	case progress.MeasurementUpgradeInfo:
+		r.fromVersion = event.Labels["from_version"]
+		r.toVersion = event.Labels["to_version"]
+		labels := r.databaseLabels("from_version", r.fromVersion)
+		labels["to_version"] = r.toVersion
+		r.registry.Set(UpgradeInfo, labels, 1)
+	case progress.MeasurementDataDirectoryBytes:
+		r.registry.Set(DataDirectoryBytes, r.databaseLabels("stage", event.Labels["stage"]), value)
+	case progress.MeasurementCopiedBytes:
+		r.registry.Set(CopiedBytes, r.databaseLabels(), value)
+	case progress.MeasurementPodWaitSeconds:
+		r.registry.Add(PodWaitSecondsTotal, r.databaseLabels("phase", event.Phase), value)
+	}
+}
+
+// databaseLabels returns the namespace and database labels, followed by extra name value pairs
+func (r *Reporter) databaseLabels(extra ...string) map[string]string {
+	labels := map[string]string{
+		"namespace": r.namespace,
+		"database":  r.database,
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels[extra[i]] = extra[i+1]
+	}
+	return labels
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+func TestReporter(t *testing.T) {
+	registry := NewRegistry()
+	ctx := progress.WithReporter(context.Background(), registry.NewReporter())
+
+	upgradeCtx, endUpgrade := progress.StartPhase(ctx, progress.PhaseUpgrade, map[string]string{"namespace": "db", "statefulset": "postgres"})
+	progress.Measure(upgradeCtx, progress.MeasurementUpgradeInfo, 1, map[string]string{"from_version": "15", "to_version": "17"})
+	pgUpgradeCtx, endPGUpgrade := progress.StartPhase(upgradeCtx, progress.PhasePGUpgrade, nil)
+	progress.Measure(pgUpgradeCtx, progress.MeasurementPodWaitSeconds, 2, map[string]string{"pod": "prepare"})
+	progress.Measure(pgUpgradeCtx, progress.MeasurementPodWaitSeconds, 3, map[string]string{"pod": "upgrade"})
+	progress.Measure(pgUpgradeCtx, progress.MeasurementDataDirectoryBytes, 100, map[string]string{"stage": "before"})
+	progress.Measure(pgUpgradeCtx, progress.MeasurementCopiedBytes, 90, nil)
+	endPGUpgrade(errors.New("pg_upgrade failed"))
+	endUpgrade(errors.New("pg_upgrade failed"))
+
+	out := &strings.Builder{}
+	require.NoError(t, registry.Write(out))
+	for _, line := range []string{
+		`kube_pg_upgrade_upgrades_total{from_version="15",namespace="db",result="failure",to_version="17"} 1`,
+		`kube_pg_upgrade_upgrade_info{database="postgres",from_version="15",namespace="db",to_version="17"} 1`,
+		`kube_pg_upgrade_pod_wait_seconds_total{database="postgres",namespace="db",phase="pg_upgrade"} 5`,
+		`kube_pg_upgrade_data_directory_bytes{database="postgres",namespace="db",stage="before"} 100`,
+		`kube_pg_upgrade_copied_bytes{database="postgres",namespace="db"} 90`,
+		`kube_pg_upgrade_phase_failed{database="postgres",namespace="db",phase="pg_upgrade"} 1`,
+		`kube_pg_upgrade_phase_failed{database="postgres",namespace="db",phase="upgrade"} 1`,
+	} {
+		assert.Contains(t, out.String(), line+"\n")
+	}
+	assert.Contains(t, out.String(), `kube_pg_upgrade_phase_duration_seconds{database="postgres",namespace="db",phase="pg_upgrade"} `)
+}
+
+func TestReporterPerDatabase(t *testing.T) {
+	registry := NewRegistry()
+	for _, pvc := range []string{"data-a", "data-b"} {
+		ctx := progress.WithReporter(context.Background(), registry.NewReporter())
+		_, endUpgrade := progress.StartPhase(ctx, progress.PhaseUpgrade, map[string]string{"namespace": "db", "pvc": pvc})
+		progress.Measure(ctx, progress.MeasurementUpgradeInfo, 1, map[string]string{"from_version": "16", "to_version": "17"})
+		endUpgrade(nil)
+	}
+
+	out := &strings.Builder{}
+	require.NoError(t, registry.Write(out))
+	assert.Contains(t, out.String(), `kube_pg_upgrade_upgrades_total{from_version="16",namespace="db",result="success",to_version="17"} 2`+"\n")
+	assert.Contains(t, out.String(), `kube_pg_upgrade_phase_failed{database="data-a",namespace="db",phase="upgrade"} 0`+"\n")
+	assert.Contains(t, out.String(), `kube_pg_upgrade_phase_failed{database="data-b",namespace="db",phase="upgrade"} 0`+"\n")
+}
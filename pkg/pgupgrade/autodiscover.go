@@ -5,8 +5,33 @@ import (
 	"strings"
 
 	"golang.org/x/mod/semver"
+	v1 "k8s.io/api/core/v1"
 )
 
+// postgresImages are matched against the images of the containers of a workload to find the postgres container
+var postgresImages = []string{"/bitnami/postgresql:", "docker.io/bitnami/postgresql:", "/postgres:", "/postgresql:"}
+
+// findPostgresContainer returns the last container running a postgres image, or nil if there is none
+func findPostgresContainer(containers []v1.Container) *v1.Container {
+	var postgresContainer *v1.Container
+	for _, container := range containers {
+		if isImage(container.Image, postgresImages...) {
+			c := container
+			postgresContainer = &c
+		}
+	}
+	return postgresContainer
+}
+
+func isImage(containerImage string, images ...string) bool {
+	for _, image := range images {
+		if strings.Contains(containerImage, image) {
+			return true
+		}
+	}
+	return false
+}
+
 func AutoDiscoverPostgresVersionFromImage(image string) (string, error) {
 	splitted := strings.SplitAfter(image, ":")
 
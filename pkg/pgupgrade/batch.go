@@ -0,0 +1,193 @@
+package pgupgrade
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+// BatchTarget is a statefulset, or a pvc when StatefulSet is empty, upgraded as part of a batch, with the settings of
+// its upgrade. The pvc is upgraded in the same way as `upgrade pvc`, it requires the SourcePVCName, TargetPVCName and
+// CurrentPostgresVersion settings.
+type BatchTarget struct {
+	Namespace   string
+	StatefulSet string
+	Settings    PGUpgradeSettings
+}
+
+// name returns the statefulset, or pvc/<name> for a pvc target
+func (t BatchTarget) name() string {
+	if t.StatefulSet == "" {
+		return "pvc/" + t.Settings.SourcePVCName
+	}
+	return t.StatefulSet
+}
+
+// BatchOptions configures how the targets of a batch are upgraded
+type BatchOptions struct {
+	// Concurrency is the number of databases upgraded at the same time, defaults to 1
+	Concurrency int
+	// PerDatabaseTimeout limits the duration of the upgrade of a single database, zero means no limit
+	PerDatabaseTimeout time.Duration
+	// WithTarget is called with the context of the upgrade of every target, optional. For example to record the
+	// metrics of the upgrade with a reporter per database.
+	WithTarget func(ctx context.Context, target BatchTarget) context.Context
+}
+
+// BatchResult is the outcome of the upgrade of a single target
+type BatchResult struct {
+	Target      BatchTarget
+	FromVersion string
+	Duration    time.Duration
+	Err         error
+}
+
+// FindStatefulSets returns the statefulsets matching selector in namespace, or in all namespaces when namespace is
+// empty, sorted by namespace and name
+func FindStatefulSets(ctx context.Context, k8sClient kubernetes.Interface, namespace, selector string) ([]BatchTarget, error) {
+	list, err := k8sClient.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets matching %q: %w", selector, err)
+	}
+	targets := make([]BatchTarget, 0, len(list.Items))
+	for _, sts := range list.Items {
+		targets = append(targets, BatchTarget{Namespace: sts.Namespace, StatefulSet: sts.Name})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Namespace != targets[j].Namespace {
+			return targets[i].Namespace < targets[j].Namespace
+		}
+		return targets[i].StatefulSet < targets[j].StatefulSet
+	})
+	return targets, nil
+}
+
+// RunBatch upgrades every target with at most options.Concurrency upgrades running at the same time. A failed upgrade
+// does not stop the batch, the results are returned in the order of the targets.
+func RunBatch(ctx context.Context, k8sClient kubernetes.Interface, targets []BatchTarget, options BatchOptions) []BatchResult {
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]BatchResult, len(targets))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			results[i] = BatchResult{Target: target, Err: context.Cause(ctx)}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			results[i] = runBatchTarget(ctx, k8sClient, target, options)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func runBatchTarget(ctx context.Context, k8sClient kubernetes.Interface, target BatchTarget, options BatchOptions) (result BatchResult) {
+	start := time.Now()
+	result = BatchResult{Target: target, FromVersion: target.Settings.CurrentPostgresVersion}
+	defer func() { result.Duration = time.Since(start) }()
+
+	if options.PerDatabaseTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, options.PerDatabaseTimeout, fmt.Errorf("upgrade of %s/%s did not complete within %s", target.Namespace, target.name(), options.PerDatabaseTimeout))
+		defer cancel()
+	}
+	ctx = progress.WithReporter(ctx, &targetReporter{prefix: target.Namespace + "/" + target.name(), next: progress.FromContext(ctx)})
+	if options.WithTarget != nil {
+		ctx = options.WithTarget(ctx, target)
+	}
+
+	// every upgrade gets its own copy of the settings, the runner updates them with the discovered values
+	runner, err := NewPGUpgradeRunnerWithClient(target.Namespace, k8sClient, target.Settings.clone())
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if target.StatefulSet == "" {
+		result.Err = runner.RunPGUpgradeForDatabasePVC(ctx)
+	} else {
+		result.Err = runner.RunPGUpgradeForDatabaseStatefulSet(ctx, target.StatefulSet)
+	}
+	result.FromVersion = runner.settings.CurrentPostgresVersion
+	return result
+}
+
+// clone returns a copy of the settings that shares no slices with s
+func (s PGUpgradeSettings) clone() PGUpgradeSettings {
+	s.ImagePullSecrets = slices.Clone(s.ImagePullSecrets)
+	s.PreHooks = slices.Clone(s.PreHooks)
+	s.PostHooks = slices.Clone(s.PostHooks)
+	return s
+}
+
+// targetReporter prefixes the messages and log lines of the upgrade of a target, the upgrades of a batch run
+// concurrently and their output is interleaved
+type targetReporter struct {
+	prefix string
+	next   progress.Reporter
+}
+
+func (r *targetReporter) Report(event progress.Event) {
+	switch event.Type {
+	case progress.EventMessage:
+		event.Message = fmt.Sprintf("[%s] %s", r.prefix, event.Message)
+	case progress.EventLog:
+		event.Source = fmt.Sprintf("%s %s", r.prefix, event.Source)
+	}
+	r.next.Report(event)
+}
+
+// PrintBatchResults reports the results of a batch as a table
+func PrintBatchResults(ctx context.Context, results []BatchResult) {
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		status, message := "succeeded", ""
+		if result.Err != nil {
+			// the error of a failed pg_upgrade includes the key lines of its output, only the first line fits the table
+			message, _, _ = strings.Cut(result.Err.Error(), "\n")
+			status = "failed"
+		}
+		rows = append(rows, []string{
+			result.Target.Namespace,
+			result.Target.name(),
+			result.FromVersion,
+			result.Target.Settings.TargetPostgresVersion,
+			status,
+			result.Duration.Round(time.Second).String(),
+			message,
+		})
+	}
+	progress.Table(ctx, []string{"namespace", "target", "from", "to", "result", "duration", "error"}, rows)
+}
+
+// BatchError returns an error when any upgrade of the batch failed
+func BatchError(results []BatchResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d upgrades failed", failed, len(results))
+	}
+	return nil
+}
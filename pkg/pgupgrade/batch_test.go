@@ -0,0 +1,104 @@
+package pgupgrade
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+func newTestStatefulSet(namespace, name string, labels map[string]string) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+}
+
+func TestFindStatefulSets(t *testing.T) {
+	postgres := map[string]string{"app.kubernetes.io/name": "postgresql"}
+	k8sClient := fake.NewSimpleClientset(
+		newTestStatefulSet("team-b", "db", postgres),
+		newTestStatefulSet("team-a", "db", postgres),
+		newTestStatefulSet("team-a", "cache", map[string]string{"app.kubernetes.io/name": "redis"}),
+	)
+
+	targets, err := FindStatefulSets(context.Background(), k8sClient, "", "app.kubernetes.io/name=postgresql")
+	require.NoError(t, err)
+	assert.Equal(t, []BatchTarget{{Namespace: "team-a", StatefulSet: "db"}, {Namespace: "team-b", StatefulSet: "db"}}, targets)
+
+	targets, err = FindStatefulSets(context.Background(), k8sClient, "team-b", "app.kubernetes.io/name=postgresql")
+	require.NoError(t, err)
+	assert.Equal(t, []BatchTarget{{Namespace: "team-b", StatefulSet: "db"}}, targets)
+}
+
+func TestRunBatchContinuesPastFailures(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	settings := PGUpgradeSettings{TargetPostgresVersion: "16", CurrentPostgresVersion: "15", ImagePullSecrets: []string{"registry"}}
+	targets := []BatchTarget{
+		{Namespace: "team-a", StatefulSet: "missing-1", Settings: settings},
+		{Namespace: "team-b", StatefulSet: "missing-2", Settings: settings},
+		{Namespace: "team-c", StatefulSet: "invalid", Settings: PGUpgradeSettings{}},
+	}
+
+	var out bytes.Buffer
+	ctx := progress.WithReporter(context.Background(), progress.NewHumanReporter(&out))
+	var mu sync.Mutex
+	var withTarget []string
+	results := RunBatch(ctx, k8sClient, targets, BatchOptions{
+		Concurrency: 2,
+		WithTarget: func(ctx context.Context, target BatchTarget) context.Context {
+			mu.Lock()
+			defer mu.Unlock()
+			withTarget = append(withTarget, target.StatefulSet)
+			return ctx
+		},
+	})
+
+	require.Len(t, results, 3)
+	for i, result := range results {
+		assert.Equal(t, targets[i], result.Target, "results are in the order of the targets")
+		assert.Error(t, result.Err)
+	}
+	assert.Equal(t, "15", results[0].FromVersion)
+	assert.Contains(t, results[2].Err.Error(), "missing target postgres version")
+	assert.Equal(t, []string{"registry"}, settings.ImagePullSecrets, "the settings of the targets are not modified")
+	assert.Len(t, withTarget, 3)
+	assert.EqualError(t, BatchError(results), "3 of 3 upgrades failed")
+}
+
+func TestRunBatchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errors.New("interrupted"))
+
+	results := RunBatch(ctx, fake.NewSimpleClientset(), []BatchTarget{
+		{Namespace: "team-a", StatefulSet: "db", Settings: PGUpgradeSettings{TargetPostgresVersion: "16"}},
+	}, BatchOptions{})
+	require.Len(t, results, 1)
+	assert.Error(t, results[0].Err)
+}
+
+func TestTargetReporter(t *testing.T) {
+	var out bytes.Buffer
+	ctx := progress.WithReporter(context.Background(), &targetReporter{prefix: "team-a/db", next: progress.NewHumanReporter(&out)})
+	progress.Printf(ctx, "scaling down\n")
+	progress.Log(ctx, "pg-upgrade", "upgrade-postgres", "pg-upgrade", "Performing Upgrade")
+	assert.Equal(t, "[team-a/db] scaling down\n[team-a/db pg-upgrade]: Performing Upgrade\n", out.String())
+}
+
+func TestPrintBatchResults(t *testing.T) {
+	var out bytes.Buffer
+	ctx := progress.WithReporter(context.Background(), progress.NewJSONReporter(&out))
+	PrintBatchResults(ctx, []BatchResult{
+		{Target: BatchTarget{Namespace: "team-a", StatefulSet: "db", Settings: PGUpgradeSettings{TargetPostgresVersion: "16"}}, FromVersion: "15"},
+		{Target: BatchTarget{Namespace: "team-b", StatefulSet: "db", Settings: PGUpgradeSettings{TargetPostgresVersion: "16"}}, Err: errors.New("pg_upgrade failed\npg_upgrade reported:\n  fatal")},
+	})
+	assert.Contains(t, out.String(), `["team-a","db","15","16","succeeded","0s",""]`)
+	assert.Contains(t, out.String(), `["team-b","db","","16","failed","0s","pg_upgrade failed"]`)
+	assert.NoError(t, BatchError(nil))
+}
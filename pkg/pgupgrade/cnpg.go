@@ -0,0 +1,132 @@
+package pgupgrade
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/cnpg"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+// CNPGMigration are the settings of the migration of a statefulset to a CloudNativePG Cluster
+type CNPGMigration struct {
+	// ClusterName is the name of the Cluster, defaults to the name of the statefulset
+	ClusterName string
+	Instances   int
+	// ImageName is the PostgreSQL image of the Cluster, defaults to the CloudNativePG image of the target version
+	ImageName string
+	// VolumeSnapshotClass is used for the snapshot of the upgraded volume, defaults to the default class of the cluster
+	VolumeSnapshotClass string
+	// ManifestFile is a local file the manifests of the Cluster and its secrets are written to, optional
+	ManifestFile string
+	// Apply creates the Cluster and its secrets
+	Apply bool
+}
+
+// importPVCName returns the pvc the upgraded data is bound to
+func (m CNPGMigration) importPVCName() string {
+	return Truncate(m.ClusterName+"-import", 63)
+}
+
+// RunCNPGMigration upgrades the data of the statefulset into a new pvc, in the layout of CloudNativePG, and creates
+// the manifests of a Cluster bootstrapped from a VolumeSnapshot of it. The statefulset is left scaled down with its
+// original data, so it can be scaled up again to roll back.
+func (r *PGUpgradeRunner) RunCNPGMigration(ctx context.Context, statefulSetName string, migration CNPGMigration) error {
+	if r.settings.DynamicClient == nil {
+		return fmt.Errorf("a dynamic client is required to migrate to CloudNativePG")
+	}
+	if migration.ManifestFile == "" && !migration.Apply {
+		return fmt.Errorf("a manifest file or apply is required, the manifests contain the credentials of the database")
+	}
+	if migration.ClusterName == "" {
+		migration.ClusterName = statefulSetName
+	}
+	var clusterPermissions []Permission
+	if migration.Apply {
+		clusterPermissions = append(clusterPermissions, Permission{Namespace: r.namespace, Group: cnpg.ClusterResource.Group, Resource: cnpg.ClusterResource.Resource, Verb: "create", Reason: "create the cluster"})
+	}
+	if err := r.checkPermissions(ctx, ModeCNPG, clusterPermissions...); err != nil {
+		return err
+	}
+	r.settings.CheckPermissions = false
+
+	sts, err := r.k8sclient.AppsV1().StatefulSets(r.namespace).Get(ctx, statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get statefulset %q: %w", statefulSetName, err)
+	}
+	postgresContainer, err := r.findPostgresContainer(ctx, statefulSetName)
+	if err != nil {
+		return err
+	}
+	// CloudNativePG manages the instances with the postgres user
+	if user := r.getInitDBUser(postgresContainer); user != cnpg.SuperuserName {
+		return fmt.Errorf("the database is initialized with user %q, CloudNativePG requires the superuser %q", user, cnpg.SuperuserName)
+	}
+	credentials, err := cnpg.FindCredentials(ctx, r.k8sclient, r.namespace, sts.Spec.Template.Spec, *postgresContainer)
+	if err != nil {
+		return fmt.Errorf("failed to read the credentials of statefulset %q: %w", statefulSetName, err)
+	}
+
+	r.settings.TargetPVCName = migration.importPVCName()
+	r.settings.TargetSubPath = cnpg.PGDataSubPath
+	r.settings.KeepSourcePVC = true
+	r.settings.PostHooks = append(slices.Clone(r.settings.PostHooks), Hook{
+		Source: "cnpg-ownership",
+		Script: fmt.Sprintf("chown -R %d:%d /new\nchmod 0700 /new\n", cnpg.DefaultPostgresUID, cnpg.DefaultPostgresGID),
+	})
+	if err := r.RunPGUpgradeForDatabaseStatefulSet(ctx, statefulSetName); err != nil {
+		return err
+	}
+
+	pvc, err := r.k8sclient.CoreV1().PersistentVolumeClaims(r.namespace).Get(ctx, r.settings.TargetPVCName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pvc %q: %w", r.settings.TargetPVCName, err)
+	}
+	snapshotName := r.settings.TargetPVCName
+	progress.Printf(ctx, "creating volume snapshot %q of pvc %q...\n", snapshotName, pvc.Name)
+	if err := cnpg.CreateVolumeSnapshot(ctx, r.settings.DynamicClient, r.namespace, snapshotName, pvc.Name, migration.VolumeSnapshotClass); err != nil {
+		return err
+	}
+	if err := cnpg.WaitForVolumeSnapshot(ctx, r.settings.DynamicClient, r.namespace, snapshotName); err != nil {
+		return err
+	}
+
+	objects, err := cnpg.Manifests(cnpg.ClusterOptions{
+		Name:            migration.ClusterName,
+		Namespace:       r.namespace,
+		Instances:       migration.Instances,
+		ImageName:       migration.ImageName,
+		PostgresVersion: r.settings.TargetPostgresVersion,
+		StorageClass:    getStorageClassForPVC(pvc),
+		Size:            pvc.Spec.Resources.Requests.Storage().String(),
+		VolumeSnapshot:  snapshotName,
+		Credentials:     credentials,
+	})
+	if err != nil {
+		return err
+	}
+	if migration.ManifestFile != "" {
+		manifest, err := cnpg.ToYAML(objects)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(migration.ManifestFile, manifest, 0o600); err != nil {
+			return fmt.Errorf("failed to write the manifests: %w", err)
+		}
+		progress.Printf(ctx, "wrote the manifests of cluster %q to %s\n", migration.ClusterName, migration.ManifestFile)
+	}
+	if migration.Apply {
+		if err := cnpg.Apply(ctx, r.settings.DynamicClient, objects); err != nil {
+			return err
+		}
+		progress.Printf(ctx, "created cluster %q bootstrapped from volume snapshot %q\n", migration.ClusterName, snapshotName)
+	}
+
+	progress.Printf(ctx, "statefulset %q is left scaled down with the original data, scale it up again to roll back\n", statefulSetName)
+	progress.Printf(ctx, "once the cluster runs, delete the statefulset, its pvc and the pvc %q\n", pvc.Name)
+	return nil
+}
@@ -0,0 +1,38 @@
+package pgupgrade
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunCNPGMigrationValidation(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "databases", Name: "orders-postgresql"},
+		Spec: appsv1.StatefulSetSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name: "postgres",
+			Env:  []v1.EnvVar{{Name: "POSTGRES_USER", Value: "orders"}},
+		}}}}},
+	})
+	runner, err := NewPGUpgradeRunnerWithClient("databases", k8sClient, PGUpgradeSettings{
+		CurrentPostgresVersion: "11",
+		TargetPostgresVersion:  "16",
+		PostgresContainerName:  "postgres",
+		DynamicClient:          dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+	})
+	require.NoError(t, err)
+
+	err = runner.RunCNPGMigration(context.Background(), "orders-postgresql", CNPGMigration{})
+	assert.ErrorContains(t, err, "manifest file or apply is required")
+
+	err = runner.RunCNPGMigration(context.Background(), "orders-postgresql", CNPGMigration{Apply: true})
+	assert.ErrorContains(t, err, `initialized with user "orders"`)
+}
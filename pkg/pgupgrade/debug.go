@@ -0,0 +1,103 @@
+package pgupgrade
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubevolumes"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+// debugCommand keeps the debug container running until the pod is deleted
+const debugCommand = "trap 'exit 0' TERM INT; echo 'debug pod is ready'; while true; do sleep 3600 & wait $!; done"
+
+// RunDebugPod starts a pod using the upgrade image, with the source pvc and, when it still exists, the temporary pvc
+// of a failed upgrade mounted on the same paths as during pg_upgrade. The pod sleeps until it is deleted.
+// Returns the name of the pod.
+func (r *PGUpgradeRunner) RunDebugPod(ctx context.Context) (string, error) {
+	sourcePVCName := r.settings.SourcePVCName
+	if sourcePVCName == "" {
+		return "", fmt.Errorf("source pvc name must not be empty")
+	}
+	subpath := "data"
+	if r.settings.SubPath != "" {
+		subpath = r.settings.SubPath
+	}
+
+	if _, err := r.k8sclient.CoreV1().PersistentVolumeClaims(r.namespace).Get(ctx, sourcePVCName, metav1.GetOptions{}); err != nil {
+		return "", fmt.Errorf("failed to get persistent volume claim %q: %w", sourcePVCName, err)
+	}
+	volumes := []v1.Volume{
+		kubevolumes.NewPersistentVolumeClaimVolume("old", sourcePVCName, false),
+	}
+
+	tmpPVCName := getTemporaryPVCName(sourcePVCName)
+	_, err := r.k8sclient.CoreV1().PersistentVolumeClaims(r.namespace).Get(ctx, tmpPVCName, metav1.GetOptions{})
+	switch {
+	case kubeerrors.IsNotFound(err):
+		progress.Printf(ctx, "temporary pvc %q does not exist, only mounting %q\n", tmpPVCName, sourcePVCName)
+	case err != nil:
+		return "", fmt.Errorf("failed to get persistent volume claim %q: %w", tmpPVCName, err)
+	default:
+		volumes = append(volumes, kubevolumes.NewPersistentVolumeClaimVolume("new", tmpPVCName, false))
+	}
+
+	jobAction, err := createUpgradeJobActionInput(r.settings, subpath, subpath, r.settings.GetInitDBUser(), r.settings.InitDBArgs)
+	if err != nil {
+		return "", err
+	}
+	container := newDebugContainer(jobAction.JobContainer, len(volumes) > 1)
+
+	podName := Truncate("pg-upgrade-debug-"+sourcePVCName, 63)
+	runner := podrunner.NewPodRunnerWithOptions(r.k8sclient, podrunner.Options{StuckTimeout: r.settings.StuckTimeout, Logger: r.logger})
+	err = runner.StartPod(ctx, r.namespace, podName, newTaskPod(podName, r.namespace, jobAction.ImagePullSecrets, []v1.Container{container}, volumes))
+	if err != nil {
+		return "", err
+	}
+
+	mounted := fmt.Sprintf("the old data directory is mounted on %q", getVolumeMountPath(container, "old"))
+	if len(volumes) > 1 {
+		mounted += fmt.Sprintf(" and the new data directory on %q", getVolumeMountPath(container, "new"))
+	}
+	progress.Printf(ctx, "debug pod %q is running, %s\n", podName, mounted)
+	progress.Printf(ctx, "open a shell using:\n")
+	progress.Printf(ctx, "  kubectl -n %s exec -it %s -- /bin/sh\n", r.namespace, podName)
+	progress.Printf(ctx, "remove it once done using:\n")
+	progress.Printf(ctx, "  kubectl -n %s delete pod %s\n", r.namespace, podName)
+	return podName, nil
+}
+
+// newDebugContainer returns the upgrade container with the same environment and mounts, sleeping instead of running pg_upgrade
+func newDebugContainer(upgradeContainer v1.Container, mountNew bool) v1.Container {
+	container := *upgradeContainer.DeepCopy()
+	container.Name = "debug"
+	container.Command = []string{"/bin/sh", "-c"}
+	container.Args = []string{debugCommand}
+	container.Stdin = true
+	container.TTY = true
+
+	mounts := []v1.VolumeMount{}
+	for _, mount := range container.VolumeMounts {
+		// the scripts secret only exists while an upgrade is running
+		if mount.Name == "scripts" || (mount.Name == "new" && !mountNew) {
+			continue
+		}
+		mounts = append(mounts, mount)
+	}
+	container.VolumeMounts = mounts
+	return container
+}
+
+func getVolumeMountPath(container v1.Container, volumeName string) string {
+	for _, mount := range container.VolumeMounts {
+		if mount.Name == volumeName {
+			return mount.MountPath
+		}
+	}
+	return ""
+}
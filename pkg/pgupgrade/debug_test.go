@@ -0,0 +1,32 @@
+package pgupgrade
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestNewDebugContainer(t *testing.T) {
+	upgradeContainer := v1.Container{
+		Name:  "upgrade-postgres",
+		Image: "tianon/postgres-upgrade:11-to-15",
+		Env:   []v1.EnvVar{newPodEnvVar("PGDATAOLD", "/var/lib/postgresql/11/data")},
+		VolumeMounts: []v1.VolumeMount{
+			{Name: "old", MountPath: "/var/lib/postgresql/11/data", SubPath: "data"},
+			{Name: "new", MountPath: "/var/lib/postgresql/15/data", SubPath: "data"},
+		},
+	}
+
+	container := newDebugContainer(upgradeContainer, true)
+	assert.Equal(t, "debug", container.Name)
+	assert.Equal(t, upgradeContainer.Image, container.Image)
+	assert.Equal(t, upgradeContainer.Env, container.Env)
+	assert.Equal(t, []string{"/bin/sh", "-c"}, container.Command)
+	assert.Len(t, container.VolumeMounts, 2)
+
+	container = newDebugContainer(upgradeContainer, false)
+	assert.Equal(t, []v1.VolumeMount{upgradeContainer.VolumeMounts[0]}, container.VolumeMounts)
+	assert.Equal(t, "/var/lib/postgresql/11/data", getVolumeMountPath(container, "old"))
+	assert.Len(t, upgradeContainer.VolumeMounts, 2, "the upgrade container must not be modified")
+}
@@ -0,0 +1,306 @@
+package pgupgrade
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubesecrethelper"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/kubevolumes"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/ptrs"
+)
+
+//go:embed scripts/probe-extensions.sh
+var probeExtensionsScript string
+
+//go:embed scripts/check-extensions.sh
+var checkExtensionsScript string
+
+const (
+	ProbeExtensionsScriptFileName = "probe-extensions.sh"
+	CheckExtensionsScriptFileName = "check-extensions.sh"
+)
+
+const (
+	// ExtensionStatusOK means the installed extension version is available in the image
+	ExtensionStatusOK = "ok"
+	// ExtensionStatusUpdateRequired means the image ships a newer version with an update path,
+	// ALTER EXTENSION ... UPDATE should be run after the upgrade
+	ExtensionStatusUpdateRequired = "update required"
+	// ExtensionStatusVersionMismatch means the installed version is not available in the image and there is no update path
+	ExtensionStatusVersionMismatch = "version mismatch"
+	// ExtensionStatusMissing means the extension or library is not available in the image, pg_upgrade will fail
+	ExtensionStatusMissing = "missing"
+)
+
+// InstalledExtension is an extension installed in a database of the old cluster
+type InstalledExtension struct {
+	Database string
+	Name     string
+	Version  string
+}
+
+// ExtensionProbeResult contains the extensions and preloaded libraries of the old cluster
+type ExtensionProbeResult struct {
+	Extensions             []InstalledExtension
+	SharedPreloadLibraries []string
+}
+
+type availableExtension struct {
+	DefaultVersion string
+	Versions       []string
+}
+
+// ImageExtensions contains the extensions and libraries found in an image
+type ImageExtensions struct {
+	Image      string
+	Extensions map[string]*availableExtension
+	Libraries  map[string]bool
+}
+
+// ExtensionCheckResult is a single row of the extension compatibility report
+type ExtensionCheckResult struct {
+	Database         string
+	Extension        string
+	InstalledVersion string
+	Image            string
+	AvailableVersion string
+	Status           string
+}
+
+func parseExtensionProbeOutput(lines []string) ExtensionProbeResult {
+	result := ExtensionProbeResult{}
+	for _, line := range lines {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		switch {
+		case fields[0] == "PRELOAD" && len(fields) == 2:
+			if !slices.Contains(result.SharedPreloadLibraries, fields[1]) {
+				result.SharedPreloadLibraries = append(result.SharedPreloadLibraries, fields[1])
+			}
+		case fields[0] == "EXTENSION" && len(fields) == 4:
+			result.Extensions = append(result.Extensions, InstalledExtension{
+				Database: fields[1],
+				Name:     fields[2],
+				Version:  fields[3],
+			})
+		}
+	}
+	return result
+}
+
+func parseExtensionCheckOutput(image string, lines []string) ImageExtensions {
+	result := ImageExtensions{
+		Image:      image,
+		Extensions: map[string]*availableExtension{},
+		Libraries:  map[string]bool{},
+	}
+	for _, line := range lines {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		switch {
+		case fields[0] == "MISSING" && len(fields) == 2:
+			result.Extensions[fields[1]] = nil
+		case fields[0] == "CONTROL" && len(fields) == 4:
+			available := &availableExtension{DefaultVersion: fields[2]}
+			for _, version := range strings.Split(fields[3], ",") {
+				if version != "" {
+					available.Versions = append(available.Versions, version)
+				}
+			}
+			result.Extensions[fields[1]] = available
+		case fields[0] == "LIBRARY" && len(fields) == 3:
+			result.Libraries[fields[1]] = fields[2] == "found"
+		}
+	}
+	return result
+}
+
+// evaluateExtensions compares the extensions of the old cluster against what is available in each image
+func evaluateExtensions(probe ExtensionProbeResult, images []ImageExtensions) []ExtensionCheckResult {
+	results := []ExtensionCheckResult{}
+	for _, image := range images {
+		for _, library := range probe.SharedPreloadLibraries {
+			status := ExtensionStatusOK
+			if !image.Libraries[library] {
+				status = ExtensionStatusMissing
+			}
+			results = append(results, ExtensionCheckResult{
+				Database:  "*",
+				Extension: library + " (shared_preload_libraries)",
+				Image:     image.Image,
+				Status:    status,
+			})
+		}
+		for _, installed := range probe.Extensions {
+			result := ExtensionCheckResult{
+				Database:         installed.Database,
+				Extension:        installed.Name,
+				InstalledVersion: installed.Version,
+				Image:            image.Image,
+				Status:           ExtensionStatusMissing,
+			}
+			if available := image.Extensions[installed.Name]; available != nil {
+				result.AvailableVersion = available.DefaultVersion
+				result.Status = extensionVersionStatus(installed.Version, available)
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func extensionVersionStatus(installedVersion string, available *availableExtension) string {
+	if installedVersion == available.DefaultVersion {
+		return ExtensionStatusOK
+	}
+	for _, version := range available.Versions {
+		// install scripts are named <version>, update scripts <from>--<to>
+		from, _, _ := strings.Cut(version, "--")
+		if from == installedVersion {
+			return ExtensionStatusUpdateRequired
+		}
+	}
+	return ExtensionStatusVersionMismatch
+}
+
+func printExtensionCheckResults(ctx context.Context, results []ExtensionCheckResult) {
+	body := make([][]string, 0, len(results))
+	for _, result := range results {
+		body = append(body, []string{result.Database, result.Extension, result.InstalledVersion, result.Image, result.AvailableVersion, result.Status})
+	}
+	progress.Table(ctx, []string{"database", "extension", "installed", "image", "available", "status"}, body)
+}
+
+// checkExtensionCompatibility starts the old cluster in a probe pod and validates that all installed extensions and
+// preloaded libraries are available in the upgrade image and, if configured, in the target runtime image.
+func (r *PGUpgradeRunner) checkExtensionCompatibility(ctx context.Context, sourcePVCName, subPath, pgUser string, sharedPreloadLibraries []string) (err error) {
+	ctx, endPhase := progress.StartPhase(ctx, progress.PhaseExtensionCheck, map[string]string{"pvc": sourcePVCName})
+	defer func() { endPhase(err) }()
+
+	upgradeImage, err := r.settings.GetUpgradeImage()
+	if err != nil {
+		return err
+	}
+	binEnv, err := getPGBinEnv(r.settings)
+	if err != nil {
+		return err
+	}
+
+	scriptSecretName := Truncate("pg-extension-check-"+sourcePVCName, 63)
+	err = kubesecrethelper.CreateOrUpdateSecret(ctx, r.k8sclient, kubesecrethelper.CreateSecret(kubesecrethelper.CreateSecretOptions{
+		Name:      scriptSecretName,
+		Namespace: r.namespace,
+		Data: map[string][]byte{
+			ProbeExtensionsScriptFileName: []byte(probeExtensionsScript),
+			CheckExtensionsScriptFileName: []byte(checkExtensionsScript),
+		},
+	}))
+	if err != nil {
+		return err
+	}
+	defer r.k8sclient.CoreV1().Secrets(r.namespace).Delete(context.Background(), scriptSecretName, metav1.DeleteOptions{})
+
+	runner := r.newTaskRunner()
+	scriptsMount := v1.VolumeMount{Name: "scripts", MountPath: "/scripts/", ReadOnly: true}
+
+	progress.Printf(ctx, "[extensions] probing installed extensions of %q...\n", sourcePVCName)
+	probePodName := Truncate("pg-ext-probe-"+sourcePVCName, 63)
+	var probeOutput []string
+	err = runner.RunPod(ctx, r.namespace, probePodName, newTaskPod(probePodName, r.namespace, r.settings.GetImagePullSecrets(), []v1.Container{
+		{
+			Name:            "probe",
+			Image:           upgradeImage,
+			SecurityContext: &v1.SecurityContext{RunAsUser: ptrs.Int64(0), RunAsNonRoot: ptrs.False()},
+			Command:         []string{"/bin/bash"},
+			Args:            []string{fmt.Sprintf("/scripts/%s", ProbeExtensionsScriptFileName)},
+			Env:             append([]v1.EnvVar{newPodEnvVar("PGUSER", pgUser)}, binEnv...),
+			VolumeMounts: []v1.VolumeMount{
+				{Name: "old", MountPath: "/old", SubPath: subPath},
+				scriptsMount,
+			},
+		},
+	}, []v1.Volume{
+		kubevolumes.NewPersistentVolumeClaimVolume("old", sourcePVCName, false),
+		kubevolumes.NewVolumeFromSecret("scripts", scriptSecretName),
+	}), podrunner.WithLogHandler(func(line string) {
+		probeOutput = append(probeOutput, line)
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to probe installed extensions: %w", err)
+	}
+
+	probe := parseExtensionProbeOutput(probeOutput)
+	for _, library := range sharedPreloadLibraries {
+		if library != "" && !slices.Contains(probe.SharedPreloadLibraries, library) {
+			probe.SharedPreloadLibraries = append(probe.SharedPreloadLibraries, library)
+		}
+	}
+
+	extensionNames := []string{}
+	for _, extension := range probe.Extensions {
+		if !slices.Contains(extensionNames, extension.Name) {
+			extensionNames = append(extensionNames, extension.Name)
+		}
+	}
+	sort.Strings(extensionNames)
+
+	images := []string{upgradeImage}
+	if r.settings.TargetImage != "" {
+		images = append(images, r.settings.ImageMirrors.Apply(r.settings.TargetImage))
+	}
+
+	imageExtensions := make([]ImageExtensions, 0, len(images))
+	for i, image := range images {
+		env := []v1.EnvVar{
+			newPodEnvVar("CHECK_EXTENSIONS", strings.Join(extensionNames, " ")),
+			newPodEnvVar("CHECK_LIBRARIES", strings.Join(probe.SharedPreloadLibraries, " ")),
+		}
+		if image == upgradeImage {
+			env = append(env, binEnv...)
+		}
+
+		progress.Printf(ctx, "[extensions] checking available extensions in image %q...\n", image)
+		checkPodName := Truncate(fmt.Sprintf("pg-ext-check-%d-%s", i, sourcePVCName), 63)
+		var checkOutput []string
+		err = runner.RunPod(ctx, r.namespace, checkPodName, newTaskPod(checkPodName, r.namespace, r.settings.GetImagePullSecrets(), []v1.Container{
+			{
+				Name:            "check",
+				Image:           image,
+				SecurityContext: &v1.SecurityContext{RunAsNonRoot: ptrs.False()},
+				Command:         []string{"/bin/sh"},
+				Args:            []string{fmt.Sprintf("/scripts/%s", CheckExtensionsScriptFileName)},
+				Env:             env,
+				VolumeMounts:    []v1.VolumeMount{scriptsMount},
+			},
+		}, []v1.Volume{
+			kubevolumes.NewVolumeFromSecret("scripts", scriptSecretName),
+		}), podrunner.WithLogHandler(func(line string) {
+			checkOutput = append(checkOutput, line)
+		}))
+		if err != nil {
+			return fmt.Errorf("failed to check extensions in image %q: %w", image, err)
+		}
+		imageExtensions = append(imageExtensions, parseExtensionCheckOutput(image, checkOutput))
+	}
+
+	results := evaluateExtensions(probe, imageExtensions)
+	printExtensionCheckResults(ctx, results)
+
+	var missing []string
+	for _, result := range results {
+		if result.Status == ExtensionStatusMissing {
+			missing = append(missing, fmt.Sprintf("%s in %s", result.Extension, result.Image))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("extensions missing from target images: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package pgupgrade
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateExtensions(t *testing.T) {
+	probe := parseExtensionProbeOutput([]string{
+		"waiting for server to start.... done",
+		"PRELOAD|pg_stat_statements",
+		"EXTENSION|app|plpgsql|1.0",
+		"EXTENSION|app|postgis|3.1.4",
+		"EXTENSION|app|pg_trgm|1.4",
+		"EXTENSION|app|hstore|1.5",
+	})
+	assert.Equal(t, []string{"pg_stat_statements"}, probe.SharedPreloadLibraries)
+	assert.Len(t, probe.Extensions, 4)
+
+	image := parseExtensionCheckOutput("postgres-upgrade:11-to-15", []string{
+		"CONTROL|plpgsql|1.0|1.0,",
+		"MISSING|postgis",
+		"CONTROL|pg_trgm|1.6|1.3,1.4--1.5,1.5--1.6,",
+		"CONTROL|hstore|1.8|1.4,1.7--1.8,",
+		"LIBRARY|pg_stat_statements|found",
+	})
+
+	results := evaluateExtensions(probe, []ImageExtensions{image})
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result.Extension] = result.Status
+	}
+	assert.Equal(t, map[string]string{
+		"pg_stat_statements (shared_preload_libraries)": ExtensionStatusOK,
+		"plpgsql": ExtensionStatusOK,
+		"postgis": ExtensionStatusMissing,
+		"pg_trgm": ExtensionStatusUpdateRequired,
+		"hstore":  ExtensionStatusVersionMismatch,
+	}, statuses)
+}
+
+func TestSplitList(t *testing.T) {
+	assert.Equal(t, []string{"pgaudit", "pg_stat_statements"}, splitList("'pgaudit, pg_stat_statements'"))
+	assert.Empty(t, splitList(""))
+}
+
+func TestProbeExtensionsRestoresOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the ownership of the data directory requires root")
+	}
+	realChown, err := exec.LookPath("chown")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "old")
+	require.NoError(t, os.MkdirAll(filepath.Join(data, "base"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(data, "PG_VERSION"), []byte("11\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(data, "base", "1"), nil, 0o600))
+	require.NoError(t, exec.Command(realChown, "-R", "1001:1001", data).Run())
+	require.NoError(t, os.Chown(filepath.Join(data, "base", "1"), 1001, 0))
+
+	// the image has a postgres user, nobody stands in for it, and the old cluster fails to start
+	bin := filepath.Join(dir, "bin")
+	require.NoError(t, os.Mkdir(bin, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "chown"), []byte("#!/bin/bash\nexec "+realChown+" \"${@/postgres/nobody}\"\n"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "su"), []byte("#!/bin/bash\nexit 1\n"), 0o755))
+
+	cmd := exec.Command("bash", "-c", strings.ReplaceAll(probeExtensionsScript, "/old", data))
+	cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"), "PGBINOLD=/usr/lib/postgresql/11/bin", "PGUSER=postgres")
+	output, err := cmd.CombinedOutput()
+	require.Error(t, err, "the probe fails: %s", output)
+
+	owner := func(path string) [2]uint32 {
+		info, err := os.Lstat(path)
+		require.NoError(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		return [2]uint32{stat.Uid, stat.Gid}
+	}
+	assert.Equal(t, [2]uint32{1001, 1001}, owner(data))
+	assert.Equal(t, [2]uint32{1001, 1001}, owner(filepath.Join(data, "PG_VERSION")))
+	assert.Equal(t, [2]uint32{1001, 0}, owner(filepath.Join(data, "base", "1")))
+	assert.NoFileExists(t, filepath.Join(data, "postgresql.conf"))
+}
@@ -0,0 +1,48 @@
+package pgupgrade
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/gitops"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+// suspendGitOps suspends the sync of the Argo CD Applications and Flux objects managing the statefulset, so they do
+// not scale it back up during the upgrade. It returns a function resuming them. The upgrade fails when the owners
+// cannot be looked up, a forbidden owner may still scale the statefulset back up.
+func (r *PGUpgradeRunner) suspendGitOps(ctx context.Context, statefulSetName string) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if !r.settings.SuspendGitOps || r.settings.DynamicClient == nil {
+		return noop, nil
+	}
+	sts, err := r.k8sclient.AppsV1().StatefulSets(r.namespace).Get(ctx, statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	owners, err := gitops.FindOwners(ctx, r.settings.DynamicClient, r.k8sclient.Discovery(), sts.ObjectMeta, r.settings.ArgoCDNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the gitops owners of statefulset %s: %w", statefulSetName, err)
+	}
+	if len(owners) == 0 {
+		return noop, nil
+	}
+	if err := r.reviewPermissions(ctx, GitOpsPermissions(owners)); err != nil {
+		return nil, err
+	}
+	for _, owner := range owners {
+		progress.Printf(ctx, "suspending the sync of %s\n", owner)
+	}
+	resume, err := gitops.Suspend(ctx, r.settings.DynamicClient, owners)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		for _, owner := range owners {
+			progress.Printf(ctx, "resuming the sync of %s\n", owner)
+		}
+		return resume(ctx)
+	}, nil
+}
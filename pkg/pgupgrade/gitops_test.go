@@ -0,0 +1,82 @@
+package pgupgrade
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/gitops"
+)
+
+func TestSuspendGitOps(t *testing.T) {
+	helmReleases := schema.GroupVersionResource{Group: "helm.toolkit.fluxcd.io", Version: "v2", Resource: "helmreleases"}
+	helmRelease := &unstructured.Unstructured{Object: map[string]any{"spec": map[string]any{"interval": "5m"}}}
+	helmRelease.SetAPIVersion(helmReleases.GroupVersion().String())
+	helmRelease.SetKind(gitops.KindFluxHelmRelease)
+	helmRelease.SetNamespace("databases")
+	helmRelease.SetName("orders")
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		helmReleases: "HelmReleaseList",
+	}, helmRelease)
+
+	k8sClient := fake.NewSimpleClientset(&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "databases",
+		Name:      "orders-postgresql",
+		Labels:    map[string]string{"helm.toolkit.fluxcd.io/name": "orders"},
+	}})
+	k8sClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: helmReleases.GroupVersion().String(),
+		APIResources: []metav1.APIResource{{Name: helmReleases.Resource, Namespaced: true}},
+	}}
+	suspended := func() bool {
+		object, err := dynamicClient.Resource(helmReleases).Namespace("databases").Get(context.Background(), "orders", metav1.GetOptions{})
+		require.NoError(t, err)
+		value, _, _ := unstructured.NestedBool(object.Object, "spec", "suspend")
+		return value
+	}
+
+	runner, err := NewPGUpgradeRunnerWithClient("databases", k8sClient, PGUpgradeSettings{CurrentPostgresVersion: "11", TargetPostgresVersion: "15", DynamicClient: dynamicClient})
+	require.NoError(t, err)
+	resume, err := runner.suspendGitOps(context.Background(), "orders-postgresql")
+	require.NoError(t, err)
+	assert.False(t, suspended(), "the sync is only suspended when enabled")
+	require.NoError(t, resume(context.Background()))
+
+	runner, err = NewPGUpgradeRunnerWithClient("databases", k8sClient, PGUpgradeSettings{CurrentPostgresVersion: "11", TargetPostgresVersion: "15", DynamicClient: dynamicClient, SuspendGitOps: true})
+	require.NoError(t, err)
+	resume, err = runner.suspendGitOps(context.Background(), "orders-postgresql")
+	require.NoError(t, err)
+	assert.True(t, suspended())
+	require.NoError(t, resume(context.Background()))
+	assert.False(t, suspended())
+
+	k8sClient.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Verb != "patch"
+		return true, review, nil
+	})
+	runner, err = NewPGUpgradeRunnerWithClient("databases", k8sClient, PGUpgradeSettings{CurrentPostgresVersion: "11", TargetPostgresVersion: "15", DynamicClient: dynamicClient, SuspendGitOps: true, CheckPermissions: true})
+	require.NoError(t, err)
+	_, err = runner.suspendGitOps(context.Background(), "orders-postgresql")
+	assert.ErrorContains(t, err, "missing 1 of the 2 permissions")
+	assert.False(t, suspended())
+
+	dynamicClient.PrependReactor("get", "helmreleases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kubeerrors.NewForbidden(helmReleases.GroupResource(), "orders", errors.New("denied"))
+	})
+	_, err = runner.suspendGitOps(context.Background(), "orders-postgresql")
+	assert.ErrorContains(t, err, "failed to look up the gitops owners", "the upgrade does not scale down while the owner may still sync")
+}
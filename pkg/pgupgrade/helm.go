@@ -0,0 +1,79 @@
+package pgupgrade
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/helmrelease"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+// findHelmRelease returns the Helm release managing the statefulset, or nil when it is not managed by Helm, and warns
+// that the next helm upgrade must set the new image
+func (r *PGUpgradeRunner) findHelmRelease(ctx context.Context, statefulSetName string) *helmrelease.Release {
+	sts, err := r.k8sclient.AppsV1().StatefulSets(r.namespace).Get(ctx, statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+	release, err := helmrelease.Find(ctx, r.k8sclient, sts.ObjectMeta)
+	if err != nil {
+		r.logger.Warn("failed to read the helm release of the statefulset", "namespace", r.namespace, "statefulset", statefulSetName, "error", err)
+	}
+	if release == nil {
+		return nil
+	}
+	progress.Printf(ctx, "statefulset %q is managed by helm release %s\n", statefulSetName, release)
+	progress.Printf(ctx, "WARNING: a helm upgrade of the release without new image values reverts the image to postgres %s, which cannot start on the upgraded data. Use the values printed once the upgrade is done.\n", r.settings.CurrentPostgresVersion)
+	return release
+}
+
+// reportHelmValues prints the values patch for the next helm upgrade of the release, and writes it to the
+// HelmValuesFile when set. The upgraded claim is only set as existing claim when the chart did not create it.
+func (r *PGUpgradeRunner) reportHelmValues(ctx context.Context, release *helmrelease.Release, statefulSetName, upgradedClaim string) {
+	existingClaim := upgradedClaim
+	if r.isVolumeClaimTemplateClaim(ctx, statefulSetName, upgradedClaim) {
+		existingClaim = ""
+	}
+	patch, err := release.ValuesPatch(r.targetImageTag(), existingClaim)
+	if err != nil {
+		r.logger.Warn("failed to create the helm values patch", "release", release.Name, "error", err)
+		return
+	}
+	progress.Printf(ctx, "upgrade helm release %s with these values to run postgres %s on the upgraded data:\n%s", release.Name, r.settings.TargetPostgresVersion, patch)
+	if r.settings.HelmValuesFile == "" {
+		return
+	}
+	if err := os.WriteFile(r.settings.HelmValuesFile, patch, 0o644); err != nil {
+		r.logger.Warn("failed to write the helm values patch", "file", r.settings.HelmValuesFile, "error", err)
+		return
+	}
+	progress.Printf(ctx, "wrote the helm values to %s, for example: helm upgrade %s <chart> -n %s --reuse-values -f %s\n", r.settings.HelmValuesFile, release.Name, release.Namespace, r.settings.HelmValuesFile)
+}
+
+// isVolumeClaimTemplateClaim reports whether the claim is created by a volumeClaimTemplate of the first replica of the
+// statefulset
+func (r *PGUpgradeRunner) isVolumeClaimTemplateClaim(ctx context.Context, statefulSetName, claimName string) bool {
+	sts, err := r.k8sclient.AppsV1().StatefulSets(r.namespace).Get(ctx, statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return false
+	}
+	for _, template := range sts.Spec.VolumeClaimTemplates {
+		if fmt.Sprintf("%s-%s-0", template.Name, sts.Name) == claimName {
+			return true
+		}
+	}
+	return false
+}
+
+// targetImageTag returns the tag of the TargetImage, or the target version when no target image is set
+func (r *PGUpgradeRunner) targetImageTag() string {
+	image, _, _ := strings.Cut(r.settings.TargetImage, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return r.settings.TargetPostgresVersion
+}
@@ -0,0 +1,68 @@
+package pgupgrade
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/helmrelease"
+)
+
+func TestTargetImageTag(t *testing.T) {
+	tests := map[string]string{
+		"":                                    "16",
+		"docker.io/bitnami/postgresql:16.4.0": "16.4.0",
+		"registry:5000/bitnami/postgresql":    "16",
+		"postgres:16.4@sha256:abcd":           "16.4",
+		"registry:5000/bitnami/postgresql:16.4.0": "16.4.0",
+	}
+	for image, want := range tests {
+		runner := &PGUpgradeRunner{settings: PGUpgradeSettings{TargetImage: image, TargetPostgresVersion: "16"}}
+		assert.Equal(t, want, runner.targetImageTag(), image)
+	}
+}
+
+func TestHelmRelease(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "databases",
+		Name:        "orders-postgresql",
+		Annotations: map[string]string{helmrelease.AnnotationReleaseName: "orders"},
+	}})
+	valuesFile := filepath.Join(t.TempDir(), "values.yaml")
+	runner, err := NewPGUpgradeRunnerWithClient("databases", k8sClient, PGUpgradeSettings{
+		CurrentPostgresVersion: "11",
+		TargetPostgresVersion:  "15",
+		TargetImage:            "docker.io/bitnami/postgresql:15.4.0",
+		HelmValuesFile:         valuesFile,
+	})
+	require.NoError(t, err)
+
+	release := runner.findHelmRelease(context.Background(), "orders-postgresql")
+	require.NotNil(t, release, "the release secret is missing, the release is found by its annotations")
+	assert.Equal(t, "orders", release.Name)
+	assert.Nil(t, runner.findHelmRelease(context.Background(), "missing"))
+
+	runner.reportHelmValues(context.Background(), release, "orders-postgresql", "upgraded-orders-postgresql")
+	values, err := os.ReadFile(valuesFile)
+	require.NoError(t, err)
+	assert.Equal(t, "image:\n  tag: 15.4.0\npersistence:\n  existingClaim: upgraded-orders-postgresql\n", string(values))
+
+	// the claim of the volumeClaimTemplate can not be set as existing claim, helm can not remove the template
+	sts, err := k8sClient.AppsV1().StatefulSets("databases").Get(context.Background(), "orders-postgresql", metav1.GetOptions{})
+	require.NoError(t, err)
+	sts.Spec.VolumeClaimTemplates = []v1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}}
+	_, err = k8sClient.AppsV1().StatefulSets("databases").Update(context.Background(), sts, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	runner.reportHelmValues(context.Background(), release, "orders-postgresql", "data-orders-postgresql-0")
+	values, err = os.ReadFile(valuesFile)
+	require.NoError(t, err)
+	assert.Equal(t, "image:\n  tag: 15.4.0\n", string(values))
+}
@@ -0,0 +1,127 @@
+package pgupgrade
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	configMapHookPrefix = "configmap:"
+	defaultInterpreter  = "/bin/sh"
+)
+
+var invalidScriptNameCharacters = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// Hook is a user supplied script that is executed during the upgrade. Pre hooks run in the prepare container after
+// prepare.sh with the old and new data directories mounted on /old and /new. Post hooks run after posthook.sh with
+// the upgraded data directory mounted on /new. A failing hook fails the upgrade.
+type Hook struct {
+	// Source is either a local file or a reference to a configmap key in the namespace of the database,
+	// in the form configmap:<name>/<key>
+	Source string
+	// Script is the Go template of the hook, rendered with the PGUpgradeSettings. Loaded from Source if empty.
+	Script string
+}
+
+// HookScript is a rendered hook, stored next to the embedded scripts in the scripts secret
+type HookScript struct {
+	FileName    string
+	Interpreter string
+	Content     string
+}
+
+// resolveHooks loads the script of every hook that does not have one yet
+func (r *PGUpgradeRunner) resolveHooks(ctx context.Context) error {
+	for _, hooks := range [][]Hook{r.settings.PreHooks, r.settings.PostHooks} {
+		for i := range hooks {
+			if hooks[i].Script != "" {
+				continue
+			}
+			script, err := r.loadHook(ctx, hooks[i].Source)
+			if err != nil {
+				return err
+			}
+			hooks[i].Script = script
+		}
+	}
+	return nil
+}
+
+func (r *PGUpgradeRunner) loadHook(ctx context.Context, source string) (string, error) {
+	reference, isConfigMap := strings.CutPrefix(source, configMapHookPrefix)
+	if !isConfigMap {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return "", fmt.Errorf("failed to read hook %q: %w", source, err)
+		}
+		return string(data), nil
+	}
+
+	name, key, found := strings.Cut(reference, "/")
+	if !found || name == "" || key == "" {
+		return "", fmt.Errorf("invalid hook reference %q: must be in the form %s<name>/<key>", source, configMapHookPrefix)
+	}
+	configMap, err := r.k8sclient.CoreV1().ConfigMaps(r.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get configmap for hook %q: %w", source, err)
+	}
+	script, ok := configMap.Data[key]
+	if !ok {
+		return "", fmt.Errorf("configmap %q does not contain key %q", name, key)
+	}
+	return script, nil
+}
+
+// renderHooks renders the hooks with the settings of the upgrade. The file names are prefixed
+// to keep the hooks ordered and unique within the scripts secret.
+func renderHooks(prefix string, hooks []Hook, settings PGUpgradeSettings) ([]HookScript, error) {
+	scripts := make([]HookScript, 0, len(hooks))
+	for i, hook := range hooks {
+		tmpl, err := template.New(hook.Source).Option("missingkey=error").Parse(hook.Script)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hook %q: %w", hook.Source, err)
+		}
+		var content bytes.Buffer
+		if err := tmpl.Execute(&content, settings); err != nil {
+			return nil, fmt.Errorf("failed to render hook %q: %w", hook.Source, err)
+		}
+
+		baseName := filepath.Base(strings.TrimPrefix(hook.Source, configMapHookPrefix))
+		scripts = append(scripts, HookScript{
+			FileName:    fmt.Sprintf("%s-%02d-%s", prefix, i, invalidScriptNameCharacters.ReplaceAllString(baseName, "_")),
+			Interpreter: getInterpreter(content.String()),
+			Content:     content.String(),
+		})
+	}
+	return scripts, nil
+}
+
+// getInterpreter returns the interpreter of the shebang line, the scripts secret is not mounted as executable
+func getInterpreter(script string) string {
+	firstLine, _, _ := strings.Cut(script, "\n")
+	interpreter, ok := strings.CutPrefix(strings.TrimSpace(firstLine), "#!")
+	if !ok || strings.TrimSpace(interpreter) == "" {
+		return defaultInterpreter
+	}
+	return strings.TrimSpace(interpreter)
+}
+
+// newScriptCommand returns the command and args that run the embedded script followed by the hooks in order
+func newScriptCommand(script string, hooks []HookScript) ([]string, []string) {
+	if len(hooks) == 0 {
+		return []string{"/bin/sh"}, []string{fmt.Sprintf("/scripts/%s", script)}
+	}
+	steps := []string{fmt.Sprintf("/bin/sh /scripts/%s", script)}
+	for _, hook := range hooks {
+		steps = append(steps, fmt.Sprintf("echo 'running hook %s' && %s /scripts/%s", hook.FileName, hook.Interpreter, hook.FileName))
+	}
+	return []string{"/bin/sh", "-c"}, []string{strings.Join(steps, " && ")}
+}
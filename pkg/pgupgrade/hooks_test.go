@@ -0,0 +1,35 @@
+package pgupgrade
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderHooks(t *testing.T) {
+	settings := PGUpgradeSettings{CurrentPostgresVersion: "11", TargetPostgresVersion: "15"}
+	scripts, err := renderHooks("pre-hook", []Hook{
+		{Source: "hooks/fix permissions.sh", Script: "#!/bin/bash\necho upgrading from {{ .CurrentPostgresVersion }} to {{ .TargetPostgresVersion }}\n"},
+		{Source: "configmap:hooks/vacuum", Script: "vacuumdb --all"},
+	}, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, []HookScript{
+		{FileName: "pre-hook-00-fix_permissions.sh", Interpreter: "/bin/bash", Content: "#!/bin/bash\necho upgrading from 11 to 15\n"},
+		{FileName: "pre-hook-01-vacuum", Interpreter: "/bin/sh", Content: "vacuumdb --all"},
+	}, scripts)
+
+	_, err = renderHooks("post-hook", []Hook{{Source: "broken.sh", Script: "{{ .Unknown }}"}}, settings)
+	assert.Error(t, err)
+}
+
+func TestNewScriptCommand(t *testing.T) {
+	command, args := newScriptCommand(PrepareScriptFileName, nil)
+	assert.Equal(t, []string{"/bin/sh"}, command)
+	assert.Equal(t, []string{"/scripts/prepare.sh"}, args)
+
+	command, args = newScriptCommand(PrepareScriptFileName, []HookScript{{FileName: "pre-hook-00-a.sh", Interpreter: "/bin/bash"}})
+	assert.Equal(t, []string{"/bin/sh", "-c"}, command)
+	assert.Equal(t, []string{"/bin/sh /scripts/prepare.sh && echo 'running hook pre-hook-00-a.sh' && /bin/bash /scripts/pre-hook-00-a.sh"}, args)
+}
@@ -0,0 +1,72 @@
+package pgupgrade
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+const (
+	// DefaultUpgradeImageTemplate follows the <image>:<from>-to-<to> tag convention of tianon/postgres-upgrade
+	DefaultUpgradeImageTemplate = "{{ .Image }}:{{ .From }}-to-{{ .To }}{{ if .Distro }}-{{ .Distro }}{{ end }}"
+	// DefaultPGDataOldTemplate and DefaultPGDataNewTemplate match the PGDATAOLD and PGDATANEW defaults of tianon/postgres-upgrade
+	DefaultPGDataOldTemplate = "/var/lib/postgresql/{{ .From }}/data"
+	DefaultPGDataNewTemplate = "/var/lib/postgresql/{{ .To }}/data"
+)
+
+// UpgradeTemplateData contains the values available in the upgrade image and path templates
+type UpgradeTemplateData struct {
+	// Image is the value of the --upgrade-image flag
+	Image string
+	// From is the current postgres major version
+	From string
+	// To is the target postgres major version
+	To string
+	// Distro is the optional distribution suffix, for example bookworm or alpine
+	Distro string
+}
+
+func (s *PGUpgradeSettings) templateData() UpgradeTemplateData {
+	return UpgradeTemplateData{
+		Image:  s.UpgradeImage,
+		From:   s.CurrentPostgresVersion,
+		To:     s.TargetPostgresVersion,
+		Distro: s.UpgradeImageDistro,
+	}
+}
+
+func (s *PGUpgradeSettings) validateTemplates() error {
+	templates := map[string]string{
+		"upgrade image template": s.UpgradeImageTemplate,
+		"pgbin old":              s.PGBinOld,
+		"pgbin new":              s.PGBinNew,
+		"pgdata old":             s.PGDataOld,
+		"pgdata new":             s.PGDataNew,
+	}
+	for name, text := range templates {
+		// render with the current values to catch unknown placeholders early
+		if _, err := renderUpgradeTemplate(name, text, s.templateData()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderUpgradeTemplate(name, text string, data UpgradeTemplateData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: %w", name, text, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render %s %q: %w", name, text, err)
+	}
+	return out.String(), nil
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,50 @@
+package pgupgrade
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/imagemirror"
+)
+
+func TestGetUpgradeImage(t *testing.T) {
+	settings := PGUpgradeSettings{
+		UpgradeImage:           "tianon/postgres-upgrade",
+		CurrentPostgresVersion: "11",
+		TargetPostgresVersion:  "15",
+	}
+
+	image, err := settings.GetUpgradeImage()
+	require.NoError(t, err)
+	assert.Equal(t, "tianon/postgres-upgrade:11-to-15", image)
+
+	settings.UpgradeImageTemplate = "registry.example.com/pg-upgrade:{{ .To }}-from-{{ .From }}-{{ .Distro }}"
+	settings.UpgradeImageDistro = "bookworm"
+	image, err = settings.GetUpgradeImage()
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com/pg-upgrade:15-from-11-bookworm", image)
+
+	settings.UpgradeImageTemplate = ""
+	settings.ImageMirrors = &imagemirror.Config{Mirrors: []imagemirror.Mirror{{Source: "docker.io/tianon", Mirror: "mirror.example.com/tianon"}}}
+	image, err = settings.GetUpgradeImage()
+	require.NoError(t, err)
+	assert.Equal(t, "mirror.example.com/tianon/postgres-upgrade:11-to-15-bookworm", image)
+}
+
+func TestGetPGDataPaths(t *testing.T) {
+	settings := PGUpgradeSettings{CurrentPostgresVersion: "11", TargetPostgresVersion: "15"}
+
+	oldPath, err := settings.GetPGDataOld()
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/postgresql/11/data", oldPath)
+
+	settings.PGDataNew = "/data/{{ .To }}"
+	newPath, err := settings.GetPGDataNew()
+	require.NoError(t, err)
+	assert.Equal(t, "/data/15", newPath)
+
+	settings.PGBinOld = "{{ .Missing }}"
+	assert.Error(t, settings.Validate())
+}
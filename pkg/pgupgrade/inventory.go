@@ -0,0 +1,175 @@
+package pgupgrade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Kinds of the workloads in the inventory
+const (
+	KindStatefulSet = "StatefulSet"
+	KindDeployment  = "Deployment"
+)
+
+// InventoryItem is a workload running postgres
+type InventoryItem struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	// MajorVersion is detected from the image tag, empty when the tag does not contain a version
+	MajorVersion string `json:"majorVersion,omitempty"`
+	// DataPVC is the pvc with the data directory, of the first replica for a statefulset
+	DataPVC      string `json:"dataPVC,omitempty"`
+	Size         string `json:"size,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	Replicas     int32  `json:"replicas"`
+}
+
+// Inventory returns the statefulsets and deployments running postgres in namespace, or in all namespaces when
+// namespace is empty, sorted by namespace with the statefulsets first. The postgres container is found in the same way as
+// during an upgrade.
+func Inventory(ctx context.Context, k8sClient kubernetes.Interface, namespace, selector string) ([]InventoryItem, error) {
+	listOptions := metav1.ListOptions{LabelSelector: selector}
+	items := []InventoryItem{}
+
+	statefulSets, err := k8sClient.AppsV1().StatefulSets(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets: %w", err)
+	}
+	for _, sts := range statefulSets.Items {
+		container := findPostgresContainer(sts.Spec.Template.Spec.Containers)
+		if container == nil {
+			continue
+		}
+		item := newInventoryItem(sts.Namespace, KindStatefulSet, sts.Name, container, sts.Spec.Replicas)
+		claimTemplate := findStatefulSetDataClaimTemplate(&sts, container)
+		if claimTemplate != nil {
+			item.DataPVC = fmt.Sprintf("%s-%s-0", claimTemplate.Name, sts.Name)
+			// the template describes the pvc, unless it has been created or resized since
+			if size, ok := claimTemplate.Spec.Resources.Requests[v1.ResourceStorage]; ok {
+				item.Size = size.String()
+			}
+			item.StorageClass = getStorageClassForPVC(claimTemplate)
+		}
+		if err := addPersistentVolumeClaimDetails(ctx, k8sClient, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	deployments, err := k8sClient.AppsV1().Deployments(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	for _, deployment := range deployments.Items {
+		container := findPostgresContainer(deployment.Spec.Template.Spec.Containers)
+		if container == nil {
+			continue
+		}
+		item := newInventoryItem(deployment.Namespace, KindDeployment, deployment.Name, container, deployment.Spec.Replicas)
+		item.DataPVC = findMountedClaimName(deployment.Spec.Template.Spec.Volumes, container)
+		if err := addPersistentVolumeClaimDetails(ctx, k8sClient, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Namespace != items[j].Namespace {
+			return items[i].Namespace < items[j].Namespace
+		}
+		if items[i].Kind != items[j].Kind {
+			return items[i].Kind > items[j].Kind
+		}
+		return items[i].Name < items[j].Name
+	})
+	return items, nil
+}
+
+func newInventoryItem(namespace, kind, name string, container *v1.Container, replicas *int32) InventoryItem {
+	item := InventoryItem{
+		Namespace: namespace,
+		Kind:      kind,
+		Name:      name,
+		Container: container.Name,
+		Image:     container.Image,
+		Replicas:  1,
+	}
+	if replicas != nil {
+		item.Replicas = *replicas
+	}
+	if version, err := AutoDiscoverPostgresVersionFromImage(container.Image); err == nil {
+		item.MajorVersion = version
+	}
+	return item
+}
+
+// findStatefulSetDataClaimTemplate returns the volume claim template mounted by the postgres container
+func findStatefulSetDataClaimTemplate(sts *appsv1.StatefulSet, container *v1.Container) *v1.PersistentVolumeClaim {
+	for _, mount := range container.VolumeMounts {
+		for i, template := range sts.Spec.VolumeClaimTemplates {
+			if template.Name == mount.Name {
+				return &sts.Spec.VolumeClaimTemplates[i]
+			}
+		}
+	}
+	return nil
+}
+
+// findMountedClaimName returns the pvc of the first persistent volume claim volume mounted by the container
+func findMountedClaimName(volumes []v1.Volume, container *v1.Container) string {
+	for _, mount := range container.VolumeMounts {
+		for _, volume := range volumes {
+			if volume.Name == mount.Name && volume.PersistentVolumeClaim != nil {
+				return volume.PersistentVolumeClaim.ClaimName
+			}
+		}
+	}
+	return ""
+}
+
+// addPersistentVolumeClaimDetails sets the size and storage class of the data pvc of the item, when it exists
+func addPersistentVolumeClaimDetails(ctx context.Context, k8sClient kubernetes.Interface, item *InventoryItem) error {
+	if item.DataPVC == "" {
+		return nil
+	}
+	pvc, err := k8sClient.CoreV1().PersistentVolumeClaims(item.Namespace).Get(ctx, item.DataPVC, metav1.GetOptions{})
+	if kubeerrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get persistent volume claim %q: %w", item.DataPVC, err)
+	}
+	item.Size = getDiskSizeOrUsePVCDiskRequestSize(item.Size, pvc)
+	if storageClass := getStorageClassForPVC(pvc); storageClass != "" {
+		item.StorageClass = storageClass
+	}
+	return nil
+}
+
+// InventoryTable returns the header and rows of the inventory as a table
+func InventoryTable(items []InventoryItem) ([]string, [][]string) {
+	rows := make([][]string, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, []string{
+			item.Namespace,
+			fmt.Sprintf("%s/%s", item.Kind, item.Name),
+			item.Image,
+			item.MajorVersion,
+			item.DataPVC,
+			item.Size,
+			item.StorageClass,
+			fmt.Sprint(item.Replicas),
+		})
+	}
+	return []string{"namespace", "workload", "image", "version", "data pvc", "size", "storage class", "replicas"}, rows
+}
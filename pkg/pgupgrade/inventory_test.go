@@ -0,0 +1,107 @@
+package pgupgrade
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/ptrs"
+)
+
+func newTestPodTemplate(image string, mounts ...string) v1.PodTemplateSpec {
+	container := v1.Container{Name: "postgresql", Image: image}
+	for _, mount := range mounts {
+		container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{Name: mount, MountPath: "/" + mount})
+	}
+	return v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{
+		{Name: "metrics", Image: "docker.io/bitnami/postgres-exporter:0.15.0"},
+		container,
+	}}}
+}
+
+func newTestPersistentVolumeClaim(namespace, name, size, storageClass string) v1.PersistentVolumeClaim {
+	return v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+			Resources:        v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(size)}},
+		},
+	}
+}
+
+func TestInventory(t *testing.T) {
+	resizedPVC := newTestPersistentVolumeClaim("team-a", "data-database-postgresql-0", "20Gi", "fast")
+	deploymentPVC := newTestPersistentVolumeClaim("team-b", "legacy-data", "5Gi", "standard")
+	k8sClient := fake.NewSimpleClientset(
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "database-postgresql"},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas:             ptrs.Int32(2),
+				Template:             newTestPodTemplate("docker.io/bitnami/postgresql:15.4.0-debian-11-r10", "empty-dir", "data"),
+				VolumeClaimTemplates: []v1.PersistentVolumeClaim{newTestPersistentVolumeClaim("", "data", "8Gi", "fast")},
+			},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "redis"},
+			Spec:       appsv1.StatefulSetSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "redis", Image: "docker.io/library/redis:7"}}}}},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "legacy"},
+			Spec: appsv1.DeploymentSpec{
+				Template: func() v1.PodTemplateSpec {
+					template := newTestPodTemplate("docker.io/library/postgres:latest", "data")
+					template.Spec.Volumes = []v1.Volume{{
+						Name:         "data",
+						VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "legacy-data"}},
+					}}
+					return template
+				}(),
+			},
+		},
+		&resizedPVC,
+		&deploymentPVC,
+	)
+
+	items, err := Inventory(context.Background(), k8sClient, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, []InventoryItem{
+		{
+			Namespace:    "team-a",
+			Kind:         KindStatefulSet,
+			Name:         "database-postgresql",
+			Container:    "postgresql",
+			Image:        "docker.io/bitnami/postgresql:15.4.0-debian-11-r10",
+			MajorVersion: "15",
+			DataPVC:      "data-database-postgresql-0",
+			Size:         "20Gi",
+			StorageClass: "fast",
+			Replicas:     2,
+		},
+		{
+			Namespace:    "team-b",
+			Kind:         KindDeployment,
+			Name:         "legacy",
+			Container:    "postgresql",
+			Image:        "docker.io/library/postgres:latest",
+			DataPVC:      "legacy-data",
+			Size:         "5Gi",
+			StorageClass: "standard",
+			Replicas:     1,
+		},
+	}, items)
+
+	items, err = Inventory(context.Background(), k8sClient, "team-b", "")
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+
+	header, rows := InventoryTable(items)
+	assert.Len(t, header, 8)
+	assert.Equal(t, []string{"team-b", "Deployment/legacy", "docker.io/library/postgres:latest", "", "legacy-data", "5Gi", "standard", "1"}, rows[0])
+}
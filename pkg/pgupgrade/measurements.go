@@ -0,0 +1,57 @@
+package pgupgrade
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+// dataDirectorySizePrefix starts the DATA_DIRECTORY_SIZE|<stage>|<kilobytes> lines printed by prepare.sh and upgrade.sh
+const dataDirectorySizePrefix = "DATA_DIRECTORY_SIZE|"
+
+// Stages of the data directory size measurement
+const (
+	DataDirectoryStageBefore = "before"
+	DataDirectoryStageAfter  = "after"
+)
+
+// reportUpgradeVersions reports the postgres versions of the upgrade, once they are known
+func (r *PGUpgradeRunner) reportUpgradeVersions(ctx context.Context) {
+	progress.Measure(ctx, progress.MeasurementUpgradeInfo, 1, map[string]string{
+		"from_version": r.settings.CurrentPostgresVersion,
+		"to_version":   r.settings.TargetPostgresVersion,
+	})
+}
+
+// parseDataDirectorySize parses a data directory size line, returning the stage and the size in bytes
+func parseDataDirectorySize(line string) (string, float64, bool) {
+	fields := strings.Split(strings.TrimSpace(line), "|")
+	if len(fields) != 3 || fields[0]+"|" != dataDirectorySizePrefix {
+		return "", 0, false
+	}
+	if fields[1] != DataDirectoryStageBefore && fields[1] != DataDirectoryStageAfter {
+		return "", 0, false
+	}
+	kilobytes, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return fields[1], kilobytes * 1024, true
+}
+
+// newDataDirectorySizeHandler returns a log handler reporting the data directory sizes printed by the upgrade pod.
+// pg_upgrade copies the data files, so the size of the new data directory is the number of bytes copied.
+func newDataDirectorySizeHandler(ctx context.Context) func(line string) {
+	return func(line string) {
+		stage, bytes, ok := parseDataDirectorySize(line)
+		if !ok {
+			return
+		}
+		progress.Measure(ctx, progress.MeasurementDataDirectoryBytes, bytes, map[string]string{"stage": stage})
+		if stage == DataDirectoryStageAfter {
+			progress.Measure(ctx, progress.MeasurementCopiedBytes, bytes, nil)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package pgupgrade
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/ptrs"
+)
+
+type recordingReporter struct {
+	events []progress.Event
+}
+
+func (r *recordingReporter) Report(event progress.Event) {
+	r.events = append(r.events, event)
+}
+
+func TestParseDataDirectorySize(t *testing.T) {
+	stage, bytes, ok := parseDataDirectorySize("DATA_DIRECTORY_SIZE|before|2048\n")
+	assert.True(t, ok)
+	assert.Equal(t, DataDirectoryStageBefore, stage)
+	assert.Equal(t, float64(2048*1024), bytes)
+
+	for _, line := range []string{
+		"database size:",
+		"DATA_DIRECTORY_SIZE|during|10",
+		"DATA_DIRECTORY_SIZE|after|",
+		"DATA_DIRECTORY_SIZE|after|10|extra",
+	} {
+		_, _, ok := parseDataDirectorySize(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestDataDirectorySizeHandler(t *testing.T) {
+	reporter := &recordingReporter{}
+	handler := newDataDirectorySizeHandler(progress.WithReporter(context.Background(), reporter))
+
+	handler("Performing Upgrade")
+	handler("DATA_DIRECTORY_SIZE|before|1")
+	handler("DATA_DIRECTORY_SIZE|after|2")
+
+	if assert.Len(t, reporter.events, 3) {
+		assert.Equal(t, progress.MeasurementDataDirectoryBytes, reporter.events[0].Measurement)
+		assert.Equal(t, map[string]string{"stage": "before"}, reporter.events[0].Labels)
+		assert.Equal(t, ptrs.Float64(1024), reporter.events[0].Value)
+		assert.Equal(t, map[string]string{"stage": "after"}, reporter.events[1].Labels)
+		assert.Equal(t, progress.MeasurementCopiedBytes, reporter.events[2].Measurement)
+		assert.Equal(t, ptrs.Float64(2048), reporter.events[2].Value)
+	}
+}
@@ -4,19 +4,25 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 	"k8s.io/client-go/util/retry"
 
+	"github.com/containerinfra/kube-pg-upgrade/pkg/imagemirror"
 	"github.com/containerinfra/kube-pg-upgrade/pkg/kubesecrethelper"
 	"github.com/containerinfra/kube-pg-upgrade/pkg/kubevolumes"
 	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
-	"github.com/containerinfra/kube-pg-upgrade/pkg/ptrs"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
 )
 
 //go:embed scripts/prepare.sh
@@ -29,8 +35,24 @@ const (
 	DefaultPostgresInitDBUser = "postgres"
 )
 
+// DefaultUpgradeCommand is the entrypoint and command of tianon/postgres-upgrade, which runs initdb for the new
+// cluster before pg_upgrade
+var DefaultUpgradeCommand = []string{"docker-upgrade", "pg_upgrade"}
+
 type PGUpgradeSettings struct {
 	UpgradeImage string
+	// UpgradeImageTemplate is a Go template rendering the upgrade image reference, see UpgradeTemplateData
+	// for the available placeholders. Defaults to DefaultUpgradeImageTemplate.
+	UpgradeImageTemplate string
+	// UpgradeImageDistro is available in the templates as {{ .Distro }}
+	UpgradeImageDistro string
+	// UpgradeCommand runs pg_upgrade in the upgrade image, it replaces the entrypoint of the image as upgrade.sh
+	// wraps it to capture the output files of pg_upgrade. Defaults to DefaultUpgradeCommand.
+	UpgradeCommand []string
+	// ImageMirrors rewrites generated images to a registry mirror, optional
+	ImageMirrors *imagemirror.Config
+	// ImagePullSecrets are added to every pod created during the upgrade
+	ImagePullSecrets []string
 
 	InitDBArgs string
 	DiskSize   string
@@ -45,10 +67,119 @@ type PGUpgradeSettings struct {
 	SourcePVCName string
 	TargetPVCName string
 	SubPath       string
+	// TargetSubPath is the directory of the upgraded data on the target volume, defaults to SubPath
+	TargetSubPath string
+	// KeepSourcePVC keeps the source pvc bound to the original data instead of replacing it, the upgraded data is
+	// bound to TargetPVCName which must differ from the source pvc. The statefulset is not switched over to the
+	// upgraded data, so it can be scaled back up on the original data.
+	KeepSourcePVC bool
+	// BeforeSwitchVolumes is called with the source and target pvc before the volumes are switched around, from then on
+	// an interrupted upgrade can not be restarted from the beginning. The upgrade fails when it returns an error.
+	BeforeSwitchVolumes func(ctx context.Context, sourcePVCName, targetPVCName string) error
+
+	// CheckExtensions validates that all installed extensions are available in the upgrade image
+	// and the TargetImage before the data is migrated
+	CheckExtensions bool
+	// TargetImage is the postgres image the database will run with after the upgrade, optional
+	TargetImage string
+	// HelmValuesFile is a local file the values for the next helm upgrade are written to when the statefulset is
+	// managed by a Helm release, optional
+	HelmValuesFile string
+
+	// VerifyData compares the tables, row counts and sequences of the old and new cluster before the volumes are swapped
+	VerifyData bool
+	// VerifyChecksums additionally compares a checksum of the contents of every table, requires VerifyData
+	VerifyChecksums bool
+
+	// LogDir is a local directory the logs of every upgrade pod are written to, optional
+	LogDir string
+	// StuckTimeout is how long an upgrade pod may be pending before the upgrade fails, defaults to podrunner.DefaultStuckTimeout
+	StuckTimeout time.Duration
+	// KeepOnFailure leaves failed upgrade pods, the scripts secret and the temporary volume in place for debugging
+	KeepOnFailure bool
+	// Logger receives the diagnostic logs of the upgrade, such as retries and warnings, they are discarded when nil.
+	// The progress of the upgrade is reported through the progress.Reporter of the context.
+	Logger *slog.Logger
+
+	// DynamicClient is used for the custom resources of other tools, such as Argo CD and Flux, optional
+	DynamicClient dynamic.Interface
+	// SuspendGitOps suspends the sync of the Argo CD Applications and Flux HelmReleases and Kustomizations of a
+	// statefulset during the upgrade, requires the DynamicClient
+	SuspendGitOps bool
+	// CheckPermissions reviews the permissions required by the upgrade with SelfSubjectAccessReviews before it starts,
+	// and fails when any are missing
+	CheckPermissions bool
+	// ArgoCDNamespace is the namespace of the Argo CD Applications, defaults to gitops.DefaultArgoCDNamespace
+	ArgoCDNamespace string
+
+	// UseJobs runs the upgrade steps as batch/v1 Jobs instead of bare pods
+	UseJobs    bool
+	JobOptions podrunner.JobOptions
+
+	// PreHooks and PostHooks are user supplied scripts executed after prepare.sh and posthook.sh
+	PreHooks  []Hook
+	PostHooks []Hook
+
+	// PGBinOld and PGBinNew override the PGBINOLD and PGBINNEW locations of the upgrade image, optional.
+	// PGDataOld and PGDataNew are the paths the old and new data directories are mounted on.
+	// All of them are Go templates with the same placeholders as UpgradeImageTemplate.
+	PGBinOld  string
+	PGBinNew  string
+	PGDataOld string
+	PGDataNew string
+}
+
+func (s *PGUpgradeSettings) GetUpgradeImage() (string, error) {
+	image, err := renderUpgradeTemplate("upgrade image template", orDefault(s.UpgradeImageTemplate, DefaultUpgradeImageTemplate), s.templateData())
+	if err != nil {
+		return "", err
+	}
+	return s.ImageMirrors.Apply(image), nil
+}
+
+func (s *PGUpgradeSettings) GetUpgradeCommand() []string {
+	if len(s.UpgradeCommand) == 0 {
+		return DefaultUpgradeCommand
+	}
+	return s.UpgradeCommand
+}
+
+func (s *PGUpgradeSettings) GetPGBinOld() (string, error) {
+	return renderUpgradeTemplate("pgbin old", s.PGBinOld, s.templateData())
+}
+
+func (s *PGUpgradeSettings) GetPGBinNew() (string, error) {
+	return renderUpgradeTemplate("pgbin new", s.PGBinNew, s.templateData())
+}
+
+func (s *PGUpgradeSettings) GetPGDataOld() (string, error) {
+	return renderUpgradeTemplate("pgdata old", orDefault(s.PGDataOld, DefaultPGDataOldTemplate), s.templateData())
+}
+
+func (s *PGUpgradeSettings) GetPGDataNew() (string, error) {
+	return renderUpgradeTemplate("pgdata new", orDefault(s.PGDataNew, DefaultPGDataNewTemplate), s.templateData())
+}
+
+func (s *PGUpgradeSettings) GetImagePullSecrets() []v1.LocalObjectReference {
+	if len(s.ImagePullSecrets) == 0 {
+		return nil
+	}
+	secrets := make([]v1.LocalObjectReference, 0, len(s.ImagePullSecrets))
+	for _, name := range s.ImagePullSecrets {
+		secrets = append(secrets, v1.LocalObjectReference{Name: name})
+	}
+	return secrets
+}
+
+func (s *PGUpgradeSettings) GetLogger() *slog.Logger {
+	if s.Logger == nil {
+		return discardLogger()
+	}
+	return s.Logger
 }
 
-func (s *PGUpgradeSettings) GetUpgradeImage() string {
-	return fmt.Sprintf("%s:%s-to-%s", s.UpgradeImage, s.CurrentPostgresVersion, s.TargetPostgresVersion)
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
 }
 
 func (s *PGUpgradeSettings) GetInitDBUser() string {
@@ -62,7 +193,7 @@ func (s *PGUpgradeSettings) Validate() error {
 	if s.TargetPostgresVersion == "" {
 		return fmt.Errorf("missing target postgres version")
 	}
-	return nil
+	return s.validateTemplates()
 }
 
 const (
@@ -77,24 +208,67 @@ type JobActions struct {
 	JobContainer      v1.Container
 	PrepareContainer  v1.Container
 	PostHookContainer v1.Container
+	ImagePullSecrets  []v1.LocalObjectReference
+	// Hooks are stored in the scripts secret next to the embedded scripts
+	Hooks []HookScript
+	// VerifyContainer compares the old and new cluster after pg_upgrade, optional
+	VerifyContainer *v1.Container
+	// KeepOnFailure keeps the scripts secret when the upgrade fails, for debugging
+	KeepOnFailure bool
+	// KeepSourcePVC keeps the source pvc instead of replacing it with the upgraded data
+	KeepSourcePVC bool
+	// BeforeSwitchVolumes is called before the volumes are switched around, optional
+	BeforeSwitchVolumes func(ctx context.Context, sourcePVCName, targetPVCName string) error
+	// LogDir is the local directory the pg_upgrade output files are saved to when the upgrade fails, optional
+	LogDir string
+	// Logger receives the diagnostic logs of the data migration
+	Logger *slog.Logger
+}
+
+func (j JobActions) scriptsSecretData() map[string][]byte {
+	data := map[string][]byte{
+		PrepareScriptFileName:  []byte(j.Script),
+		PostHookScriptFileName: []byte(j.PostHookScript),
+		UpgradeScriptFileName:  []byte(upgradeScript),
+	}
+	for _, hook := range j.Hooks {
+		data[hook.FileName] = []byte(hook.Content)
+	}
+	if j.VerifyContainer != nil {
+		data[VerifyScriptFileName] = []byte(verifyScript)
+	}
+	return data
 }
 
-func RunPGDataMigration(ctx context.Context, k8sClient *kubernetes.Clientset, namespace, sourcePersistenVolumeName, targetPVCName, storageClassName string, newSize string, jobaction JobActions) error {
+// MigrationResult describes the outcome of a successful data migration
+type MigrationResult struct {
+	// TargetPVCName is the pvc bound to the volume with the upgraded data
+	TargetPVCName string
+	// RetainedPersistentVolume is the volume with the original data, it is retained and no longer bound to a pvc
+	RetainedPersistentVolume string
+	// UpgradeImageID is the image, including its digest when reported by the container runtime, pg_upgrade ran with
+	UpgradeImageID string
+}
+
+func RunPGDataMigration(ctx context.Context, k8sClient kubernetes.Interface, r podrunner.Runner, namespace, sourcePersistenVolumeName, targetPVCName, storageClassName string, newSize string, jobaction JobActions) (result *MigrationResult, err error) {
+	if jobaction.Logger == nil {
+		jobaction.Logger = discardLogger()
+	}
 	upgradePodName := Truncate(jobaction.Name+sourcePersistenVolumeName, 63)
-	upgradeTargetPersistentVolumeTempName := Truncate("tmp-"+sourcePersistenVolumeName, 63)
+	upgradeTargetPersistentVolumeTempName := getTemporaryPVCName(sourcePersistenVolumeName)
 
 	if err := kubevolumes.ValidateStorageClassExists(ctx, k8sClient, storageClassName); err != nil {
-		return err
+		return nil, err
 	}
 
 	pvc, err := kubevolumes.GetPersistentVolumeClaimAndWaitForVolume(ctx, k8sClient, namespace, sourcePersistenVolumeName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	storageSize, err := resource.ParseQuantity(newSize)
 	if err != nil {
-		return fmt.Errorf("cannot parse size into quantity: %v", err)
+		return nil, fmt.Errorf("cannot parse size into quantity: %v", err)
 	}
 
 	scriptSecretName := upgradePodName
@@ -102,73 +276,152 @@ func RunPGDataMigration(ctx context.Context, k8sClient *kubernetes.Clientset, na
 	err = kubesecrethelper.CreateOrUpdateSecret(ctx, k8sClient, kubesecrethelper.CreateSecret(kubesecrethelper.CreateSecretOptions{
 		Name:      scriptSecretName,
 		Namespace: namespace,
-		Data: map[string][]byte{
-			PrepareScriptFileName:  []byte(jobaction.Script),
-			PostHookScriptFileName: []byte(jobaction.PostHookScript),
-		},
+		Data:      jobaction.scriptsSecretData(),
 	}))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// make sure we remove the secret once we are done with it
-	defer k8sClient.CoreV1().Secrets(namespace).Delete(context.Background(), scriptSecretName, metav1.DeleteOptions{})
+	// make sure we remove the secret once we are done with it, unless it is kept to debug a failed upgrade
+	disksSwitched := false
+	defer func() {
+		if err != nil && jobaction.KeepOnFailure {
+			if !disksSwitched {
+				printKeptUpgradeVolumes(ctx, namespace, sourcePersistenVolumeName, upgradeTargetPersistentVolumeTempName)
+			}
+			progress.Printf(ctx, "keeping scripts secret %q, remove it once done using:\n", scriptSecretName)
+			progress.Printf(ctx, "  kubectl -n %s delete secret %s\n", namespace, scriptSecretName)
+			return
+		}
+		k8sClient.CoreV1().Secrets(namespace).Delete(context.Background(), scriptSecretName, metav1.DeleteOptions{})
+	}()
 
 	err = kubevolumes.CreatePersistentVolumeClaim(ctx, k8sClient, upgradeTargetPersistentVolumeTempName, namespace, storageClassName, storageSize)
 	if err != nil {
 		if !kubeerrors.IsAlreadyExists(err) {
-			return err
+			return nil, err
 		}
-		fmt.Printf("Using existing pvc %q\n", upgradeTargetPersistentVolumeTempName)
+		progress.Printf(ctx, "Using existing pvc %q\n", upgradeTargetPersistentVolumeTempName)
 	} else {
-		fmt.Printf("Temporary pvc %q created\n", upgradeTargetPersistentVolumeTempName)
+		progress.Printf(ctx, "Temporary pvc %q created\n", upgradeTargetPersistentVolumeTempName)
 	}
 
-	mismatch := v1.FSGroupChangeOnRootMismatch
-	r := podrunner.NewPodRunner(k8sClient)
-
 	// run the pg-upgrade job
-	err = r.RunPod(ctx, namespace, upgradePodName, v1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      upgradePodName,
-			Namespace: namespace,
-		},
-		Spec: v1.PodSpec{
-			SecurityContext: &v1.PodSecurityContext{
-				RunAsNonRoot:        ptrs.False(),
-				FSGroupChangePolicy: &mismatch,
-			},
-			InitContainers: []v1.Container{
-				jobaction.PrepareContainer,
-			},
-			Containers: []v1.Container{
-				jobaction.JobContainer,
-			},
-			RestartPolicy: v1.RestartPolicyNever,
-			Volumes: []v1.Volume{
-				kubevolumes.NewPersistentVolumeClaimVolume("old", sourcePersistenVolumeName, false),
-				kubevolumes.NewPersistentVolumeClaimVolume("new", upgradeTargetPersistentVolumeTempName, false),
-				kubevolumes.NewVolumeFromSecret("scripts", scriptSecretName),
-			},
-		},
+	upgradePod := newTaskPod(upgradePodName, namespace, jobaction.ImagePullSecrets, []v1.Container{
+		jobaction.JobContainer,
+	}, []v1.Volume{
+		kubevolumes.NewPersistentVolumeClaimVolume("old", sourcePersistenVolumeName, false),
+		kubevolumes.NewPersistentVolumeClaimVolume("new", upgradeTargetPersistentVolumeTempName, false),
+		kubevolumes.NewVolumeFromSecret("scripts", scriptSecretName),
 	})
+	upgradePod.Spec.InitContainers = []v1.Container{
+		jobaction.PrepareContainer,
+	}
+	upgradeCtx, endUpgradePhase := progress.StartPhase(ctx, progress.PhasePGUpgrade, map[string]string{"pod": upgradePodName, "pvc": sourcePersistenVolumeName, "temporaryPVC": upgradeTargetPersistentVolumeTempName})
+	upgradeOutput := newPGUpgradeOutputCollector()
+	result = &MigrationResult{
+		TargetPVCName:            targetPVCName,
+		RetainedPersistentVolume: pvc.Spec.VolumeName,
+		UpgradeImageID:           jobaction.JobContainer.Image,
+	}
+	err = r.RunPod(upgradeCtx, namespace, upgradePodName, upgradePod, podrunner.WithLogHandler(upgradeOutput.handleLine), podrunner.WithLogHandler(newDataDirectorySizeHandler(upgradeCtx)), podrunner.WithCompletedPodHandler(func(pod *v1.Pod) {
+		if imageID := getContainerImageID(pod, jobaction.JobContainer.Name); imageID != "" {
+			result.UpgradeImageID = imageID
+		}
+	}))
 	if err != nil {
-		return err
+		err = upgradeOutput.wrapError(upgradeCtx, jobaction.Logger, err, jobaction.LogDir, upgradePodName)
+	}
+	endUpgradePhase(err)
+	if err != nil {
+		return nil, err
+	}
+
+	if jobaction.VerifyContainer != nil {
+		verifyPodName := Truncate(fmt.Sprintf("verify-%s-%s", jobaction.Name, sourcePersistenVolumeName), 63)
+		verifyCtx, endVerifyPhase := progress.StartPhase(ctx, progress.PhaseVerify, map[string]string{"pod": verifyPodName})
+		progress.Printf(verifyCtx, "[pg_upgrade] verifying the upgraded data using pod %q...\n", verifyPodName)
+
+		var verifyOutput []string
+		err = r.RunPod(verifyCtx, namespace, verifyPodName, newTaskPod(verifyPodName, namespace, jobaction.ImagePullSecrets, []v1.Container{
+			*jobaction.VerifyContainer,
+		}, []v1.Volume{
+			kubevolumes.NewPersistentVolumeClaimVolume("old", sourcePersistenVolumeName, false),
+			kubevolumes.NewPersistentVolumeClaimVolume("new", upgradeTargetPersistentVolumeTempName, false),
+			kubevolumes.NewVolumeFromSecret("scripts", scriptSecretName),
+		}), podrunner.WithLogHandler(func(line string) {
+			verifyOutput = append(verifyOutput, line)
+		}))
+		if err == nil {
+			err = verifyUpgradedData(verifyCtx, verifyOutput)
+		}
+		endVerifyPhase(err)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// SWITCHING DISKS AROUND
+	if jobaction.BeforeSwitchVolumes != nil {
+		if err := jobaction.BeforeSwitchVolumes(ctx, sourcePersistenVolumeName, targetPVCName); err != nil {
+			return nil, fmt.Errorf("failed to prepare switching the volumes: %w", err)
+		}
+	}
+	disksSwitched = true
 
-	tmpPVC, err := k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, upgradeTargetPersistentVolumeTempName, metav1.GetOptions{})
+	switchCtx, endSwitchPhase := progress.StartPhase(ctx, progress.PhaseSwitchVolumes, map[string]string{"pvc": targetPVCName, "temporaryPVC": upgradeTargetPersistentVolumeTempName})
+	err = switchPersistentVolumes(switchCtx, k8sClient, namespace, pvc, upgradeTargetPersistentVolumeTempName, targetPVCName, storageClassName, storageSize, jobaction.KeepSourcePVC)
+	endSwitchPhase(err)
 	if err != nil {
-		return fmt.Errorf("failed to get persistent volume claim%q: %w", upgradeTargetPersistentVolumeTempName, err)
+		return nil, err
 	}
 
-	err = retry.OnError(retry.DefaultBackoff, RetryAllErrorsFn(ctx), func() error {
-		err = kubevolumes.SetPVReclaimPolicyToRetain(ctx, k8sClient, pvc)
-		if err != nil {
-			return err
+	postHookPodName := Truncate(fmt.Sprintf("post-upgrade-%s-%s", jobaction.Name, sourcePersistenVolumeName), 63)
+
+	postHookCtx, endPostHookPhase := progress.StartPhase(ctx, progress.PhasePostUpgradeHook, map[string]string{"pod": postHookPodName, "pvc": targetPVCName})
+	progress.Printf(postHookCtx, "[pg_upgrade] running the post upgrade hook container %q...\n", postHookPodName)
+	err = r.RunPod(postHookCtx, namespace, postHookPodName, newTaskPod(postHookPodName, namespace, jobaction.ImagePullSecrets, []v1.Container{
+		jobaction.PostHookContainer,
+	}, []v1.Volume{
+		kubevolumes.NewPersistentVolumeClaimVolume("new", targetPVCName, false),
+		kubevolumes.NewVolumeFromSecret("scripts", scriptSecretName),
+	}))
+	endPostHookPhase(err)
+	if err != nil {
+		return nil, err
+	}
+	progress.Printf(ctx, "[pg_upgrade] completed running the post upgrade hook container\n")
+	return result, nil
+}
+
+func getContainerImageID(pod *v1.Pod, containerName string) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == containerName {
+			return strings.TrimPrefix(status.ImageID, "docker-pullable://")
 		}
-		return kubevolumes.SetPVReclaimPolicyToRetain(ctx, k8sClient, tmpPVC)
-	})
+	}
+	return ""
+}
+
+// getTemporaryPVCName returns the name of the pvc the upgraded data directory is written to, before it replaces the source pvc
+func getTemporaryPVCName(sourcePVCName string) string {
+	return Truncate("tmp-"+sourcePVCName, 63)
+}
+
+// printKeptUpgradeVolumes prints how to inspect the volumes of a failed upgrade, before any disks were switched around
+func printKeptUpgradeVolumes(ctx context.Context, namespace, sourcePVCName, tmpPVCName string) {
+	progress.Printf(ctx, "the original data in %q is untouched, the temporary pvc %q with the new data directory and the pg_upgrade logs is kept\n", sourcePVCName, tmpPVCName)
+	progress.Printf(ctx, "start a debug pod with both volumes mounted using:\n")
+	progress.Printf(ctx, "  kube-pg-upgrade pgupgrade debug %s -n %s --current-version <version> --version <version>\n", sourcePVCName, namespace)
+	progress.Printf(ctx, "remove the temporary pvc once done using:\n")
+	progress.Printf(ctx, "  kubectl -n %s delete pvc %s\n", namespace, tmpPVCName)
+}
+
+// switchPersistentVolumes binds the volume with the upgraded data to the target pvc, retaining the original volume
+func switchPersistentVolumes(ctx context.Context, k8sClient kubernetes.Interface, namespace string, pvc *v1.PersistentVolumeClaim, tmpPVCName, targetPVCName, storageClassName string, storageSize resource.Quantity, keepSourcePVC bool) error {
+	tmpPVC, err := k8sClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, tmpPVCName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get persistent volume claim%q: %w", tmpPVCName, err)
+	}
 
 	err = retry.OnError(retry.DefaultBackoff, RetryAllErrorsFn(ctx), func() error {
 		err = kubevolumes.SetPVReclaimPolicyToRetain(ctx, k8sClient, pvc)
@@ -182,7 +435,7 @@ func RunPGDataMigration(ctx context.Context, k8sClient *kubernetes.Clientset, na
 	}
 
 	// make sure the persistent volumes are set correctly
-	err = cleanupPersistentVolumes(ctx, k8sClient, namespace, upgradeTargetPersistentVolumeTempName, sourcePersistenVolumeName)
+	err = cleanupPersistentVolumes(ctx, k8sClient, namespace, tmpPVCName, pvc.Name, keepSourcePVC)
 	if err != nil {
 		return err
 	}
@@ -192,44 +445,12 @@ func RunPGDataMigration(ctx context.Context, k8sClient *kubernetes.Clientset, na
 	if err != nil {
 		return err
 	}
-	err = retry.OnError(retry.DefaultBackoff, RetryAllErrorsFn(ctx), func() error {
-		return validatePVCCreationCompleted(ctx, k8sClient, targetPVCName, namespace, tmpPVC, storageClassName, sourcePersistenVolumeName, pvc)
-	})
-	if err != nil {
-		return err
-	}
-
-	postHookPodName := Truncate(fmt.Sprintf("post-upgrade-%s-%s", jobaction.Name, sourcePersistenVolumeName), 63)
-
-	fmt.Printf("[pg_upgrade] running the post upgrade hook container %q...\n", postHookPodName)
-	err = r.RunPod(ctx, namespace, postHookPodName, v1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      postHookPodName,
-			Namespace: namespace,
-		},
-		Spec: v1.PodSpec{
-			SecurityContext: &v1.PodSecurityContext{
-				RunAsNonRoot:        ptrs.False(),
-				FSGroupChangePolicy: &mismatch,
-			},
-			Containers: []v1.Container{
-				jobaction.PostHookContainer,
-			},
-			RestartPolicy: v1.RestartPolicyNever,
-			Volumes: []v1.Volume{
-				kubevolumes.NewPersistentVolumeClaimVolume("new", targetPVCName, false),
-				kubevolumes.NewVolumeFromSecret("scripts", scriptSecretName),
-			},
-		},
+	return retry.OnError(retry.DefaultBackoff, RetryAllErrorsFn(ctx), func() error {
+		return validatePVCCreationCompleted(ctx, k8sClient, targetPVCName, namespace, tmpPVC, storageClassName, pvc.Name, pvc)
 	})
-	if err != nil {
-		return err
-	}
-	fmt.Printf("[pg_upgrade] completed running the post upgrade hook container\n")
-	return nil
 }
 
-func validatePVCCreationCompleted(ctx context.Context, k8sClient *kubernetes.Clientset, targetPVCName string, namespace string, tmpPVC *v1.PersistentVolumeClaim, storageClassName string, sourcePersistenVolumeName string, pvc *v1.PersistentVolumeClaim) error {
+func validatePVCCreationCompleted(ctx context.Context, k8sClient kubernetes.Interface, targetPVCName string, namespace string, tmpPVC *v1.PersistentVolumeClaim, storageClassName string, sourcePersistenVolumeName string, pvc *v1.PersistentVolumeClaim) error {
 	finalPVC, err := kubevolumes.GetPersistentVolumeClaimAndWaitForVolume(ctx, k8sClient, namespace, targetPVCName)
 	if err != nil {
 		return fmt.Errorf("failed to get new persistent volume claim%q: %w", targetPVCName, err)
@@ -246,11 +467,11 @@ func validatePVCCreationCompleted(ctx context.Context, k8sClient *kubernetes.Cli
 	if *finalPVC.Spec.StorageClassName != storageClassName {
 		return fmt.Errorf("new persistent volume claim %q has the storageclass %q and not the given storageclass %q", sourcePersistenVolumeName, *finalPVC.Spec.StorageClassName, storageClassName)
 	}
-	fmt.Printf("Data in %q succesfully migrated to %q bound to PVC %q with storageclass %q\n", pvc.Spec.VolumeName, finalPVC.Spec.VolumeName, finalPVC.Name, *finalPVC.Spec.StorageClassName)
+	progress.Printf(ctx, "Data in %q succesfully migrated to %q bound to PVC %q with storageclass %q\n", pvc.Spec.VolumeName, finalPVC.Spec.VolumeName, finalPVC.Name, *finalPVC.Spec.StorageClassName)
 	return nil
 }
 
-func createFinalTargetPVCWithPV(ctx context.Context, k8sClient *kubernetes.Clientset, tmpPVC *v1.PersistentVolumeClaim, targetPVCName string, namespace string, storageClassName string, storageSize resource.Quantity) error {
+func createFinalTargetPVCWithPV(ctx context.Context, k8sClient kubernetes.Interface, tmpPVC *v1.PersistentVolumeClaim, targetPVCName string, namespace string, storageClassName string, storageSize resource.Quantity) error {
 	err := retry.OnError(retry.DefaultBackoff, RetryAllErrorsFn(ctx), func() error {
 		err := kubevolumes.RemoveClaimRefOfPV(ctx, k8sClient, tmpPVC)
 		if err != nil {
@@ -269,22 +490,27 @@ func createFinalTargetPVCWithPV(ctx context.Context, k8sClient *kubernetes.Clien
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Created final pvc %q\n", targetPVCName)
+	progress.Printf(ctx, "Created final pvc %q\n", targetPVCName)
 	return nil
 }
 
-func cleanupPersistentVolumes(ctx context.Context, k8sClient *kubernetes.Clientset, namespace string, tmpPVCName string, pvcName string) error {
+func cleanupPersistentVolumes(ctx context.Context, k8sClient kubernetes.Interface, namespace string, tmpPVCName string, pvcName string, keepSourcePVC bool) error {
 	err := k8sClient.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, tmpPVCName, metav1.DeleteOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to delete persistent volume claim%q: %w", tmpPVCName, err)
 	}
-	fmt.Printf("Deleting temp pvc %q (persistent volume is marked as retain)\n", tmpPVCName)
+	progress.Printf(ctx, "Deleting temp pvc %q (persistent volume is marked as retain)\n", tmpPVCName)
+
+	if keepSourcePVC {
+		progress.Printf(ctx, "Keeping source pvc %q with the original data\n", pvcName)
+		return nil
+	}
 
 	err = k8sClient.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, pvcName, metav1.DeleteOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to delete persistent volume claim%q: %w", pvcName, err)
 	}
-	fmt.Printf("Deleting source pvc: %s (persistent volume is marked as retain)\n", pvcName)
+	progress.Printf(ctx, "Deleting source pvc: %s (persistent volume is marked as retain)\n", pvcName)
 
 	err = kubevolumes.WaitForPVCToBeDeleted(ctx, k8sClient, namespace, pvcName)
 	if err != nil {
@@ -5,21 +5,57 @@ import (
 
 	"github.com/containerinfra/kube-pg-upgrade/pkg/ptrs"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func createUpgradeJobActionInput(settings PGUpgradeSettings, sourceSubPath, targetSubPath string, pgUser string, extraInitDBArgs string) JobActions {
+func createUpgradeJobActionInput(settings PGUpgradeSettings, sourceSubPath, targetSubPath string, pgUser string, extraInitDBArgs string) (JobActions, error) {
+	upgradeImage, err := settings.GetUpgradeImage()
+	if err != nil {
+		return JobActions{}, err
+	}
+	pgDataOld, err := settings.GetPGDataOld()
+	if err != nil {
+		return JobActions{}, err
+	}
+	pgDataNew, err := settings.GetPGDataNew()
+	if err != nil {
+		return JobActions{}, err
+	}
+	binEnv, err := getPGBinEnv(settings)
+	if err != nil {
+		return JobActions{}, err
+	}
+	preHooks, err := renderHooks("pre-hook", settings.PreHooks, settings)
+	if err != nil {
+		return JobActions{}, err
+	}
+	postHooks, err := renderHooks("post-hook", settings.PostHooks, settings)
+	if err != nil {
+		return JobActions{}, err
+	}
+	prepareCommand, prepareArgs := newScriptCommand(PrepareScriptFileName, preHooks)
+	postHookCommand, postHookArgs := newScriptCommand(PostHookScriptFileName, postHooks)
+
 	jobAction := JobActions{
-		Name:           "pg-upgrade",
-		Script:         upgradePrepareScript,
-		PostHookScript: postHookScript,
+		Name:                "pg-upgrade",
+		Script:              upgradePrepareScript,
+		PostHookScript:      postHookScript,
+		ImagePullSecrets:    settings.GetImagePullSecrets(),
+		Hooks:               append(preHooks, postHooks...),
+		KeepOnFailure:       settings.KeepOnFailure,
+		KeepSourcePVC:       settings.KeepSourcePVC,
+		BeforeSwitchVolumes: settings.BeforeSwitchVolumes,
+		LogDir:              settings.LogDir,
+		Logger:              settings.GetLogger(),
 		PrepareContainer: v1.Container{
 			Name:  "prepare",
-			Image: settings.GetUpgradeImage(),
+			Image: upgradeImage,
 			SecurityContext: &v1.SecurityContext{
 				RunAsNonRoot: ptrs.False(),
 			},
-			Command: []string{"/bin/sh"},
-			Args:    []string{fmt.Sprintf("/scripts/%s", PrepareScriptFileName)},
+			Command: prepareCommand,
+			Args:    prepareArgs,
+			Env:     binEnv,
 			VolumeMounts: []v1.VolumeMount{
 				{
 					Name: "old",
@@ -41,39 +77,49 @@ func createUpgradeJobActionInput(settings PGUpgradeSettings, sourceSubPath, targ
 		},
 		JobContainer: v1.Container{
 			Name:  "upgrade-postgres",
-			Image: settings.GetUpgradeImage(),
+			Image: upgradeImage,
 			SecurityContext: &v1.SecurityContext{
 				RunAsNonRoot: ptrs.False(),
 			},
-			Env: []v1.EnvVar{
+			Command: []string{"/bin/sh", fmt.Sprintf("/scripts/%s", UpgradeScriptFileName)},
+			Args:    settings.GetUpgradeCommand(),
+			Env: append([]v1.EnvVar{
 				newPodEnvVar("PGUSER", pgUser),
 				newPodEnvVar("POSTGRES_USER", pgUser),
 				newPodEnvVar("POSTGRES_INITDB_ARGS", fmt.Sprintf("-U %s %s", pgUser, extraInitDBArgs)),
-			},
+				newPodEnvVar("PGDATAOLD", pgDataOld),
+				newPodEnvVar("PGDATANEW", pgDataNew),
+			}, binEnv...),
 			VolumeMounts: []v1.VolumeMount{
 				{
 					Name: "old",
 
-					MountPath: fmt.Sprintf("/var/lib/postgresql/%s/data", settings.CurrentPostgresVersion),
+					MountPath: pgDataOld,
 					SubPath:   sourceSubPath,
 				},
 				{
 					Name:      "new",
-					MountPath: fmt.Sprintf("/var/lib/postgresql/%s/data", settings.TargetPostgresVersion),
+					MountPath: pgDataNew,
 					SubPath:   targetSubPath,
 				},
+				{
+					Name:      "scripts",
+					MountPath: "/scripts/",
+					ReadOnly:  true,
+				},
 			},
 		},
 		PostHookContainer: v1.Container{
 			Name:  "posthook",
-			Image: settings.GetUpgradeImage(),
+			Image: upgradeImage,
 			SecurityContext: &v1.SecurityContext{
 				RunAsUser:    ptrs.Int64(0),
 				RunAsGroup:   ptrs.Int64(0),
 				RunAsNonRoot: ptrs.False(),
 			},
-			Command: []string{"/bin/sh"},
-			Args:    []string{fmt.Sprintf("/scripts/%s", PostHookScriptFileName)},
+			Command: postHookCommand,
+			Args:    postHookArgs,
+			Env:     binEnv,
 			VolumeMounts: []v1.VolumeMount{
 				{
 					Name:      "new",
@@ -88,7 +134,53 @@ func createUpgradeJobActionInput(settings PGUpgradeSettings, sourceSubPath, targ
 			},
 		},
 	}
-	return jobAction
+	if settings.VerifyData {
+		jobAction.VerifyContainer = newVerifyContainer(settings, upgradeImage, binEnv, pgUser, sourceSubPath, targetSubPath)
+	}
+	return jobAction, nil
+}
+
+// getPGBinEnv returns the PGBINOLD and PGBINNEW environment variables used by the scripts and pg_upgrade.
+// They are only set when configured, otherwise the defaults of the upgrade image are used.
+func getPGBinEnv(settings PGUpgradeSettings) ([]v1.EnvVar, error) {
+	pgBinOld, err := settings.GetPGBinOld()
+	if err != nil {
+		return nil, err
+	}
+	pgBinNew, err := settings.GetPGBinNew()
+	if err != nil {
+		return nil, err
+	}
+
+	var env []v1.EnvVar
+	if pgBinOld != "" {
+		env = append(env, newPodEnvVar("PGBINOLD", pgBinOld))
+	}
+	if pgBinNew != "" {
+		env = append(env, newPodEnvVar("PGBINNEW", pgBinNew))
+	}
+	return env, nil
+}
+
+// newTaskPod returns the spec of a pod that runs to completion as part of the upgrade
+func newTaskPod(name, namespace string, imagePullSecrets []v1.LocalObjectReference, containers []v1.Container, volumes []v1.Volume) v1.Pod {
+	mismatch := v1.FSGroupChangeOnRootMismatch
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1.PodSpec{
+			SecurityContext: &v1.PodSecurityContext{
+				RunAsNonRoot:        ptrs.False(),
+				FSGroupChangePolicy: &mismatch,
+			},
+			Containers:       containers,
+			RestartPolicy:    v1.RestartPolicyNever,
+			ImagePullSecrets: imagePullSecrets,
+			Volumes:          volumes,
+		},
+	}
 }
 
 func getDiskSizeOrUsePVCDiskRequestSize(diskSize string, pvc *v1.PersistentVolumeClaim) string {
@@ -0,0 +1,292 @@
+package pgupgrade
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// Kind of the pvc targets of an upgrade plan
+const KindPersistentVolumeClaim = "PersistentVolumeClaim"
+
+// Execution modes of an upgrade plan
+const (
+	PlanModeSequential = "sequential"
+	PlanModeParallel   = "parallel"
+)
+
+// UpgradePlan lists the databases to upgrade with the settings of every upgrade, for example:
+//
+//	defaults:
+//	  targetVersion: "16"
+//	  size: 20Gi
+//	execution:
+//	  mode: parallel
+//	  concurrency: 2
+//	targets:
+//	  - kind: StatefulSet
+//	    namespace: team-a
+//	    name: orders-postgresql
+//	  - kind: PersistentVolumeClaim
+//	    namespace: team-b
+//	    name: data-legacy-postgres
+//	    currentVersion: "11"
+//	    targetPVC: data-legacy-postgres-16
+//
+// The targets are upgraded in the order they are listed.
+type UpgradePlan struct {
+	// Defaults apply to every target, the settings of a target take precedence
+	Defaults  PlanSettings  `json:"defaults,omitempty"`
+	Execution PlanExecution `json:"execution,omitempty"`
+	Targets   []PlanTarget  `json:"targets"`
+}
+
+// PlanExecution configures how the targets of a plan are upgraded
+type PlanExecution struct {
+	// Mode is sequential, the default, or parallel
+	Mode string `json:"mode,omitempty"`
+	// Concurrency is the number of databases upgraded at the same time in parallel mode, defaults to 2
+	Concurrency int `json:"concurrency,omitempty"`
+	// PerDatabaseTimeout limits the duration of the upgrade of a single database, for example 30m
+	PerDatabaseTimeout string `json:"perDatabaseTimeout,omitempty"`
+}
+
+// PlanSettings mirror the PGUpgradeSettings of an upgrade, empty settings are discovered in the same way as with the
+// flags of the upgrade commands
+type PlanSettings struct {
+	Namespace            string `json:"namespace,omitempty"`
+	CurrentVersion       string `json:"currentVersion,omitempty"`
+	TargetVersion        string `json:"targetVersion,omitempty"`
+	User                 string `json:"user,omitempty"`
+	InitDBArgs           string `json:"initdbArgs,omitempty"`
+	Size                 string `json:"size,omitempty"`
+	SubPath              string `json:"subPath,omitempty"`
+	Container            string `json:"container,omitempty"`
+	UpgradeImage         string `json:"upgradeImage,omitempty"`
+	UpgradeImageTemplate string `json:"upgradeImageTemplate,omitempty"`
+	UpgradeImageDistro   string `json:"upgradeImageDistro,omitempty"`
+	TargetImage          string `json:"targetImage,omitempty"`
+	// TargetPVC is the pvc the upgraded data is written to, required for pvc targets
+	TargetPVC string `json:"targetPVC,omitempty"`
+}
+
+// PlanTarget is a statefulset or pvc in an upgrade plan
+type PlanTarget struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	PlanSettings
+}
+
+// LoadUpgradePlan reads an upgrade plan from a YAML or JSON file and validates it
+func LoadUpgradePlan(path string) (*UpgradePlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upgrade plan %q: %w", path, err)
+	}
+	plan := &UpgradePlan{}
+	if err := yaml.UnmarshalStrict(data, plan); err != nil {
+		return nil, fmt.Errorf("failed to parse upgrade plan %q: %w", path, err)
+	}
+	if err := plan.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid upgrade plan %q:\n%w", path, err)
+	}
+	return plan, nil
+}
+
+// Validate checks the whole plan and returns all problems at once, the settings are validated after the defaults
+// have been applied
+func (p *UpgradePlan) Validate() error {
+	errs := []error{}
+	switch p.Execution.Mode {
+	case "", PlanModeSequential, PlanModeParallel:
+	default:
+		errs = append(errs, fmt.Errorf("execution.mode: unsupported mode %q, must be %s or %s", p.Execution.Mode, PlanModeSequential, PlanModeParallel))
+	}
+	if p.Execution.Concurrency < 0 {
+		errs = append(errs, fmt.Errorf("execution.concurrency: must not be negative"))
+	}
+	if p.Execution.PerDatabaseTimeout != "" {
+		if _, err := time.ParseDuration(p.Execution.PerDatabaseTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("execution.perDatabaseTimeout: %w", err))
+		}
+	}
+	if len(p.Targets) == 0 {
+		errs = append(errs, fmt.Errorf("targets: must list at least one target"))
+	}
+
+	seen := map[string]int{}
+	for i, target := range p.Targets {
+		settings := p.Defaults.merge(target.PlanSettings)
+		field := fmt.Sprintf("targets[%d]", i)
+		if target.Name != "" {
+			field = fmt.Sprintf("targets[%d] (%s %s/%s)", i, target.Kind, settings.Namespace, target.Name)
+		}
+		for _, err := range target.validate(settings) {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+
+		key := strings.Join([]string{target.Kind, settings.Namespace, target.Name}, "/")
+		if first, ok := seen[key]; ok && target.Name != "" {
+			errs = append(errs, fmt.Errorf("%s: duplicate of targets[%d]", field, first))
+		} else {
+			seen[key] = i
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the target and returns all problems at once
+func (t PlanTarget) Validate() error {
+	return errors.Join(t.validate(t.PlanSettings)...)
+}
+
+// validate checks the target with settings, the settings of the target with the defaults of the plan applied
+func (t PlanTarget) validate(settings PlanSettings) []error {
+	kind, name := t.Kind, t.Name
+	errs := []error{}
+	if name == "" {
+		errs = append(errs, fmt.Errorf("name: must not be empty"))
+	}
+	switch kind {
+	case KindStatefulSet:
+		if settings.TargetPVC != "" {
+			errs = append(errs, fmt.Errorf("targetPVC: only supported for %s targets", KindPersistentVolumeClaim))
+		}
+	case KindPersistentVolumeClaim:
+		if settings.CurrentVersion == "" {
+			errs = append(errs, fmt.Errorf("currentVersion: required for %s targets", KindPersistentVolumeClaim))
+		}
+		if settings.TargetPVC == "" {
+			errs = append(errs, fmt.Errorf("targetPVC: required for %s targets", KindPersistentVolumeClaim))
+		}
+		if settings.TargetPVC != "" && settings.TargetPVC == name {
+			errs = append(errs, fmt.Errorf("targetPVC: must differ from the source pvc"))
+		}
+		if settings.Container != "" {
+			errs = append(errs, fmt.Errorf("container: only supported for %s targets", KindStatefulSet))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("kind: unsupported kind %q, must be %s or %s", kind, KindStatefulSet, KindPersistentVolumeClaim))
+	}
+
+	if settings.TargetVersion == "" {
+		errs = append(errs, fmt.Errorf("targetVersion: must be set on the target or in the defaults"))
+	} else if !isMajorVersion(settings.TargetVersion) {
+		errs = append(errs, fmt.Errorf("targetVersion: invalid major version %q", settings.TargetVersion))
+	}
+	if settings.CurrentVersion != "" && !isMajorVersion(settings.CurrentVersion) {
+		errs = append(errs, fmt.Errorf("currentVersion: invalid major version %q", settings.CurrentVersion))
+	}
+	if settings.CurrentVersion != "" && settings.CurrentVersion == settings.TargetVersion {
+		errs = append(errs, fmt.Errorf("currentVersion: equal to the target version %q", settings.TargetVersion))
+	}
+	if settings.Size != "" {
+		if _, err := resource.ParseQuantity(settings.Size); err != nil {
+			errs = append(errs, fmt.Errorf("size: invalid quantity %q", settings.Size))
+		}
+	}
+	upgradeSettings := settings.apply(PGUpgradeSettings{})
+	if err := upgradeSettings.validateTemplates(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// isMajorVersion returns true for a postgres major version, such as 9.6 or 16
+func isMajorVersion(version string) bool {
+	parts := strings.Split(version, ".")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// merge returns the settings with the non-empty settings of override applied
+func (s PlanSettings) merge(override PlanSettings) PlanSettings {
+	set := func(value *string, override string) {
+		if override != "" {
+			*value = override
+		}
+	}
+	set(&s.Namespace, override.Namespace)
+	set(&s.CurrentVersion, override.CurrentVersion)
+	set(&s.TargetVersion, override.TargetVersion)
+	set(&s.User, override.User)
+	set(&s.InitDBArgs, override.InitDBArgs)
+	set(&s.Size, override.Size)
+	set(&s.SubPath, override.SubPath)
+	set(&s.Container, override.Container)
+	set(&s.UpgradeImage, override.UpgradeImage)
+	set(&s.UpgradeImageTemplate, override.UpgradeImageTemplate)
+	set(&s.UpgradeImageDistro, override.UpgradeImageDistro)
+	set(&s.TargetImage, override.TargetImage)
+	set(&s.TargetPVC, override.TargetPVC)
+	return s
+}
+
+// apply returns base with the non-empty plan settings applied
+func (s PlanSettings) apply(base PGUpgradeSettings) PGUpgradeSettings {
+	set := func(value *string, setting string) {
+		if setting != "" {
+			*value = setting
+		}
+	}
+	set(&base.CurrentPostgresVersion, s.CurrentVersion)
+	set(&base.TargetPostgresVersion, s.TargetVersion)
+	set(&base.InitDBUser, s.User)
+	set(&base.InitDBArgs, s.InitDBArgs)
+	set(&base.DiskSize, s.Size)
+	set(&base.SubPath, s.SubPath)
+	set(&base.PostgresContainerName, s.Container)
+	set(&base.UpgradeImage, s.UpgradeImage)
+	set(&base.UpgradeImageTemplate, s.UpgradeImageTemplate)
+	set(&base.UpgradeImageDistro, s.UpgradeImageDistro)
+	set(&base.TargetImage, s.TargetImage)
+	set(&base.TargetPVCName, s.TargetPVC)
+	return base
+}
+
+// BatchTargets returns the targets of the plan in order, with the defaults and the target settings applied to base.
+// Targets without a namespace are upgraded in namespace.
+func (p *UpgradePlan) BatchTargets(base PGUpgradeSettings, namespace string) []BatchTarget {
+	targets := make([]BatchTarget, 0, len(p.Targets))
+	for _, target := range p.Targets {
+		planSettings := p.Defaults.merge(target.PlanSettings)
+		batchTarget := BatchTarget{
+			Namespace: orDefault(planSettings.Namespace, namespace),
+			Settings:  planSettings.apply(base.clone()),
+		}
+		if target.Kind == KindPersistentVolumeClaim {
+			batchTarget.Settings.SourcePVCName = target.Name
+		} else {
+			batchTarget.StatefulSet = target.Name
+		}
+		targets = append(targets, batchTarget)
+	}
+	return targets
+}
+
+// BatchOptions returns the options of the execution of the plan
+func (p *UpgradePlan) BatchOptions() BatchOptions {
+	options := BatchOptions{Concurrency: 1}
+	if p.Execution.Mode == PlanModeParallel {
+		options.Concurrency = p.Execution.Concurrency
+		if options.Concurrency == 0 {
+			options.Concurrency = 2
+		}
+	}
+	// validated by Validate
+	options.PerDatabaseTimeout, _ = time.ParseDuration(p.Execution.PerDatabaseTimeout)
+	return options
+}
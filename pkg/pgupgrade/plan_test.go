@@ -0,0 +1,118 @@
+package pgupgrade
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestPlan(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "plan.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadUpgradePlan(t *testing.T) {
+	plan, err := LoadUpgradePlan(writeTestPlan(t, `
+defaults:
+  targetVersion: "16"
+  size: 20Gi
+execution:
+  mode: parallel
+  perDatabaseTimeout: 1h
+targets:
+  - kind: StatefulSet
+    name: orders-postgresql
+  - kind: StatefulSet
+    namespace: team-b
+    name: billing-postgresql
+    targetVersion: "17"
+    user: billing
+  - kind: PersistentVolumeClaim
+    namespace: team-c
+    name: data-legacy-postgres
+    currentVersion: "11"
+    targetPVC: data-legacy-postgres-16
+`))
+	require.NoError(t, err)
+
+	targets := plan.BatchTargets(PGUpgradeSettings{LogDir: "logs", ImagePullSecrets: []string{"registry"}}, "team-a")
+	require.Len(t, targets, 3)
+
+	assert.Equal(t, "team-a", targets[0].Namespace, "targets without a namespace use the namespace of the command")
+	assert.Equal(t, "orders-postgresql", targets[0].StatefulSet)
+	assert.Equal(t, "16", targets[0].Settings.TargetPostgresVersion)
+	assert.Equal(t, "20Gi", targets[0].Settings.DiskSize)
+	assert.Equal(t, "logs", targets[0].Settings.LogDir, "the settings of the flags apply to every target")
+
+	assert.Equal(t, "team-b", targets[1].Namespace)
+	assert.Equal(t, "17", targets[1].Settings.TargetPostgresVersion, "target settings take precedence over the defaults")
+	assert.Equal(t, "billing", targets[1].Settings.InitDBUser)
+
+	assert.Empty(t, targets[2].StatefulSet)
+	assert.Equal(t, "data-legacy-postgres", targets[2].Settings.SourcePVCName)
+	assert.Equal(t, "data-legacy-postgres-16", targets[2].Settings.TargetPVCName)
+	assert.Equal(t, "11", targets[2].Settings.CurrentPostgresVersion)
+	assert.Equal(t, "pvc/data-legacy-postgres", targets[2].name())
+
+	targets[0].Settings.ImagePullSecrets[0] = "changed"
+	assert.Equal(t, "registry", targets[1].Settings.ImagePullSecrets[0], "every target has its own copy of the settings")
+
+	options := plan.BatchOptions()
+	assert.Equal(t, 2, options.Concurrency)
+	assert.Equal(t, time.Hour, options.PerDatabaseTimeout)
+}
+
+func TestLoadUpgradePlanSequential(t *testing.T) {
+	plan, err := LoadUpgradePlan(writeTestPlan(t, `
+targets:
+  - {kind: StatefulSet, name: db, targetVersion: "16"}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, BatchOptions{Concurrency: 1}, plan.BatchOptions())
+}
+
+func TestLoadUpgradePlanReportsAllErrors(t *testing.T) {
+	_, err := LoadUpgradePlan(writeTestPlan(t, `
+execution:
+  mode: random
+targets:
+  - kind: StatefulSet
+    name: db
+  - kind: PersistentVolumeClaim
+    name: data-db
+    targetVersion: "16"
+    size: lots
+  - kind: Deployment
+    name: other
+    targetVersion: "16"
+  - kind: StatefulSet
+    name: db
+    targetVersion: sixteen
+`))
+	require.Error(t, err)
+	for _, message := range []string{
+		`execution.mode: unsupported mode "random"`,
+		"targets[0] (StatefulSet /db): targetVersion: must be set on the target or in the defaults",
+		"targets[1] (PersistentVolumeClaim /data-db): currentVersion: required for PersistentVolumeClaim targets",
+		"targets[1] (PersistentVolumeClaim /data-db): targetPVC: required for PersistentVolumeClaim targets",
+		`targets[1] (PersistentVolumeClaim /data-db): size: invalid quantity "lots"`,
+		`targets[2] (Deployment /other): kind: unsupported kind "Deployment"`,
+		`targets[3] (StatefulSet /db): targetVersion: invalid major version "sixteen"`,
+		"targets[3] (StatefulSet /db): duplicate of targets[0]",
+	} {
+		assert.Contains(t, err.Error(), message)
+	}
+}
+
+func TestLoadUpgradePlanRejectsUnknownFields(t *testing.T) {
+	_, err := LoadUpgradePlan(writeTestPlan(t, `
+targets:
+  - {kind: StatefulSet, name: db, targetVersion: "16", targetVerison: "17"}
+`))
+	assert.ErrorContains(t, err, "failed to parse upgrade plan")
+}
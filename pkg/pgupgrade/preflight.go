@@ -0,0 +1,182 @@
+package pgupgrade
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/gitops"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+// Mode is the kind of upgrade the permissions are checked for
+type Mode string
+
+const (
+	ModeStatefulSet Mode = "statefulset"
+	ModePVC         Mode = "pvc"
+	ModeCNPG        Mode = "cnpg"
+)
+
+// Permission is a verb on a resource used by an upgrade, the namespace is empty for cluster scoped resources
+type Permission struct {
+	Namespace   string
+	Group       string
+	Resource    string
+	Subresource string
+	Verb        string
+	// Reason describes what the permission is used for
+	Reason string
+	// Optional permissions are used for reporting only, such as events and annotations, the upgrade succeeds without
+	// them
+	Optional bool
+}
+
+// String returns the resource in the form of kubectl auth can-i, for example statefulsets.apps/scale
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	return resource
+}
+
+// RequiredPermissions returns the permissions an upgrade in the mode needs in namespace, for the settings of the
+// upgrade
+func RequiredPermissions(mode Mode, namespace string, settings PGUpgradeSettings) []Permission {
+	permissions := []Permission{}
+	add := func(group, resource, subresource, reason string, optional bool, verbs ...string) {
+		for _, verb := range verbs {
+			permissions = append(permissions, Permission{Namespace: namespace, Group: group, Resource: resource, Subresource: subresource, Verb: verb, Reason: reason, Optional: optional})
+		}
+	}
+	addClusterScoped := func(group, resource, reason string, verbs ...string) {
+		for _, verb := range verbs {
+			permissions = append(permissions, Permission{Group: group, Resource: resource, Verb: verb, Reason: reason})
+		}
+	}
+
+	if mode == ModeStatefulSet || mode == ModeCNPG {
+		add("apps", "statefulsets", "", "read the postgres container", false, "get")
+		add("apps", "statefulsets", "scale", "scale the statefulset down", false, "get", "update")
+		add("apps", "statefulsets", "", "annotate the upgraded statefulset", true, "patch")
+		add("", "secrets", "", "read the helm release of the statefulset", true, "list")
+	}
+	addClusterScoped("storage.k8s.io", "storageclasses", "validate the storage class", "get")
+	add("", "persistentvolumeclaims", "", "create the volume of the upgraded data and swap the volumes", false, "get", "create", "delete")
+	add("", "persistentvolumeclaims", "", "annotate the upgraded volume", true, "patch")
+	addClusterScoped("", "persistentvolumes", "retain and rebind the volumes", "get", "update")
+	add("", "secrets", "", "store the upgrade scripts", false, "get", "create", "update", "delete")
+	add("", "pods", "", "run the upgrade pods", false, "create", "get", "watch", "delete")
+	add("", "pods", "log", "follow the output of the upgrade pods", false, "get")
+	if settings.UseJobs {
+		add("batch", "jobs", "", "run the upgrade jobs", false, "create", "get", "delete")
+		add("", "pods", "", "find the pods of the upgrade jobs", false, "list")
+	}
+	for _, hook := range append(settings.PreHooks, settings.PostHooks...) {
+		if hook.Script == "" && strings.HasPrefix(hook.Source, configMapHookPrefix) {
+			add("", "configmaps", "", "load the hooks", false, "get")
+			break
+		}
+	}
+	add("", "events", "", "record the upgrade", true, "create")
+	add("", "events", "", "diagnose failed upgrade pods", true, "list")
+	if mode == ModeCNPG {
+		add("snapshot.storage.k8s.io", "volumesnapshots", "", "snapshot the upgraded volume", false, "create", "get")
+	}
+	return permissions
+}
+
+// GitOpsPermissions returns the permissions to suspend the sync of the gitops owners, checked once the owners are
+// found as most clusters run neither Argo CD nor Flux
+func GitOpsPermissions(owners []gitops.Owner) []Permission {
+	permissions := []Permission{}
+	for _, owner := range owners {
+		resource := owner.GroupResource()
+		for _, verb := range []string{"get", "patch"} {
+			permissions = append(permissions, Permission{Namespace: owner.Namespace, Group: resource.Group, Resource: resource.Resource, Verb: verb, Reason: "suspend the sync of " + owner.String()})
+		}
+	}
+	return permissions
+}
+
+// CheckPermissions reviews every permission using a SelfSubjectAccessReview and returns the denied permissions
+func CheckPermissions(ctx context.Context, k8sClient kubernetes.Interface, permissions []Permission) ([]Permission, error) {
+	missing := []Permission{}
+	for _, permission := range permissions {
+		review, err := k8sClient.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   permission.Namespace,
+					Verb:        permission.Verb,
+					Group:       permission.Group,
+					Resource:    permission.Resource,
+					Subresource: permission.Subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to review permission to %s %s: %w", permission.Verb, permission, err)
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, permission)
+		}
+	}
+	return missing, nil
+}
+
+// PrintMissingPermissions prints a table of the missing permissions
+func PrintMissingPermissions(ctx context.Context, missing []Permission) {
+	rows := make([][]string, 0, len(missing))
+	for _, permission := range missing {
+		namespace, required := permission.Namespace, "yes"
+		if namespace == "" {
+			namespace = "(cluster)"
+		}
+		if permission.Optional {
+			required = "no"
+		}
+		rows = append(rows, []string{namespace, permission.Verb, permission.String(), required, permission.Reason})
+	}
+	progress.Table(ctx, []string{"namespace", "verb", "resource", "required", "used to"}, rows)
+}
+
+// checkPermissions fails the upgrade when a permission required by the mode is missing
+func (r *PGUpgradeRunner) checkPermissions(ctx context.Context, mode Mode, additional ...Permission) error {
+	return r.reviewPermissions(ctx, append(RequiredPermissions(mode, r.namespace, r.settings), additional...))
+}
+
+// reviewPermissions fails the upgrade when a required permission is missing. The check is skipped when the
+// permissions cannot be reviewed, the upgrade then fails on the first missing permission instead.
+func (r *PGUpgradeRunner) reviewPermissions(ctx context.Context, permissions []Permission) error {
+	if !r.settings.CheckPermissions {
+		return nil
+	}
+	missing, err := CheckPermissions(ctx, r.k8sclient, permissions)
+	if err != nil {
+		r.logger.Warn("failed to check the permissions of the upgrade", "namespace", r.namespace, "error", err)
+		return nil
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	progress.Printf(ctx, "missing permissions:\n")
+	PrintMissingPermissions(ctx, missing)
+	required := 0
+	for _, permission := range missing {
+		if !permission.Optional {
+			required++
+		}
+	}
+	if required > 0 {
+		return fmt.Errorf("missing %d of the %d permissions required by the upgrade", required, len(permissions))
+	}
+	return nil
+}
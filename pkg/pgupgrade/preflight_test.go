@@ -0,0 +1,120 @@
+package pgupgrade
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/gitops"
+)
+
+// newAccessReviewClient returns a client allowing every permission except the denied ones
+func newAccessReviewClient(denied ...Permission) *fake.Clientset {
+	k8sClient := fake.NewSimpleClientset()
+	k8sClient.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = true
+		for _, permission := range denied {
+			if permission.Verb == attributes.Verb && permission.Group == attributes.Group && permission.Resource == attributes.Resource &&
+				permission.Subresource == attributes.Subresource && permission.Namespace == attributes.Namespace {
+				review.Status.Allowed = false
+			}
+		}
+		return true, review, nil
+	})
+	return k8sClient
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	has := func(permissions []Permission, verb, resource string) bool {
+		for _, permission := range permissions {
+			if permission.Verb == verb && permission.String() == resource {
+				return true
+			}
+		}
+		return false
+	}
+
+	permissions := RequiredPermissions(ModePVC, "databases", PGUpgradeSettings{})
+	assert.True(t, has(permissions, "update", "persistentvolumes"))
+	assert.True(t, has(permissions, "get", "pods/log"))
+	assert.False(t, has(permissions, "update", "statefulsets.apps/scale"))
+	assert.False(t, has(permissions, "create", "jobs.batch"))
+	assert.False(t, has(permissions, "get", "configmaps"))
+	for _, permission := range permissions {
+		if permission.Resource == "persistentvolumes" || permission.Resource == "storageclasses" {
+			assert.Empty(t, permission.Namespace, "%s is cluster scoped", permission)
+		} else {
+			assert.Equal(t, "databases", permission.Namespace)
+		}
+	}
+
+	permissions = RequiredPermissions(ModeStatefulSet, "databases", PGUpgradeSettings{
+		UseJobs:       true,
+		SuspendGitOps: true,
+		PreHooks:      []Hook{{Source: "configmap:hooks/prepare.sh"}},
+	})
+	assert.True(t, has(permissions, "update", "statefulsets.apps/scale"))
+	assert.True(t, has(permissions, "create", "jobs.batch"))
+	assert.True(t, has(permissions, "list", "pods"))
+	assert.True(t, has(permissions, "get", "configmaps"))
+	assert.False(t, has(permissions, "create", "volumesnapshots.snapshot.storage.k8s.io"))
+	assert.False(t, has(permissions, "patch", "applications.argoproj.io"), "gitops owners are only checked once found")
+
+	permissions = RequiredPermissions(ModeCNPG, "databases", PGUpgradeSettings{})
+	assert.True(t, has(permissions, "get", "statefulsets.apps"))
+	assert.True(t, has(permissions, "create", "volumesnapshots.snapshot.storage.k8s.io"))
+}
+
+func TestGitOpsPermissions(t *testing.T) {
+	permissions := GitOpsPermissions([]gitops.Owner{
+		{Kind: gitops.KindArgoCDApplication, Namespace: "argocd", Name: "orders"},
+		{Kind: gitops.KindFluxKustomization, Namespace: "databases", Name: "apps"},
+	})
+	require.Len(t, permissions, 4)
+	assert.Equal(t, Permission{Namespace: "argocd", Group: "argoproj.io", Resource: "applications", Verb: "patch", Reason: "suspend the sync of Application argocd/orders"}, permissions[1])
+	assert.Equal(t, "kustomizations.kustomize.toolkit.fluxcd.io", permissions[2].String())
+	assert.Equal(t, "databases", permissions[2].Namespace)
+}
+
+func TestCheckPermissions(t *testing.T) {
+	ctx := context.Background()
+	updateVolumes := Permission{Resource: "persistentvolumes", Verb: "update"}
+	patchClaims := Permission{Namespace: "databases", Resource: "persistentvolumeclaims", Verb: "patch"}
+
+	missing, err := CheckPermissions(ctx, newAccessReviewClient(updateVolumes), RequiredPermissions(ModePVC, "databases", PGUpgradeSettings{}))
+	require.NoError(t, err)
+	require.Len(t, missing, 1)
+	assert.Equal(t, "persistentvolumes", missing[0].String())
+	assert.False(t, missing[0].Optional)
+
+	settings := PGUpgradeSettings{CurrentPostgresVersion: "11", TargetPostgresVersion: "15", CheckPermissions: true}
+	runner, err := NewPGUpgradeRunnerWithClient("databases", newAccessReviewClient(updateVolumes, patchClaims), settings)
+	require.NoError(t, err)
+	assert.ErrorContains(t, runner.checkPermissions(ctx, ModeStatefulSet), "missing 1 of the")
+
+	runner, err = NewPGUpgradeRunnerWithClient("databases", newAccessReviewClient(patchClaims), settings)
+	require.NoError(t, err)
+	assert.NoError(t, runner.checkPermissions(ctx, ModeStatefulSet), "optional permissions do not fail the upgrade")
+
+	k8sClient := fake.NewSimpleClientset()
+	k8sClient.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("forbidden")
+	})
+	runner, err = NewPGUpgradeRunnerWithClient("databases", k8sClient, settings)
+	require.NoError(t, err)
+	assert.NoError(t, runner.checkPermissions(ctx, ModeStatefulSet), "the check is skipped when the permissions cannot be reviewed")
+
+	settings.CheckPermissions = false
+	runner, err = NewPGUpgradeRunnerWithClient("databases", newAccessReviewClient(updateVolumes), settings)
+	require.NoError(t, err)
+	assert.NoError(t, runner.checkPermissions(ctx, ModeStatefulSet))
+}
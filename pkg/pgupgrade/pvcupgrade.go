@@ -5,16 +5,28 @@ import (
 	"fmt"
 
 	"github.com/containerinfra/kube-pg-upgrade/pkg/kubevolumes"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
 )
 
-func (r *PGUpgradeRunner) RunPGUpgradeForDatabasePVC(ctx context.Context) error {
+func (r *PGUpgradeRunner) RunPGUpgradeForDatabasePVC(ctx context.Context) (err error) {
+	ctx = r.withKubeEvents(ctx, r.getPersistentVolumeClaimReference(ctx, r.settings.SourcePVCName))
+	ctx, endPhase := progress.StartPhase(ctx, progress.PhaseUpgrade, map[string]string{"namespace": r.namespace, "pvc": r.settings.SourcePVCName})
+	defer func() { endPhase(err) }()
+
+	if err := r.checkPermissions(ctx, ModePVC); err != nil {
+		return err
+	}
+	if err := r.resolveHooks(ctx); err != nil {
+		return err
+	}
+
 	pgUser := r.settings.GetInitDBUser()
 	extraInitDBArgs := r.settings.InitDBArgs
 
-	fmt.Printf("---------\n")
-	fmt.Printf("postgres user: %q\n", pgUser)
-	fmt.Printf("initdb-args: %q\n", extraInitDBArgs)
-	fmt.Printf("---------\n")
+	progress.Printf(ctx, "---------\n")
+	progress.Printf(ctx, "postgres user: %q\n", pgUser)
+	progress.Printf(ctx, "initdb-args: %q\n", extraInitDBArgs)
+	progress.Printf(ctx, "---------\n")
 
 	// TODO: figure out from statefulset
 	// by default we have to use `data` from bitnami
@@ -33,10 +45,16 @@ func (r *PGUpgradeRunner) RunPGUpgradeForDatabasePVC(ctx context.Context) error
 		return fmt.Errorf("target pvc name must not be empty")
 	}
 
+	if r.settings.KeepSourcePVC && targetPVCName == sourcePVCName {
+		return fmt.Errorf("target pvc must differ from the source pvc %q to keep the source pvc", sourcePVCName)
+	}
+
 	if r.settings.CurrentPostgresVersion == "" {
 		return fmt.Errorf("must provide current postgres version")
 	}
 
+	r.reportUpgradeVersions(ctx)
+
 	// if versions are equal, we don't have to do anything
 	if r.settings.CurrentPostgresVersion == r.settings.TargetPostgresVersion {
 		return fmt.Errorf("current postgres version is equal to target postgres version: %q", r.settings.CurrentPostgresVersion)
@@ -55,12 +73,25 @@ func (r *PGUpgradeRunner) RunPGUpgradeForDatabasePVC(ctx context.Context) error
 	if diskSize == "" {
 		return fmt.Errorf("invalid disk size: must not be empty")
 	}
+	r.logger.Debug("resolved the volumes of the upgrade", "namespace", r.namespace, "sourcePVC", sourcePVCName, "targetPVC", targetPVCName, "storageClass", storageclass, "size", diskSize)
+
+	if r.settings.CheckExtensions {
+		err = r.checkExtensionCompatibility(ctx, sourcePVCName, subpath, pgUser, nil)
+		if err != nil {
+			return err
+		}
+	}
 
-	fmt.Printf("running pg_upgrade with init args: %q\n", fmt.Sprintf("-U %s %s", pgUser, extraInitDBArgs))
-	err = RunPGDataMigration(ctx, r.k8sclient, r.namespace, sourcePVCName, targetPVCName, storageclass, diskSize, createUpgradeJobActionInput(r.settings, subpath, subpath, pgUser, extraInitDBArgs))
+	progress.Printf(ctx, "running pg_upgrade with init args: %q\n", fmt.Sprintf("-U %s %s", pgUser, extraInitDBArgs))
+	jobAction, err := createUpgradeJobActionInput(r.settings, subpath, orDefault(r.settings.TargetSubPath, subpath), pgUser, extraInitDBArgs)
+	if err != nil {
+		return err
+	}
+	result, err := RunPGDataMigration(ctx, r.k8sclient, r.newTaskRunner(), r.namespace, sourcePVCName, targetPVCName, storageclass, diskSize, jobAction)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("ran postgres upgrade succesfully\n")
+	r.recordUpgrade(ctx, result, "")
+	progress.Printf(ctx, "ran postgres upgrade succesfully\n")
 	return nil
 }
@@ -0,0 +1,185 @@
+package pgupgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/version"
+)
+
+const (
+	annotationPrefix = "kube-pg-upgrade.containerinfra.com/"
+
+	// AnnotationUpgradedFrom is the postgres version before the last upgrade
+	AnnotationUpgradedFrom = annotationPrefix + "upgraded-from"
+	// AnnotationUpgradedTo is the postgres version after the last upgrade
+	AnnotationUpgradedTo = annotationPrefix + "upgraded-to"
+	// AnnotationUpgradedAt is the time the last upgrade completed, in RFC 3339 format
+	AnnotationUpgradedAt = annotationPrefix + "upgraded-at"
+	// AnnotationToolVersion is the version of kube-pg-upgrade that ran the last upgrade
+	AnnotationToolVersion = annotationPrefix + "tool-version"
+	// AnnotationRetainedPersistentVolume is the retained volume with the data from before the last upgrade
+	AnnotationRetainedPersistentVolume = annotationPrefix + "retained-persistent-volume"
+	// AnnotationUpgradeImage is the image, including its digest, pg_upgrade ran with
+	AnnotationUpgradeImage = annotationPrefix + "upgrade-image"
+)
+
+const (
+	eventComponent = "kube-pg-upgrade"
+
+	EventReasonPhaseStarted   = "UpgradePhaseStarted"
+	EventReasonPhaseSucceeded = "UpgradePhaseSucceeded"
+	EventReasonPhaseFailed    = "UpgradePhaseFailed"
+	EventReasonUpgraded       = "Upgraded"
+
+	maxEventMessageLength = 1024
+	// eventTimeout limits how long recording an event may block the upgrade
+	eventTimeout = 5 * time.Second
+)
+
+// kubeEventReporter records the start and the end of every phase as an Event on the upgraded object,
+// all events are passed on to the next reporter
+type kubeEventReporter struct {
+	ctx       context.Context
+	k8sClient kubernetes.Interface
+	object    v1.ObjectReference
+	next      progress.Reporter
+	logger    *slog.Logger
+}
+
+// withKubeEvents returns a context that records the phases of the upgrade as Events on object
+func (r *PGUpgradeRunner) withKubeEvents(ctx context.Context, object v1.ObjectReference) context.Context {
+	return progress.WithReporter(ctx, &kubeEventReporter{
+		ctx:       ctx,
+		k8sClient: r.k8sclient,
+		object:    object,
+		next:      progress.FromContext(ctx),
+		logger:    r.logger,
+	})
+}
+
+func (r *kubeEventReporter) Report(event progress.Event) {
+	r.next.Report(event)
+
+	var eventType, reason, message string
+	switch {
+	case event.Type == progress.EventPhaseStart:
+		eventType, reason, message = v1.EventTypeNormal, EventReasonPhaseStarted, fmt.Sprintf("phase %s started", event.Phase)
+	case event.Type == progress.EventPhaseEnd && event.Error == "":
+		duration := time.Duration(event.DurationSeconds * float64(time.Second)).Round(time.Second)
+		eventType, reason, message = v1.EventTypeNormal, EventReasonPhaseSucceeded, fmt.Sprintf("phase %s completed in %s", event.Phase, duration)
+	case event.Type == progress.EventPhaseEnd:
+		eventType, reason, message = v1.EventTypeWarning, EventReasonPhaseFailed, fmt.Sprintf("phase %s failed: %s", event.Phase, event.Error)
+	default:
+		return
+	}
+
+	// the phase may have ended because its context was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.ctx), eventTimeout)
+	defer cancel()
+	if err := recordEvent(ctx, r.k8sClient, r.object, eventType, reason, message); err != nil {
+		r.logger.Warn("failed to record event", "kind", r.object.Kind, "name", r.object.Name, "reason", reason, "error", err)
+	}
+}
+
+func recordEvent(ctx context.Context, k8sClient kubernetes.Interface, object v1.ObjectReference, eventType, reason, message string) error {
+	now := metav1.Now()
+	host, _ := os.Hostname()
+	_, err := k8sClient.CoreV1().Events(object.Namespace).Create(ctx, &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// same naming as the events of client-go's event recorder
+			Name:      fmt.Sprintf("%v.%x", object.Name, now.UnixNano()),
+			Namespace: object.Namespace,
+		},
+		InvolvedObject: object,
+		Reason:         reason,
+		Message:        Truncate(message, maxEventMessageLength),
+		Type:           eventType,
+		Source:         v1.EventSource{Component: eventComponent, Host: host},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return err
+}
+
+// getStatefulSetReference returns a reference to the statefulset, including its uid when it can be found
+func (r *PGUpgradeRunner) getStatefulSetReference(ctx context.Context, name string) v1.ObjectReference {
+	object := v1.ObjectReference{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: r.namespace, Name: name}
+	if sts, err := r.k8sclient.AppsV1().StatefulSets(r.namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+		object.UID = sts.UID
+		object.ResourceVersion = sts.ResourceVersion
+	}
+	return object
+}
+
+// getPersistentVolumeClaimReference returns a reference to the pvc, including its uid when it can be found
+func (r *PGUpgradeRunner) getPersistentVolumeClaimReference(ctx context.Context, name string) v1.ObjectReference {
+	object := v1.ObjectReference{APIVersion: "v1", Kind: "PersistentVolumeClaim", Namespace: r.namespace, Name: name}
+	if pvc, err := r.k8sclient.CoreV1().PersistentVolumeClaims(r.namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+		object.UID = pvc.UID
+		object.ResourceVersion = pvc.ResourceVersion
+	}
+	return object
+}
+
+// upgradeAnnotations returns the annotations recording a successful upgrade
+func (r *PGUpgradeRunner) upgradeAnnotations(result *MigrationResult, upgradedAt time.Time) map[string]string {
+	annotations := map[string]string{
+		AnnotationUpgradedFrom:             r.settings.CurrentPostgresVersion,
+		AnnotationUpgradedTo:               r.settings.TargetPostgresVersion,
+		AnnotationUpgradedAt:               upgradedAt.UTC().Format(time.RFC3339),
+		AnnotationToolVersion:              version.Version(),
+		AnnotationRetainedPersistentVolume: result.RetainedPersistentVolume,
+		AnnotationUpgradeImage:             result.UpgradeImageID,
+	}
+	for key, value := range annotations {
+		if value == "" {
+			delete(annotations, key)
+		}
+	}
+	return annotations
+}
+
+// recordUpgrade annotates the final pvc and, when set, the statefulset with the details of the upgrade and records
+// an Upgraded event on them. The upgrade itself has already succeeded, failures are reported but not returned.
+func (r *PGUpgradeRunner) recordUpgrade(ctx context.Context, result *MigrationResult, statefulSetName string) {
+	annotations := r.upgradeAnnotations(result, time.Now())
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": annotations}})
+	if err != nil {
+		r.logger.Warn("failed to record the upgrade", "error", err)
+		return
+	}
+	message := fmt.Sprintf("upgraded from postgres %s to %s, the original data is retained in persistent volume %q",
+		r.settings.CurrentPostgresVersion, r.settings.TargetPostgresVersion, result.RetainedPersistentVolume)
+
+	objects := []v1.ObjectReference{}
+	if _, err := r.k8sclient.CoreV1().PersistentVolumeClaims(r.namespace).Patch(ctx, result.TargetPVCName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		r.logger.Warn("failed to annotate persistent volume claim", "namespace", r.namespace, "pvc", result.TargetPVCName, "error", err)
+	} else {
+		objects = append(objects, r.getPersistentVolumeClaimReference(ctx, result.TargetPVCName))
+	}
+	if statefulSetName != "" {
+		if _, err := r.k8sclient.AppsV1().StatefulSets(r.namespace).Patch(ctx, statefulSetName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			r.logger.Warn("failed to annotate statefulset", "namespace", r.namespace, "statefulset", statefulSetName, "error", err)
+		} else {
+			objects = append(objects, r.getStatefulSetReference(ctx, statefulSetName))
+		}
+	}
+
+	for _, object := range objects {
+		if err := recordEvent(ctx, r.k8sclient, object, v1.EventTypeNormal, EventReasonUpgraded, message); err != nil {
+			r.logger.Warn("failed to record event", "kind", object.Kind, "name", object.Name, "reason", EventReasonUpgraded, "error", err)
+		}
+	}
+}
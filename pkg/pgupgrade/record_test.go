@@ -0,0 +1,72 @@
+package pgupgrade
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+func TestKubeEventReporter(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	var out bytes.Buffer
+	object := v1.ObjectReference{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: "default", Name: "database-postgresql", UID: "1234"}
+	ctx := progress.WithReporter(context.Background(), &kubeEventReporter{
+		ctx:       context.Background(),
+		k8sClient: k8sClient,
+		object:    object,
+		next:      progress.NewHumanReporter(&out),
+	})
+
+	phaseCtx, endPhase := progress.StartPhase(ctx, progress.PhasePGUpgrade, nil)
+	progress.Printf(phaseCtx, "running pg_upgrade\n")
+	endPhase(errors.New("unexpected exit code: 1"))
+
+	assert.Equal(t, "running pg_upgrade\n", out.String(), "events are passed on to the next reporter")
+
+	events, err := k8sClient.CoreV1().Events("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 2)
+
+	reasons := map[string]v1.Event{}
+	for _, event := range events.Items {
+		assert.Equal(t, object, event.InvolvedObject)
+		reasons[event.Reason] = event
+	}
+	assert.Equal(t, "phase pg_upgrade started", reasons[EventReasonPhaseStarted].Message)
+	assert.Equal(t, v1.EventTypeWarning, reasons[EventReasonPhaseFailed].Type)
+	assert.Equal(t, "phase pg_upgrade failed: unexpected exit code: 1", reasons[EventReasonPhaseFailed].Message)
+}
+
+func TestUpgradeAnnotations(t *testing.T) {
+	runner := &PGUpgradeRunner{settings: PGUpgradeSettings{CurrentPostgresVersion: "11", TargetPostgresVersion: "15"}}
+	annotations := runner.upgradeAnnotations(&MigrationResult{
+		TargetPVCName:            "data-database-postgresql-0",
+		RetainedPersistentVolume: "pvc-1234",
+		UpgradeImageID:           "docker.io/tianon/postgres-upgrade@sha256:abcd",
+	}, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, "11", annotations[AnnotationUpgradedFrom])
+	assert.Equal(t, "15", annotations[AnnotationUpgradedTo])
+	assert.Equal(t, "2024-05-01T10:00:00Z", annotations[AnnotationUpgradedAt])
+	assert.Equal(t, "pvc-1234", annotations[AnnotationRetainedPersistentVolume])
+	assert.Equal(t, "docker.io/tianon/postgres-upgrade@sha256:abcd", annotations[AnnotationUpgradeImage])
+	assert.NotContains(t, annotations, AnnotationToolVersion, "the tool version is unset in tests")
+}
+
+func TestGetContainerImageID(t *testing.T) {
+	pod := &v1.Pod{Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{
+		{Name: "upgrade-postgres", ImageID: "docker-pullable://tianon/postgres-upgrade@sha256:abcd"},
+	}}}
+	assert.Equal(t, "tianon/postgres-upgrade@sha256:abcd", getContainerImageID(pod, "upgrade-postgres"))
+	assert.Empty(t, getContainerImageID(pod, "prepare"))
+}
@@ -0,0 +1,43 @@
+#!/bin/sh
+# Reports which of the extensions in CHECK_EXTENSIONS and the libraries in CHECK_LIBRARIES are available in this image.
+# Output lines are parsed by kube-pg-upgrade:
+#   CONTROL|<extension>|<default version>|<comma separated versions with install or update scripts>
+#   MISSING|<extension>
+#   LIBRARY|<library>|found or missing
+
+# the upgrade image contains both postgres versions, use the binaries of the new version
+if [ -n "${PGBINNEW}" ]; then
+    PATH="${PGBINNEW}:${PATH}"
+fi
+
+sharedir=$(pg_config --sharedir 2>/dev/null)
+pkglibdir=$(pg_config --pkglibdir 2>/dev/null)
+
+# fallback for images without pg_config, every installation ships plpgsql
+if [ -z "${sharedir}" ]; then
+    control=$(find / -xdev -path "*/extension/plpgsql.control" 2>/dev/null | head -n 1)
+    sharedir=$(dirname "$(dirname "${control}")")
+fi
+if [ -z "${pkglibdir}" ]; then
+    pkglibdir=$(dirname "$(find / -xdev -name plpgsql.so 2>/dev/null | head -n 1)")
+fi
+echo "sharedir: ${sharedir}, pkglibdir: ${pkglibdir}"
+
+for extension in ${CHECK_EXTENSIONS}; do
+    control="${sharedir}/extension/${extension}.control"
+    if [ ! -f "${control}" ]; then
+        echo "MISSING|${extension}"
+        continue
+    fi
+    default_version=$(sed -n "s/^[[:space:]]*default_version[[:space:]]*=[[:space:]]*'\(.*\)'.*/\1/p" "${control}")
+    versions=$(ls "${sharedir}/extension/" | sed -n "s/^${extension}--\(.*\)\.sql$/\1/p" | tr '\n' ',')
+    echo "CONTROL|${extension}|${default_version}|${versions}"
+done
+
+for library in ${CHECK_LIBRARIES}; do
+    if [ -f "${pkglibdir}/${library}.so" ] || [ -f "${pkglibdir}/${library}" ]; then
+        echo "LIBRARY|${library}|found"
+    else
+        echo "LIBRARY|${library}|missing"
+    fi
+done
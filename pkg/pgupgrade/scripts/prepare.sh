@@ -1,5 +1,8 @@
 #!/bin/sh
 
+# remove the data of a previous, partial upgrade attempt so pg_upgrade always starts with an empty data directory
+find /new -mindepth 1 -maxdepth 1 ! -name lost+found -exec rm -rf {} +
+
 # we require a postgresql config file to exist
 touch /old/postgresql.conf
 
@@ -34,3 +37,4 @@ su postgres -c "${PGBINOLD}/pg_ctl stop -w -D /old"
 # Show database size
 echo database size:
 df -h /old
+echo "DATA_DIRECTORY_SIZE|before|$(du -sk /old | cut -f1)"
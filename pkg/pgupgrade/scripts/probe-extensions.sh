@@ -0,0 +1,54 @@
+#!/bin/bash
+# Starts the old cluster with read-only transactions and reports the installed extensions per database
+# and the configured shared_preload_libraries. The ownership of the data directory is restored on exit,
+# the statefulset is scaled back up on the original data when the check fails.
+# Output lines are parsed by kube-pg-upgrade:
+#   PRELOAD|<library>
+#   EXTENSION|<database>|<extension>|<version>
+set -e
+
+owner=$(stat -c %u:%g /old)
+# files owned by someone else than the data directory, restored after the recursive chown
+exceptions=$(mktemp)
+find /old \( ! -uid "${owner%:*}" -o ! -gid "${owner#*:}" \) -printf '%U:%G %p\n' > "$exceptions"
+created_config=""
+started=""
+restore() {
+    if [ -n "$started" ]; then
+        su postgres -c "${PGBINOLD}/pg_ctl stop -w -D /old" || true
+    fi
+    if [ -n "$created_config" ]; then
+        rm -f /old/postgresql.conf
+    fi
+    chown -R "$owner" /old
+    while read -r file_owner file; do
+        chown -h "$file_owner" "$file"
+    done < "$exceptions"
+}
+trap restore EXIT
+
+# the old cluster may not have been shut down cleanly
+rm -f /old/postmaster.pid
+if [ ! -e /old/postgresql.conf ]; then
+    touch /old/postgresql.conf
+    created_config=true
+fi
+chown postgres /old -R
+
+echo "local all all trust" > /tmp/pg_hba.conf
+chown postgres /tmp/pg_hba.conf
+
+preload=$(su postgres -c "${PGBINOLD}/postgres -D /old -C shared_preload_libraries")
+for library in ${preload//,/ }; do
+    library=${library//\'/}
+    [ -n "$library" ] && echo "PRELOAD|${library}"
+done
+
+# start without preloading libraries, so missing libraries in the upgrade image do not prevent the probe from running
+started=true
+su postgres -c "${PGBINOLD}/pg_ctl start -w -D /old -o \"-c listen_addresses= -c unix_socket_directories=/tmp -c hba_file=/tmp/pg_hba.conf -c shared_preload_libraries= -c default_transaction_read_only=on\""
+
+psql="${PGBINOLD}/psql -h /tmp -U ${PGUSER} -At -F |"
+$psql -d template1 -c "select datname from pg_database where datallowconn" | while IFS= read -r database; do
+    $psql -d "$database" -c "select 'EXTENSION', current_database(), extname, extversion from pg_extension"
+done
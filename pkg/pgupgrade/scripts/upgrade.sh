@@ -0,0 +1,32 @@
+#!/bin/sh
+
+# Runs the upgrade command passed as arguments, the entrypoint of the upgrade image. When pg_upgrade fails, its own
+# log files are printed, they are only written to pg_upgrade_output.d in the new data directory (postgres 15 and
+# later) or the working directory (earlier versions) and would otherwise be lost with the pod.
+
+if [ "$#" -eq 0 ]; then
+	echo "missing the upgrade command"
+	exit 1
+fi
+
+"$@"
+exit_code=$?
+if [ "${exit_code}" -eq 0 ]; then
+	echo "DATA_DIRECTORY_SIZE|after|$(du -sk "${PGDATANEW}" | cut -f1)"
+	exit 0
+fi
+
+echo "pg_upgrade failed with exit code ${exit_code}"
+{
+	find "${PGDATANEW}/pg_upgrade_output.d" -type f \( -name '*.log' -o -name '*.txt' \) 2> /dev/null
+	find . -maxdepth 1 -type f \( -name 'pg_upgrade*.log' -o -name '*.txt' \) 2> /dev/null
+} | while read -r file; do
+	echo "===== BEGIN pg_upgrade output file ${file} ====="
+	cat "${file}"
+	# make sure the end marker is on its own line
+	if [ -n "$(tail -c 1 "${file}")" ]; then
+		echo
+	fi
+	echo "===== END pg_upgrade output file ${file} ====="
+done
+exit "${exit_code}"
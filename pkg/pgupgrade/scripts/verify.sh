@@ -0,0 +1,75 @@
+#!/bin/bash
+# Starts the old and the new cluster read-only, one after the other, and reports the tables with their row counts
+# and the sequence values of every database. Set VERIFY_CHECKSUMS=1 to include a checksum of every table.
+# Output lines are parsed by kube-pg-upgrade:
+#   TABLE|<old or new>|<database>|<schema.table>|<row count>|<checksum>
+#   SEQUENCE|<old or new>|<database>|<schema.sequence>|<last value>
+#   RELATIONS|<old or new>|<database>|<number of tables and sequences reported>
+set -e -o pipefail
+
+echo "local all all trust" > /tmp/pg_hba.conf
+chown postgres /tmp/pg_hba.conf
+
+report() {
+    local cluster=$1
+    local bindir=$2
+    local datadir=$3
+
+    rm -f "${datadir}/postmaster.pid"
+    su postgres -c "${bindir}/pg_ctl start -w -D ${datadir} -o \"-c listen_addresses= -c unix_socket_directories=/tmp -c hba_file=/tmp/pg_hba.conf -c default_transaction_read_only=on\""
+
+    local psql="${bindir}/psql -h /tmp -U ${PGUSER} -At -F | -v ON_ERROR_STOP=1 -v cluster=${cluster} -v checksums=${VERIFY_CHECKSUMS:-0}"
+    $psql -d template1 -c "select datname from pg_database where datallowconn order by datname" | while IFS= read -r database; do
+        $psql -d "${database}" <<'SQL'
+select 'RELATIONS', :'cluster', current_database(), count(*)
+from pg_class c
+join pg_namespace n on n.oid = c.relnamespace
+where (c.relkind = 'r'
+  and n.nspname not in ('pg_catalog', 'information_schema')
+  and n.nspname not like 'pg_toast%'
+  and n.nspname not like 'pg_temp%')
+  or c.relkind = 'S';
+select format(
+    'select %L, %L, current_database(), %L, count(*), %s from %I.%I t',
+    'TABLE', :'cluster', n.nspname || '.' || c.relname,
+    case when :checksums = 1 then format('md5(coalesce(string_agg(md5(%1$s), '''' order by md5(%1$s)), ''''))', r.expression) else '''''' end,
+    n.nspname, c.relname)
+from pg_class c
+join pg_namespace n on n.oid = c.relnamespace
+-- the text output of floats changed in postgres 12, they are hashed in their binary form which is the same in every
+-- version
+cross join lateral (
+    select format('row(%s)::text', string_agg(case
+        when a.atttypid = 'float4'::regtype then format('float4send(t.%I)', a.attname)
+        when a.atttypid = 'float8'::regtype then format('float8send(t.%I)', a.attname)
+        when a.atttypid in ('float4[]'::regtype, 'float8[]'::regtype) then format('array_send(t.%I)', a.attname)
+        else format('t.%I', a.attname)
+    end, ', ' order by a.attnum)) as expression
+    from pg_attribute a
+    where a.attrelid = c.oid and a.attnum > 0 and not a.attisdropped
+) r
+where c.relkind = 'r'
+  and n.nspname not in ('pg_catalog', 'information_schema')
+  and n.nspname not like 'pg_toast%'
+  and n.nspname not like 'pg_temp%'
+order by n.nspname, c.relname
+\gexec
+select format(
+    'select %L, %L, current_database(), %L, last_value from %I.%I',
+    'SEQUENCE', :'cluster', n.nspname || '.' || c.relname, n.nspname, c.relname)
+from pg_class c
+join pg_namespace n on n.oid = c.relnamespace
+where c.relkind = 'S'
+order by n.nspname, c.relname
+\gexec
+SQL
+    done
+
+    su postgres -c "${bindir}/pg_ctl stop -w -D ${datadir}"
+}
+
+echo "collecting statistics of the old cluster..."
+report old "${PGBINOLD}" /old
+echo "collecting statistics of the new cluster..."
+report new "${PGBINNEW}" /new
+echo "completed verification script.."
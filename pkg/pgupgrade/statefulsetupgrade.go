@@ -2,7 +2,10 @@ package pgupgrade
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 	"strings"
 
 	v1 "k8s.io/api/core/v1"
@@ -10,77 +13,107 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/containerinfra/kube-pg-upgrade/pkg/helmrelease"
 	"github.com/containerinfra/kube-pg-upgrade/pkg/kubeclient"
 	"github.com/containerinfra/kube-pg-upgrade/pkg/kubescaler"
 	"github.com/containerinfra/kube-pg-upgrade/pkg/kubevolumes"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/podrunner"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
 )
 
 type PGUpgradeRunner struct {
 	namespace string
-	k8sclient *kubernetes.Clientset
+	k8sclient kubernetes.Interface
 	settings  PGUpgradeSettings
+	logger    *slog.Logger
 }
 
-func NewPGUpgradeRunner(namespace string, settings PGUpgradeSettings) (*PGUpgradeRunner, error) {
+// NewPGUpgradeRunner returns a runner with clients created from clientOptions, the default options are used when
+// nil. The namespace defaults to the namespace of the kubecontext.
+func NewPGUpgradeRunner(namespace string, clientOptions *kubeclient.Options, settings PGUpgradeSettings) (*PGUpgradeRunner, error) {
 	if err := settings.Validate(); err != nil {
 		return nil, err
 	}
 
-	kubeconfig, err := kubeclient.GetClientConfig()
+	k8sclient, contextNamespace, err := clientOptions.NewClientAndNamespace()
 	if err != nil {
 		return nil, err
 	}
-	clientconfig, err := kubeconfig.ClientConfig()
-	if err != nil {
-		return nil, err
-	}
-	k8sclient, err := kubeclient.GetClientWithConfig(clientconfig)
-	if err != nil {
-		return nil, err
-	}
-	if namespace == "" {
-		contextNamespace, _, err := kubeconfig.Namespace()
+	if settings.DynamicClient == nil {
+		settings.DynamicClient, err = clientOptions.NewDynamicClient()
 		if err != nil {
 			return nil, err
 		}
+	}
+	if namespace == "" {
 		namespace = contextNamespace
 	}
+	return NewPGUpgradeRunnerWithClient(namespace, k8sclient, settings)
+}
+
+// NewPGUpgradeRunnerWithClient returns a runner using k8sclient, namespace must be set
+func NewPGUpgradeRunnerWithClient(namespace string, k8sclient kubernetes.Interface, settings PGUpgradeSettings) (*PGUpgradeRunner, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace must not be empty")
+	}
 
 	return &PGUpgradeRunner{
 		namespace: namespace,
 		k8sclient: k8sclient,
 		settings:  settings,
+		logger:    settings.GetLogger(),
 	}, nil
 }
 
-func (r *PGUpgradeRunner) RunPGUpgradeForDatabaseStatefulSet(ctx context.Context, targetStatefulSetName string) error {
-	var err error
-	var postgresContainer *v1.Container
+// newTaskRunner returns the runner used for the pods of the upgrade
+func (r *PGUpgradeRunner) newTaskRunner() podrunner.Runner {
+	options := podrunner.Options{
+		LogDir:        r.settings.LogDir,
+		StuckTimeout:  r.settings.StuckTimeout,
+		KeepOnFailure: r.settings.KeepOnFailure,
+		Logger:        r.logger,
+	}
+	if r.settings.UseJobs {
+		return podrunner.NewJobRunnerWithOptions(r.k8sclient, r.settings.JobOptions, options)
+	}
+	return podrunner.NewPodRunnerWithOptions(r.k8sclient, options)
+}
 
-	if r.settings.PostgresContainerName == "" {
-		postgresContainer, err = autodiscoverPostgresContainer(ctx, r.k8sclient, r.namespace, targetStatefulSetName)
-		if err != nil {
-			return fmt.Errorf("failed to auto discover postgres container: %w", err)
-		}
-	} else {
-		postgresContainer, err = getContainerInStatefulset(ctx, r.k8sclient, r.namespace, targetStatefulSetName, r.settings.PostgresContainerName)
-		if err != nil {
-			return fmt.Errorf("failed to find postgres container by name: %w", err)
-		}
+func (r *PGUpgradeRunner) RunPGUpgradeForDatabaseStatefulSet(ctx context.Context, targetStatefulSetName string) (err error) {
+	ctx = r.withKubeEvents(ctx, r.getStatefulSetReference(ctx, targetStatefulSetName))
+	ctx, endPhase := progress.StartPhase(ctx, progress.PhaseUpgrade, map[string]string{"namespace": r.namespace, "statefulset": targetStatefulSetName})
+	defer func() { endPhase(err) }()
+
+	if err := r.checkPermissions(ctx, ModeStatefulSet); err != nil {
+		return err
+	}
+	if err := r.resolveHooks(ctx); err != nil {
+		return err
 	}
 
-	pgUser := strings.TrimSpace(getEnvValue(postgresContainer.Env, "POSTGRES_USER", "POSTGRES_INITSCRIPTS_USERNAME"))
-	if pgUser == "" { // default fallback
-		pgUser = r.settings.GetInitDBUser()
+	postgresContainer, err := r.findPostgresContainer(ctx, targetStatefulSetName)
+	if err != nil {
+		return err
 	}
 
+	imagePullSecrets, err := getImagePullSecretsOfStatefulSet(ctx, r.k8sclient, r.namespace, targetStatefulSetName)
+	if err != nil {
+		return err
+	}
+	r.settings.ImagePullSecrets = mergeImagePullSecrets(r.settings.ImagePullSecrets, imagePullSecrets)
+
+	pgUser := r.getInitDBUser(postgresContainer)
+
 	discoveredInitDBArguments := getEnvValue(postgresContainer.Env, "POSTGRES_INITDB_ARGS")
 
 	extraInitDBArgs := strings.TrimSpace(fmt.Sprintf("%s %s", r.settings.InitDBArgs, discoveredInitDBArguments))
-	fmt.Printf("---------\n")
-	fmt.Printf("postgres user: %q\n", pgUser)
-	fmt.Printf("initdb-args: %q\n", extraInitDBArgs)
-	fmt.Printf("---------\n")
+	progress.Printf(ctx, "---------\n")
+	progress.Printf(ctx, "postgres user: %q\n", pgUser)
+	progress.Printf(ctx, "initdb-args: %q\n", extraInitDBArgs)
+	progress.Printf(ctx, "---------\n")
 
 	// TODO: figure out from statefulset
 	// by default we have to use `data` from bitnami
@@ -106,20 +139,31 @@ func (r *PGUpgradeRunner) RunPGUpgradeForDatabaseStatefulSet(ctx context.Context
 		targetPVCName = r.settings.TargetPVCName
 	}
 
+	if r.settings.KeepSourcePVC && targetPVCName == sourcePVCName {
+		return fmt.Errorf("target pvc must differ from the source pvc %q to keep the source pvc", sourcePVCName)
+	}
+
 	if r.settings.CurrentPostgresVersion == "" {
 		// attempt discovery from container image
 		currentPostgresMajorVersion, err := AutoDiscoverPostgresVersionFromImage(postgresContainer.Image)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("auto discovered current postgres version: %s\n", currentPostgresMajorVersion)
+		progress.Printf(ctx, "auto discovered current postgres version: %s\n", currentPostgresMajorVersion)
 		r.settings.CurrentPostgresVersion = currentPostgresMajorVersion
 	}
 
+	r.reportUpgradeVersions(ctx)
+
 	// if versions are equal, we don't have to do anything
 	if r.settings.CurrentPostgresVersion == r.settings.TargetPostgresVersion {
 		return fmt.Errorf("current postgres version is equal to target postgres version: %q", r.settings.CurrentPostgresVersion)
 	}
+	// the statefulset keeps running on the original data when the source pvc is kept
+	var helmRelease *helmrelease.Release
+	if !r.settings.KeepSourcePVC {
+		helmRelease = r.findHelmRelease(ctx, targetStatefulSetName)
+	}
 
 	sourcePVC, err := kubevolumes.GetPersistentVolumeClaimAndWaitForVolume(ctx, r.k8sclient, r.namespace, sourcePVCName)
 	if err != nil {
@@ -134,25 +178,92 @@ func (r *PGUpgradeRunner) RunPGUpgradeForDatabaseStatefulSet(ctx context.Context
 	if diskSize == "" {
 		return fmt.Errorf("invalid disk size: must not be empty")
 	}
+	r.logger.Debug("resolved the volumes of the upgrade", "namespace", r.namespace, "sourcePVC", sourcePVCName, "targetPVC", targetPVCName, "storageClass", storageclass, "size", diskSize)
 
-	fmt.Printf("scaling down postgres statefulset...\n")
 	scaler := kubescaler.NewKubeScalerWithClient(r.namespace, r.k8sclient)
-	err = scaler.ScaleStatefulSet(ctx, targetStatefulSetName, 0)
+	replicas, err := scaler.GetStatefulSetReplicas(ctx, targetStatefulSetName)
+	if err != nil {
+		return err
+	}
+
+	resumeGitOps, err := r.suspendGitOps(ctx, targetStatefulSetName)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		err = errors.Join(err, resumeGitOps(context.WithoutCancel(ctx)))
+	}()
+
+	scaleCtx, endScalePhase := progress.StartPhase(ctx, progress.PhaseScaleDown, map[string]string{"statefulset": targetStatefulSetName})
+	progress.Printf(scaleCtx, "scaling down postgres statefulset...\n")
+	err = scaler.ScaleStatefulSet(scaleCtx, targetStatefulSetName, 0)
+	endScalePhase(err)
+	if err != nil {
+		return err
+	}
+
+	if r.settings.CheckExtensions {
+		sharedPreloadLibraries := splitList(getEnvValue(postgresContainer.Env, "POSTGRESQL_SHARED_PRELOAD_LIBRARIES"))
+		err = r.checkExtensionCompatibility(ctx, sourcePVCName, subpath, pgUser, sharedPreloadLibraries)
+		if err != nil {
+			// nothing has been migrated yet, bring the database back up
+			progress.Printf(ctx, "scaling postgres statefulset back up to %d replicas...\n", replicas)
+			if scaleErr := scaler.ScaleStatefulSet(context.WithoutCancel(ctx), targetStatefulSetName, replicas); scaleErr != nil {
+				return fmt.Errorf("%w (failed to scale statefulset back up: %v)", err, scaleErr)
+			}
+			return err
+		}
+	}
 
-	fmt.Printf("running pg_upgrade with init args: %q\n", fmt.Sprintf("-U %s %s", pgUser, extraInitDBArgs))
+	progress.Printf(ctx, "running pg_upgrade with init args: %q\n", fmt.Sprintf("-U %s %s", pgUser, extraInitDBArgs))
 
-	err = RunPGDataMigration(ctx, r.k8sclient, r.namespace, sourcePVCName, targetPVCName, storageclass, diskSize, createUpgradeJobActionInput(r.settings, subpath, subpath, pgUser, extraInitDBArgs))
+	jobAction, err := createUpgradeJobActionInput(r.settings, subpath, orDefault(r.settings.TargetSubPath, subpath), pgUser, extraInitDBArgs)
+	if err != nil {
+		return err
+	}
+	result, err := RunPGDataMigration(ctx, r.k8sclient, r.newTaskRunner(), r.namespace, sourcePVCName, targetPVCName, storageclass, diskSize, jobAction)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("ran postgres upgrade succesfully\n")
+	if r.settings.KeepSourcePVC {
+		r.recordUpgrade(ctx, result, "")
+	} else {
+		r.recordUpgrade(ctx, result, targetStatefulSetName)
+	}
+	progress.Printf(ctx, "ran postgres upgrade succesfully\n")
+	if helmRelease != nil {
+		r.reportHelmValues(ctx, helmRelease, targetStatefulSetName, result.TargetPVCName)
+	}
 	return nil
 }
 
-func getContainerInStatefulset(ctx context.Context, k8sclient *kubernetes.Clientset, targetNamespace, targetName, containerName string) (*v1.Container, error) {
+// findPostgresContainer returns the PostgresContainerName container of the statefulset, or the auto discovered
+// postgres container
+func (r *PGUpgradeRunner) findPostgresContainer(ctx context.Context, statefulSetName string) (*v1.Container, error) {
+	if r.settings.PostgresContainerName == "" {
+		container, err := autodiscoverPostgresContainer(ctx, r.k8sclient, r.namespace, statefulSetName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to auto discover postgres container: %w", err)
+		}
+		return container, nil
+	}
+	container, err := getContainerInStatefulset(ctx, r.k8sclient, r.namespace, statefulSetName, r.settings.PostgresContainerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find postgres container by name: %w", err)
+	}
+	return container, nil
+}
+
+// getInitDBUser returns the user the database was initialized with, from the environment of the container
+func (r *PGUpgradeRunner) getInitDBUser(container *v1.Container) string {
+	pgUser := strings.TrimSpace(getEnvValue(container.Env, "POSTGRES_USER", "POSTGRES_INITSCRIPTS_USERNAME"))
+	if pgUser == "" { // default fallback
+		pgUser = r.settings.GetInitDBUser()
+	}
+	return pgUser
+}
+
+func getContainerInStatefulset(ctx context.Context, k8sclient kubernetes.Interface, targetNamespace, targetName, containerName string) (*v1.Container, error) {
 	sts, err := k8sclient.AppsV1().StatefulSets(targetNamespace).Get(ctx, targetName, metav1.GetOptions{})
 	if err != nil {
 		if kubeerrors.IsNotFound(err) {
@@ -175,7 +286,7 @@ func getContainerInStatefulset(ctx context.Context, k8sclient *kubernetes.Client
 		if container.Name == containerName {
 			c := container
 			postgresContainer = &c
-			fmt.Printf("found container: %q\n", container.Name)
+			progress.Printf(ctx, "found container: %q\n", container.Name)
 		}
 	}
 
@@ -185,7 +296,29 @@ func getContainerInStatefulset(ctx context.Context, k8sclient *kubernetes.Client
 	return postgresContainer, nil
 }
 
-func autodiscoverPostgresContainer(ctx context.Context, k8sclient *kubernetes.Clientset, targetNamespace string, targetName string) (*v1.Container, error) {
+func getImagePullSecretsOfStatefulSet(ctx context.Context, k8sclient kubernetes.Interface, targetNamespace, targetName string) ([]string, error) {
+	sts, err := k8sclient.AppsV1().StatefulSets(targetNamespace).Get(ctx, targetName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get statefulset %q: %w", targetName, err)
+	}
+	secrets := make([]string, 0, len(sts.Spec.Template.Spec.ImagePullSecrets))
+	for _, secret := range sts.Spec.Template.Spec.ImagePullSecrets {
+		secrets = append(secrets, secret.Name)
+	}
+	return secrets, nil
+}
+
+// mergeImagePullSecrets appends the secrets that are not yet present, keeping the original order
+func mergeImagePullSecrets(secrets []string, additional []string) []string {
+	for _, secret := range additional {
+		if secret != "" && !slices.Contains(secrets, secret) {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+func autodiscoverPostgresContainer(ctx context.Context, k8sclient kubernetes.Interface, targetNamespace string, targetName string) (*v1.Container, error) {
 	sts, err := k8sclient.AppsV1().StatefulSets(targetNamespace).Get(ctx, targetName, metav1.GetOptions{})
 	if err != nil {
 		if kubeerrors.IsNotFound(err) {
@@ -203,18 +336,11 @@ func autodiscoverPostgresContainer(ctx context.Context, k8sclient *kubernetes.Cl
 		return nil, fmt.Errorf("could not find postgres container")
 	}
 
-	var postgresContainer *v1.Container
-	for _, container := range containers {
-		if isImage(container.Image, "/bitnami/postgresql:", "docker.io/bitnami/postgresql:", "/postgres:", "/postgresql:") {
-			c := container
-			postgresContainer = &c
-			fmt.Printf("found container: %q\n", container.Name)
-		}
-	}
-
+	postgresContainer := findPostgresContainer(containers)
 	if postgresContainer == nil {
 		return nil, fmt.Errorf("could not find postgres container")
 	}
+	progress.Printf(ctx, "found container: %q\n", postgresContainer.Name)
 	return postgresContainer, nil
 }
 
@@ -236,11 +362,9 @@ func getEnvValue(envs []v1.EnvVar, names ...string) string {
 	return ""
 }
 
-func isImage(containerImage string, images ...string) bool {
-	for _, image := range images {
-		if strings.Contains(containerImage, image) {
-			return true
-		}
-	}
-	return false
+// splitList splits a comma or whitespace separated list, such as shared_preload_libraries
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\'' || r == '"'
+	})
 }
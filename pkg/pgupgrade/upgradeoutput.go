@@ -0,0 +1,143 @@
+package pgupgrade
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+//go:embed scripts/upgrade.sh
+var upgradeScript string
+
+const (
+	UpgradeScriptFileName = "upgrade.sh"
+
+	upgradeOutputBeginMarker = "===== BEGIN pg_upgrade output file "
+	upgradeOutputEndMarker   = "===== END pg_upgrade output file "
+	upgradeOutputMarkerEnd   = " ====="
+
+	// maxUpgradeErrorLines limits the number of lines of the pg_upgrade output files added to the error
+	maxUpgradeErrorLines = 10
+)
+
+// lines of the pg_upgrade output files containing any of these are reported in the error of a failed upgrade
+var upgradeErrorKeywords = []string{"fatal", "error", "panic", "could not", "failed", "failure", "incompatible"}
+
+// pgUpgradeOutputFile is a log file written by pg_upgrade, as printed by upgrade.sh
+type pgUpgradeOutputFile struct {
+	Path  string
+	Lines []string
+}
+
+// pgUpgradeOutputCollector collects the pg_upgrade output files from the logs of the upgrade pod
+type pgUpgradeOutputCollector struct {
+	files   []*pgUpgradeOutputFile
+	current *pgUpgradeOutputFile
+}
+
+func newPGUpgradeOutputCollector() *pgUpgradeOutputCollector {
+	return &pgUpgradeOutputCollector{}
+}
+
+func (c *pgUpgradeOutputCollector) handleLine(line string) {
+	if path, ok := parseUpgradeOutputMarker(line, upgradeOutputBeginMarker); ok {
+		c.current = &pgUpgradeOutputFile{Path: path}
+		c.files = append(c.files, c.current)
+		return
+	}
+	if _, ok := parseUpgradeOutputMarker(line, upgradeOutputEndMarker); ok {
+		c.current = nil
+		return
+	}
+	if c.current != nil {
+		c.current.Lines = append(c.current.Lines, line)
+	}
+}
+
+func parseUpgradeOutputMarker(line, marker string) (string, bool) {
+	path, found := strings.CutPrefix(strings.TrimSpace(line), marker)
+	if !found {
+		return "", false
+	}
+	path, found = strings.CutSuffix(path, upgradeOutputMarkerEnd)
+	return path, found
+}
+
+// save writes the collected files to <dir>/<podName>-pg_upgrade_output, returns the directory
+func (c *pgUpgradeOutputCollector) save(dir, podName string) (string, error) {
+	outputDir := filepath.Join(dir, podName+"-pg_upgrade_output")
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory %q: %w", outputDir, err)
+	}
+	names := map[string]int{}
+	for _, file := range c.files {
+		name := filepath.Base(file.Path)
+		// files with the same name can be written by multiple pg_upgrade runs
+		if count := names[name]; count > 0 {
+			name = fmt.Sprintf("%d-%s", count, name)
+		}
+		names[filepath.Base(file.Path)]++
+
+		content := strings.Join(file.Lines, "\n") + "\n"
+		if err := os.WriteFile(filepath.Join(outputDir, name), []byte(content), 0o644); err != nil {
+			return "", fmt.Errorf("failed to write pg_upgrade output file %q: %w", name, err)
+		}
+	}
+	return outputDir, nil
+}
+
+// errorLines returns the lines of the output files that describe why pg_upgrade failed, prefixed with the file name
+func (c *pgUpgradeOutputCollector) errorLines() []string {
+	lines := []string{}
+	seen := map[string]bool{}
+	for _, file := range c.files {
+		for _, line := range file.Lines {
+			line = strings.TrimSpace(line)
+			if line == "" || seen[line] || !containsUpgradeErrorKeyword(line) {
+				continue
+			}
+			seen[line] = true
+			lines = append(lines, fmt.Sprintf("%s: %s", filepath.Base(file.Path), line))
+		}
+	}
+	if len(lines) > maxUpgradeErrorLines {
+		lines = lines[len(lines)-maxUpgradeErrorLines:]
+	}
+	return lines
+}
+
+func containsUpgradeErrorKeyword(line string) bool {
+	lower := strings.ToLower(line)
+	for _, keyword := range upgradeErrorKeywords {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapError saves the collected output files to logDir, when set, and adds the key error lines to err
+func (c *pgUpgradeOutputCollector) wrapError(ctx context.Context, logger *slog.Logger, err error, logDir, podName string) error {
+	if len(c.files) == 0 {
+		return err
+	}
+	if logDir != "" {
+		outputDir, saveErr := c.save(logDir, podName)
+		if saveErr != nil {
+			logger.Warn("failed to save the pg_upgrade output files", "pod", podName, "error", saveErr)
+		} else {
+			progress.Printf(ctx, "saved the pg_upgrade output files to %q\n", outputDir)
+		}
+	}
+	lines := c.errorLines()
+	if len(lines) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w\npg_upgrade reported:\n  %s", err, strings.Join(lines, "\n  "))
+}
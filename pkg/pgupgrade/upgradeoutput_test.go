@@ -0,0 +1,65 @@
+package pgupgrade
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPGUpgradeOutputCollector(t *testing.T) {
+	collector := newPGUpgradeOutputCollector()
+	for _, line := range []string{
+		"Performing Consistency Checks",
+		"Checking for presence of required libraries                fatal",
+		"pg_upgrade failed with exit code 1",
+		"===== BEGIN pg_upgrade output file /var/lib/postgresql/16/data/pg_upgrade_output.d/20240101T100000.000/loadable_libraries.txt =====",
+		"could not load library \"$libdir/postgis-3\": ERROR:  could not access file \"$libdir/postgis-3\": No such file or directory",
+		"In database: app",
+		"===== END pg_upgrade output file /var/lib/postgresql/16/data/pg_upgrade_output.d/20240101T100000.000/loadable_libraries.txt =====",
+		"===== BEGIN pg_upgrade output file /var/lib/postgresql/16/data/pg_upgrade_output.d/20240101T100000.000/log/pg_upgrade_server.log =====",
+		"2024-01-01 10:00:00.000 UTC [42] LOG:  database system is ready to accept connections",
+		"",
+		"===== END pg_upgrade output file /var/lib/postgresql/16/data/pg_upgrade_output.d/20240101T100000.000/log/pg_upgrade_server.log =====",
+	} {
+		collector.handleLine(line)
+	}
+
+	require.Len(t, collector.files, 2)
+	assert.Equal(t, []string{
+		"loadable_libraries.txt: could not load library \"$libdir/postgis-3\": ERROR:  could not access file \"$libdir/postgis-3\": No such file or directory",
+	}, collector.errorLines())
+
+	logDir := t.TempDir()
+	err := collector.wrapError(context.Background(), slog.Default(), errors.New("unexpected exit code: 1"), logDir, "pg-upgrade-data-0")
+	assert.ErrorContains(t, err, "unexpected exit code: 1\npg_upgrade reported:\n  loadable_libraries.txt: could not load library")
+
+	content, readErr := os.ReadFile(filepath.Join(logDir, "pg-upgrade-data-0-pg_upgrade_output", "loadable_libraries.txt"))
+	require.NoError(t, readErr)
+	assert.Equal(t, "could not load library \"$libdir/postgis-3\": ERROR:  could not access file \"$libdir/postgis-3\": No such file or directory\nIn database: app\n", string(content))
+	assert.FileExists(t, filepath.Join(logDir, "pg-upgrade-data-0-pg_upgrade_output", "pg_upgrade_server.log"))
+}
+
+func TestPGUpgradeOutputCollectorWithoutOutputFiles(t *testing.T) {
+	collector := newPGUpgradeOutputCollector()
+	collector.handleLine("error: something went wrong")
+
+	original := errors.New("unexpected exit code: 1")
+	assert.Equal(t, original, collector.wrapError(context.Background(), slog.Default(), original, t.TempDir(), "pg-upgrade-data-0"))
+}
+
+func TestUpgradeCommand(t *testing.T) {
+	jobAction, err := createUpgradeJobActionInput(PGUpgradeSettings{CurrentPostgresVersion: "11", TargetPostgresVersion: "15"}, "data", "data", "postgres", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/bin/sh", "/scripts/upgrade.sh"}, jobAction.JobContainer.Command)
+	assert.Equal(t, []string{"docker-upgrade", "pg_upgrade"}, jobAction.JobContainer.Args, "the entrypoint of the image runs initdb")
+
+	jobAction, err = createUpgradeJobActionInput(PGUpgradeSettings{CurrentPostgresVersion: "11", TargetPostgresVersion: "15", UpgradeCommand: []string{"/usr/local/bin/entrypoint.sh", "pg_upgrade"}}, "data", "data", "postgres", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/usr/local/bin/entrypoint.sh", "pg_upgrade"}, jobAction.JobContainer.Args)
+}
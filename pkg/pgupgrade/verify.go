@@ -0,0 +1,226 @@
+package pgupgrade
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+	"github.com/containerinfra/kube-pg-upgrade/pkg/ptrs"
+)
+
+//go:embed scripts/verify.sh
+var verifyScript string
+
+const (
+	VerifyScriptFileName = "verify.sh"
+	// verifyCompletedMarker is printed by verify.sh once the statistics of both clusters are reported
+	verifyCompletedMarker = "completed verification script.."
+)
+
+const (
+	VerificationStatusOK       = "ok"
+	VerificationStatusMismatch = "mismatch"
+	VerificationStatusMissing  = "missing"
+)
+
+// VerificationResult compares a table or sequence between the old and the new cluster
+type VerificationResult struct {
+	Database string
+	Kind     string
+	Name     string
+	Old      string
+	New      string
+	Status   string
+}
+
+type verificationKey struct {
+	Kind     string
+	Database string
+	Name     string
+}
+
+func newVerifyContainer(settings PGUpgradeSettings, image string, binEnv []v1.EnvVar, pgUser, sourceSubPath, targetSubPath string) *v1.Container {
+	checksums := "0"
+	if settings.VerifyChecksums {
+		checksums = "1"
+	}
+	return &v1.Container{
+		Name:  "verify",
+		Image: image,
+		SecurityContext: &v1.SecurityContext{
+			RunAsUser:    ptrs.Int64(0),
+			RunAsGroup:   ptrs.Int64(0),
+			RunAsNonRoot: ptrs.False(),
+		},
+		Command: []string{"/bin/bash"},
+		Args:    []string{fmt.Sprintf("/scripts/%s", VerifyScriptFileName)},
+		Env: append([]v1.EnvVar{
+			newPodEnvVar("PGUSER", pgUser),
+			newPodEnvVar("VERIFY_CHECKSUMS", checksums),
+		}, binEnv...),
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      "old",
+				MountPath: "/old",
+				SubPath:   sourceSubPath,
+			},
+			{
+				Name:      "new",
+				MountPath: "/new",
+				SubPath:   targetSubPath,
+			},
+			{
+				Name:      "scripts",
+				MountPath: "/scripts/",
+				ReadOnly:  true,
+			},
+		},
+	}
+}
+
+// parseVerificationOutput returns the statistics of the old and the new cluster reported by verify.sh
+func parseVerificationOutput(lines []string) (map[verificationKey]string, map[verificationKey]string) {
+	clusters := map[string]map[verificationKey]string{
+		"old": {},
+		"new": {},
+	}
+	for _, line := range lines {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		var key verificationKey
+		var value string
+		switch {
+		case fields[0] == "TABLE" && len(fields) == 6:
+			key = verificationKey{Kind: "table", Database: fields[2], Name: fields[3]}
+			value = fields[4]
+			if fields[5] != "" {
+				value = fmt.Sprintf("%s rows, md5 %s", fields[4], fields[5])
+			}
+		case fields[0] == "SEQUENCE" && len(fields) == 5:
+			key = verificationKey{Kind: "sequence", Database: fields[2], Name: fields[3]}
+			value = fields[4]
+		default:
+			continue
+		}
+		if cluster, ok := clusters[fields[1]]; ok {
+			cluster[key] = value
+		}
+	}
+	return clusters["old"], clusters["new"]
+}
+
+// checkVerificationOutput returns an error when the output of verify.sh is incomplete, for example when the script
+// did not finish or log lines were lost, so missing statistics are never mistaken for a successful verification
+func checkVerificationOutput(lines []string, oldCluster, newCluster map[verificationKey]string) error {
+	completed := false
+	reported := map[string]int{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == verifyCompletedMarker {
+			completed = true
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if fields[0] != "RELATIONS" || len(fields) != 4 {
+			continue
+		}
+		count, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return fmt.Errorf("data verification failed: invalid number of relations %q", fields[3])
+		}
+		reported[fields[1]] += count
+	}
+	if !completed {
+		return fmt.Errorf("data verification failed: the verification script did not complete")
+	}
+	for _, cluster := range []struct {
+		name       string
+		statistics map[verificationKey]string
+	}{{"old", oldCluster}, {"new", newCluster}} {
+		expected, ok := reported[cluster.name]
+		if !ok {
+			return fmt.Errorf("data verification failed: no statistics of the %s cluster were reported", cluster.name)
+		}
+		if len(cluster.statistics) < expected {
+			return fmt.Errorf("data verification failed: received the statistics of %d of the %d tables and sequences of the %s cluster", len(cluster.statistics), expected, cluster.name)
+		}
+	}
+	return nil
+}
+
+func compareVerification(oldCluster, newCluster map[verificationKey]string) []VerificationResult {
+	keys := make([]verificationKey, 0, len(oldCluster))
+	for key := range oldCluster {
+		keys = append(keys, key)
+	}
+	for key := range newCluster {
+		if _, ok := oldCluster[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Database != keys[j].Database {
+			return keys[i].Database < keys[j].Database
+		}
+		if keys[i].Kind != keys[j].Kind {
+			return keys[i].Kind > keys[j].Kind
+		}
+		return keys[i].Name < keys[j].Name
+	})
+
+	results := make([]VerificationResult, 0, len(keys))
+	for _, key := range keys {
+		oldValue, inOld := oldCluster[key]
+		newValue, inNew := newCluster[key]
+		status := VerificationStatusOK
+		switch {
+		case !inOld || !inNew:
+			status = VerificationStatusMissing
+		case oldValue != newValue:
+			status = VerificationStatusMismatch
+		}
+		results = append(results, VerificationResult{
+			Database: key.Database,
+			Kind:     key.Kind,
+			Name:     key.Name,
+			Old:      oldValue,
+			New:      newValue,
+			Status:   status,
+		})
+	}
+	return results
+}
+
+// verifyUpgradedData compares the output of the verification pod and returns an error on any difference
+func verifyUpgradedData(ctx context.Context, lines []string) error {
+	oldCluster, newCluster := parseVerificationOutput(lines)
+	if err := checkVerificationOutput(lines, oldCluster, newCluster); err != nil {
+		return err
+	}
+	if len(oldCluster) == 0 && len(newCluster) == 0 {
+		progress.Printf(ctx, "[verify] no tables or sequences found to compare\n")
+		return nil
+	}
+
+	results := compareVerification(oldCluster, newCluster)
+	body := make([][]string, 0, len(results))
+	failures := 0
+	for _, result := range results {
+		body = append(body, []string{result.Database, result.Kind, result.Name, result.Old, result.New, result.Status})
+		if result.Status != VerificationStatusOK {
+			failures++
+		}
+	}
+	progress.Table(ctx, []string{"database", "type", "name", "old", "new", "status"}, body)
+
+	if failures > 0 {
+		return fmt.Errorf("data verification failed: %d of %d tables and sequences differ between the old and the new cluster", failures, len(results))
+	}
+	progress.Printf(ctx, "[verify] %d tables and sequences are identical in the old and the new cluster\n", len(results))
+	return nil
+}
@@ -0,0 +1,211 @@
+package podrunner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+const (
+	// DefaultStuckTimeout is how long a pod may be unschedulable or wait for its volumes before the task fails
+	DefaultStuckTimeout = 5 * time.Minute
+
+	maxRelatedEvents = 10
+)
+
+// waiting reasons after which a container will never start without intervention
+var fatalWaitingReasons = map[string]string{
+	"ImagePullBackOff":           "the image could not be pulled, check the image name, the registry and the image pull secrets",
+	"InvalidImageName":           "the image name is invalid",
+	"ErrImageNeverPull":          "the image is not present on the node and the pull policy is Never",
+	"CreateContainerConfigError": "the container configuration is invalid, for example a referenced secret or configmap is missing",
+}
+
+// waiting reasons that are usually transient, they fail the task once the pod has been stuck for the stuck timeout
+var transientWaitingReasons = map[string]string{
+	"ErrImagePull":         "the image could not be pulled, check the image name, the registry and the image pull secrets",
+	"CreateContainerError": "the container could not be created",
+}
+
+// event reasons that indicate the volumes of the pod can not be attached or mounted
+var volumeEventReasons = map[string]string{
+	"FailedAttachVolume": "a volume could not be attached, it may still be attached to another node (Multi-Attach error)",
+	"FailedMount":        "a volume could not be mounted",
+}
+
+// PodFailedError is returned when a task pod has failed or is stuck
+type PodFailedError struct {
+	Pod     string
+	Reason  string
+	Message string
+	// Stuck is true when the pod did not fail, but will not make any progress without intervention
+	Stuck bool
+	// Events are the recent events of the pod and its persistent volume claims
+	Events []string
+}
+
+func (e *PodFailedError) Error() string {
+	var sb strings.Builder
+	if e.Stuck {
+		fmt.Fprintf(&sb, "pod %q is stuck: %s: %s", e.Pod, e.Reason, e.Message)
+	} else {
+		fmt.Fprintf(&sb, "pod %q failed: %s: %s", e.Pod, e.Reason, e.Message)
+	}
+	if len(e.Events) > 0 {
+		sb.WriteString("\nrecent events:")
+		for _, event := range e.Events {
+			sb.WriteString("\n  ")
+			sb.WriteString(event)
+		}
+	}
+	return sb.String()
+}
+
+// checkPod returns a PodFailedError when the pod has failed or is stuck, nil otherwise
+func (c *Client) checkPod(ctx context.Context, pod *v1.Pod) error {
+	stuckTimeout := c.options.StuckTimeout
+	if stuckTimeout == 0 {
+		stuckTimeout = DefaultStuckTimeout
+	}
+
+	var events []v1.Event
+	if pod.Status.Phase == v1.PodPending {
+		events = c.getRelatedEvents(ctx, pod)
+	}
+
+	failure := diagnosePod(pod, events, time.Since(pod.CreationTimestamp.Time) > stuckTimeout)
+	if failure == nil {
+		return nil
+	}
+	if events == nil {
+		events = c.getRelatedEvents(ctx, pod)
+	}
+	failure.Events = formatEvents(events)
+	return failure
+}
+
+// diagnosePod classifies why a pod failed or is stuck. Transient problems, such as an unschedulable pod that may
+// fit once the cluster autoscaler added a node, are only reported once the pod has been pending for too long.
+func diagnosePod(pod *v1.Pod, events []v1.Event, pendingTooLong bool) *PodFailedError {
+	if pod.Status.Phase == v1.PodFailed && pod.Status.Reason != "" {
+		return &PodFailedError{Pod: pod.Name, Reason: pod.Status.Reason, Message: pod.Status.Message}
+	}
+
+	for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+			return &PodFailedError{Pod: pod.Name, Reason: terminated.Reason, Message: describeTermination(status.Name, terminated)}
+		}
+		if waiting := status.State.Waiting; waiting != nil {
+			if hint, ok := fatalWaitingReasons[waiting.Reason]; ok {
+				return &PodFailedError{Pod: pod.Name, Reason: waiting.Reason, Message: describeWaiting(status, hint), Stuck: true}
+			}
+			if hint, ok := transientWaitingReasons[waiting.Reason]; ok && pendingTooLong {
+				return &PodFailedError{Pod: pod.Name, Reason: waiting.Reason, Message: describeWaiting(status, hint), Stuck: true}
+			}
+		}
+	}
+
+	if pod.Status.Phase != v1.PodPending || !pendingTooLong {
+		return nil
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse && condition.Reason == v1.PodReasonUnschedulable {
+			return &PodFailedError{Pod: pod.Name, Reason: condition.Reason, Message: condition.Message, Stuck: true}
+		}
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		if hint, ok := volumeEventReasons[event.Reason]; ok && event.Type == v1.EventTypeWarning {
+			return &PodFailedError{Pod: pod.Name, Reason: event.Reason, Message: fmt.Sprintf("%s: %s", hint, event.Message), Stuck: true}
+		}
+		if event.InvolvedObject.Kind == "PersistentVolumeClaim" && event.Type == v1.EventTypeWarning {
+			return &PodFailedError{Pod: pod.Name, Reason: event.Reason, Message: fmt.Sprintf("persistent volume claim %q is not bound: %s", event.InvolvedObject.Name, event.Message), Stuck: true}
+		}
+	}
+	return &PodFailedError{Pod: pod.Name, Reason: "Pending", Message: "the pod has been pending for too long", Stuck: true}
+}
+
+func describeTermination(containerName string, terminated *v1.ContainerStateTerminated) string {
+	switch terminated.Reason {
+	case "OOMKilled":
+		return fmt.Sprintf("container %q ran out of memory and was killed (exit code %d), increase its memory limit", containerName, terminated.ExitCode)
+	case "":
+		return fmt.Sprintf("container %q exited with code %d", containerName, terminated.ExitCode)
+	}
+	message := fmt.Sprintf("container %q exited with code %d (%s)", containerName, terminated.ExitCode, terminated.Reason)
+	if terminated.Message != "" {
+		message += ": " + strings.TrimSpace(terminated.Message)
+	}
+	return message
+}
+
+func describeWaiting(status v1.ContainerStatus, hint string) string {
+	message := fmt.Sprintf("container %q with image %q: %s", status.Name, status.Image, hint)
+	if status.State.Waiting.Message != "" {
+		message += ": " + status.State.Waiting.Message
+	}
+	return message
+}
+
+// getRelatedEvents returns the events of the pod and the persistent volume claims it mounts, oldest first
+func (c *Client) getRelatedEvents(ctx context.Context, pod *v1.Pod) []v1.Event {
+	objects := map[string]string{pod.Name: "Pod"}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			objects[volume.PersistentVolumeClaim.ClaimName] = "PersistentVolumeClaim"
+		}
+	}
+
+	events := []v1.Event{}
+	for name, kind := range objects {
+		selector := fields.Set{"involvedObject.kind": kind, "involvedObject.name": name}.AsSelector().String()
+		list, err := c.k8sClient.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+		if err != nil {
+			c.logger.Warn("failed to list events", "namespace", pod.Namespace, "kind", kind, "name", name, "error", err)
+			continue
+		}
+		for _, event := range list.Items {
+			if event.InvolvedObject.Kind != kind || event.InvolvedObject.Name != name {
+				continue
+			}
+			// ignore events of earlier pods with the same name
+			if kind == "Pod" && event.InvolvedObject.UID != "" && pod.UID != "" && event.InvolvedObject.UID != pod.UID {
+				continue
+			}
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+	return events
+}
+
+func formatEvents(events []v1.Event) []string {
+	if len(events) > maxRelatedEvents {
+		events = events[len(events)-maxRelatedEvents:]
+	}
+	formatted := make([]string, 0, len(events))
+	for _, event := range events {
+		formatted = append(formatted, fmt.Sprintf("%s %s/%s %s: %s", event.Type, event.InvolvedObject.Kind, event.InvolvedObject.Name, event.Reason, strings.TrimSpace(event.Message)))
+	}
+	return formatted
+}
+
+func eventTime(event v1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,170 @@
+package podrunner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDiagnosePod(t *testing.T) {
+	waiting := func(reason string) v1.PodStatus {
+		return v1.PodStatus{
+			Phase: v1.PodPending,
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:  "upgrade-postgres",
+				Image: "tianon/postgres-upgrade:11-to-15",
+				State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}},
+			}},
+		}
+	}
+	unschedulable := v1.PodStatus{
+		Phase: v1.PodPending,
+		Conditions: []v1.PodCondition{{
+			Type:    v1.PodScheduled,
+			Status:  v1.ConditionFalse,
+			Reason:  v1.PodReasonUnschedulable,
+			Message: "0/3 nodes are available: 3 Insufficient memory.",
+		}},
+	}
+	multiAttach := []v1.Event{{
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "upgrade"},
+		Type:           v1.EventTypeWarning,
+		Reason:         "FailedAttachVolume",
+		Message:        "Multi-Attach error for volume \"pvc-1\" Volume is already exclusively attached to one node",
+	}}
+
+	tests := []struct {
+		name           string
+		status         v1.PodStatus
+		events         []v1.Event
+		pendingTooLong bool
+		wantReason     string
+		wantStuck      bool
+	}{
+		{name: "image pull back-off fails fast", status: waiting("ImagePullBackOff"), wantReason: "ImagePullBackOff", wantStuck: true},
+		{name: "invalid image name fails fast", status: waiting("InvalidImageName"), wantReason: "InvalidImageName", wantStuck: true},
+		{name: "missing secret fails fast", status: waiting("CreateContainerConfigError"), wantReason: "CreateContainerConfigError", wantStuck: true},
+		{name: "image pull error within grace period", status: waiting("ErrImagePull")},
+		{name: "image pull error after grace period", status: waiting("ErrImagePull"), pendingTooLong: true, wantReason: "ErrImagePull", wantStuck: true},
+		{name: "container creating", status: waiting("ContainerCreating")},
+		{name: "unschedulable within grace period", status: unschedulable},
+		{name: "unschedulable after grace period", status: unschedulable, pendingTooLong: true, wantReason: v1.PodReasonUnschedulable, wantStuck: true},
+		{name: "multi-attach after grace period", status: waiting("ContainerCreating"), events: multiAttach, pendingTooLong: true, wantReason: "FailedAttachVolume", wantStuck: true},
+		{
+			name: "oom killed",
+			status: v1.PodStatus{
+				Phase: v1.PodFailed,
+				ContainerStatuses: []v1.ContainerStatus{{
+					Name:  "upgrade-postgres",
+					State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+				}},
+			},
+			wantReason: "OOMKilled",
+		},
+		{
+			name: "failed init container",
+			status: v1.PodStatus{
+				Phase: v1.PodFailed,
+				InitContainerStatuses: []v1.ContainerStatus{{
+					Name:  "prepare",
+					State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}},
+				}},
+			},
+			wantReason: "Error",
+		},
+		{name: "evicted", status: v1.PodStatus{Phase: v1.PodFailed, Reason: "Evicted", Message: "The node was low on resource: memory."}, wantReason: "Evicted"},
+		{name: "running", status: v1.PodStatus{Phase: v1.PodRunning}, pendingTooLong: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "upgrade"}, Status: tt.status}
+			failure := diagnosePod(pod, tt.events, tt.pendingTooLong)
+			if tt.wantReason == "" {
+				assert.Nil(t, failure)
+				return
+			}
+			require.NotNil(t, failure)
+			assert.Equal(t, tt.wantReason, failure.Reason)
+			assert.Equal(t, tt.wantStuck, failure.Stuck)
+		})
+	}
+}
+
+func TestWaitForPodToStartReportsEvents(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "upgrade", Namespace: "default", CreationTimestamp: metav1.NewTime(time.Now())},
+			Spec: v1.PodSpec{
+				Volumes: []v1.Volume{{
+					Name:         "old",
+					VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data-postgresql-0"}},
+				}},
+			},
+			Status: v1.PodStatus{
+				Phase: v1.PodPending,
+				ContainerStatuses: []v1.ContainerStatus{{
+					Name:  "upgrade-postgres",
+					Image: "registry.example.com/postgres-upgrade:11-to-15",
+					State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"}},
+				}},
+			},
+		},
+		&v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "upgrade.1", Namespace: "default"},
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "upgrade", Namespace: "default"},
+			Type:           v1.EventTypeWarning,
+			Reason:         "Failed",
+			Message:        "Failed to pull image: unauthorized",
+		},
+		&v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "data-postgresql-0.1", Namespace: "default"},
+			InvolvedObject: v1.ObjectReference{Kind: "PersistentVolumeClaim", Name: "data-postgresql-0", Namespace: "default"},
+			Type:           v1.EventTypeNormal,
+			Reason:         "ProvisioningSucceeded",
+			Message:        "Successfully provisioned volume",
+		},
+		&v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "other.1", Namespace: "default"},
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "other", Namespace: "default"},
+			Type:           v1.EventTypeWarning,
+			Reason:         "BackOff",
+		},
+	)
+
+	err := NewPodRunner(k8sClient).waitForPodToStart(context.Background(), "default", "upgrade")
+	require.Error(t, err)
+
+	var failure *PodFailedError
+	require.True(t, errors.As(err, &failure))
+	assert.Equal(t, "ImagePullBackOff", failure.Reason)
+	assert.True(t, failure.Stuck)
+	assert.ElementsMatch(t, []string{
+		"Warning Pod/upgrade Failed: Failed to pull image: unauthorized",
+		"Normal PersistentVolumeClaim/data-postgresql-0 ProvisioningSucceeded: Successfully provisioned volume",
+	}, failure.Events)
+	assert.Contains(t, err.Error(), "registry.example.com/postgres-upgrade:11-to-15")
+}
+
+func TestGetRelatedEventsLogsFailures(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	k8sClient.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	var logs bytes.Buffer
+	runner := NewPodRunnerWithOptions(k8sClient, Options{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+
+	events := runner.getRelatedEvents(context.Background(), &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "upgrade", Namespace: "default"}})
+	assert.Empty(t, events)
+	assert.Contains(t, logs.String(), `level=WARN msg="failed to list events" namespace=default kind=Pod name=upgrade error=forbidden`)
+}
@@ -0,0 +1,269 @@
+package podrunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientset "k8s.io/client-go/kubernetes"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+const (
+	// specHashAnnotation is used to decide if an existing job runs the same task and can be adopted
+	specHashAnnotation = "kube-pg-upgrade.containerinfra.com/spec-hash"
+)
+
+// JobOptions configures the batch/v1 Job created for every task
+type JobOptions struct {
+	// BackoffLimit is the number of retries before the job is marked as failed
+	BackoffLimit int32
+	// ActiveDeadlineSeconds limits the duration of the job including retries, optional
+	ActiveDeadlineSeconds *int64
+	// TTLSecondsAfterFinished removes the job once it has finished, optional
+	TTLSecondsAfterFinished *int32
+}
+
+// JobClient runs tasks as batch/v1 Jobs, so a task survives node drains and evictions
+// and a record of the run is kept in the cluster.
+type JobClient struct {
+	k8sClient clientset.Interface
+	pods      *Client
+	options   JobOptions
+}
+
+func NewJobRunner(clusterK8sClient clientset.Interface, options JobOptions) *JobClient {
+	return NewJobRunnerWithOptions(clusterK8sClient, options, Options{})
+}
+
+func NewJobRunnerWithOptions(clusterK8sClient clientset.Interface, options JobOptions, podOptions Options) *JobClient {
+	return &JobClient{
+		k8sClient: clusterK8sClient,
+		pods:      NewPodRunnerWithOptions(clusterK8sClient, podOptions),
+		options:   options,
+	}
+}
+
+// RunPod runs the task pod as a job and waits for the job to complete. A running job with the same spec is adopted
+// instead of recreated, a finished job is replaced.
+func (c *JobClient) RunPod(ctx context.Context, namespace, name string, taskPod v1.Pod, opts ...RunOption) error {
+	options := newRunOptions(opts)
+	jobsApi := c.k8sClient.BatchV1().Jobs(namespace)
+
+	specHash, err := hashPodSpec(taskPod.Spec)
+	if err != nil {
+		return err
+	}
+
+	job, err := jobsApi.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case kubeerrors.IsNotFound(err):
+		job = nil
+	case err != nil:
+		return fmt.Errorf("failed to get job %q: %w", name, err)
+	case job.Annotations[specHashAnnotation] != specHash:
+		c.pods.logger.Info("replacing job, it was created with a different spec", "namespace", namespace, "job", name)
+		if err := c.deleteJob(ctx, namespace, name); err != nil {
+			return err
+		}
+		job = nil
+	case isJobFinished(job, batchv1.JobComplete) || isJobFinished(job, batchv1.JobFailed):
+		// the spec of a task is the same on every run of an upgrade, a finished job may belong to an earlier run whose
+		// data has since been removed, so the task always runs again
+		c.pods.logger.Info("replacing finished job", "namespace", namespace, "job", name)
+		if err := c.deleteJob(ctx, namespace, name); err != nil {
+			return err
+		}
+		job = nil
+	default:
+		c.pods.logger.Info("adopting running job", "namespace", namespace, "job", name)
+	}
+
+	if job == nil {
+		job, err = jobsApi.Create(ctx, c.newJob(namespace, name, specHash, taskPod), metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to create job %q: %w", name, err)
+		}
+	}
+
+	err = c.waitForJobToComplete(ctx, job, options)
+	if err != nil {
+		if c.pods.options.KeepOnFailure {
+			printKeptJobCommands(ctx, namespace, name)
+		}
+		return err
+	}
+	c.pods.logger.Info("task job has completed", "namespace", namespace, "job", name)
+	return nil
+}
+
+// printKeptJobCommands prints the kubectl commands to inspect and remove a failed job. Failed jobs are only replaced
+// by the next run, or removed once their time to live has passed.
+func printKeptJobCommands(ctx context.Context, namespace, name string) {
+	progress.Printf(ctx, "keeping failed job %q for debugging, inspect it using:\n", name)
+	progress.Printf(ctx, "  kubectl -n %s describe job %s\n", namespace, name)
+	progress.Printf(ctx, "  kubectl -n %s logs job/%s --all-containers\n", namespace, name)
+	progress.Printf(ctx, "remove it once done using:\n")
+	progress.Printf(ctx, "  kubectl -n %s delete job %s\n", namespace, name)
+}
+
+func (c *JobClient) newJob(namespace, name, specHash string, taskPod v1.Pod) *batchv1.Job {
+	backoffLimit := c.options.BackoffLimit
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      taskPod.Labels,
+			Annotations: map[string]string{specHashAnnotation: specHash},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   c.options.ActiveDeadlineSeconds,
+			TTLSecondsAfterFinished: c.options.TTLSecondsAfterFinished,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      taskPod.Labels,
+					Annotations: taskPod.Annotations,
+				},
+				Spec: taskPod.Spec,
+			},
+		},
+	}
+}
+
+// waitForJobToComplete follows the pods of the job one after the other, until the job has either completed or failed
+func (c *JobClient) waitForJobToComplete(ctx context.Context, job *batchv1.Job, options *runOptions) error {
+	jobsApi := c.k8sClient.BatchV1().Jobs(job.Namespace)
+	followedPods := sets.New[string]()
+
+	for {
+		current, err := jobsApi.Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get job %q: %w", job.Name, err)
+		}
+
+		pod, err := c.getLatestJobPod(ctx, current)
+		if err != nil {
+			return err
+		}
+		if pod != nil && !followedPods.Has(pod.Name) {
+			followedPods.Insert(pod.Name)
+			if err := c.followJobPod(ctx, pod.Namespace, pod.Name, options); err != nil {
+				var failure *PodFailedError
+				if ctx.Err() != nil || (errors.As(err, &failure) && failure.Stuck) {
+					// retries of the job would get stuck in the same way
+					return err
+				}
+				c.pods.logger.Warn("job pod did not complete successfully", "namespace", pod.Namespace, "pod", pod.Name, "error", err)
+			}
+			continue
+		}
+
+		if isJobFinished(current, batchv1.JobComplete) {
+			return nil
+		}
+		if isJobFinished(current, batchv1.JobFailed) {
+			return fmt.Errorf("job %q failed: %s", job.Name, getJobConditionMessage(current, batchv1.JobFailed))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			continue
+		}
+	}
+}
+
+func (c *JobClient) followJobPod(ctx context.Context, namespace, podName string, options *runOptions) error {
+	if err := c.pods.waitForPodToStart(ctx, namespace, podName); err != nil {
+		return err
+	}
+	if err := c.pods.tailPodLogs(ctx, namespace, podName, options.logHandlers); err != nil {
+		return err
+	}
+	completedPod, err := c.pods.waitForPodToComplete(ctx, namespace, podName)
+	if err != nil {
+		return err
+	}
+	options.handleCompletedPod(completedPod)
+	return nil
+}
+
+func (c *JobClient) getLatestJobPod(ctx context.Context, job *batchv1.Job) (*v1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of job %q: %w", job.Name, err)
+	}
+	pods, err := c.k8sClient.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods of job %q: %w", job.Name, err)
+	}
+	if len(pods.Items) == 0 {
+		return nil, nil
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].CreationTimestamp.After(pods.Items[j].CreationTimestamp.Time)
+	})
+	return &pods.Items[0], nil
+}
+
+func (c *JobClient) deleteJob(ctx context.Context, namespace, name string) error {
+	foreground := metav1.DeletePropagationForeground
+	err := c.k8sClient.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &foreground})
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete job %q: %w", name, err)
+	}
+	for {
+		_, err := c.k8sClient.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if kubeerrors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get job %q: %w", name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(1 * time.Second):
+			continue
+		}
+	}
+}
+
+func isJobFinished(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func getJobConditionMessage(job *batchv1.Job, conditionType batchv1.JobConditionType) string {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType {
+			return fmt.Sprintf("%s: %s", condition.Reason, condition.Message)
+		}
+	}
+	return ""
+}
+
+func hashPodSpec(spec v1.PodSpec) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash pod spec: %w", err)
+	}
+	hash := fnv.New32a()
+	hash.Write(data)
+	return fmt.Sprintf("%x", hash.Sum32()), nil
+}
@@ -0,0 +1,144 @@
+package podrunner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestTaskPod() v1.Pod {
+	return v1.Pod{
+		Spec: v1.PodSpec{
+			RestartPolicy: v1.RestartPolicyNever,
+			Containers:    []v1.Container{{Name: "task", Image: "busybox"}},
+		},
+	}
+}
+
+var testJobSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": "upgrade"}}
+
+func newTestJob(specHash string, conditions ...batchv1.JobCondition) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "upgrade",
+			Namespace:   "default",
+			Annotations: map[string]string{specHashAnnotation: specHash},
+		},
+		Spec:   batchv1.JobSpec{Selector: testJobSelector},
+		Status: batchv1.JobStatus{Conditions: conditions},
+	}
+}
+
+func newTestJobPod(spec v1.PodSpec) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "upgrade-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{"job-name": "upgrade"},
+		},
+		Spec: spec,
+		Status: v1.PodStatus{
+			Phase: v1.PodSucceeded,
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:  "task",
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0}},
+			}},
+		},
+	}
+}
+
+var jobComplete = batchv1.JobCondition{Type: batchv1.JobComplete, Status: v1.ConditionTrue}
+
+// completeCreatedJobs completes every job as soon as it is created and returns the number of created jobs
+func completeCreatedJobs(k8sClient *fake.Clientset) *int {
+	created := 0
+	k8sClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Spec.Selector = testJobSelector
+		job.Status.Conditions = []batchv1.JobCondition{jobComplete}
+		created++
+		return false, nil, nil
+	})
+	return &created
+}
+
+func countJobActions(k8sClient *fake.Clientset, verb string) int {
+	count := 0
+	for _, action := range k8sClient.Actions() {
+		if action.GetVerb() == verb && action.GetResource().Resource == "jobs" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestJobRunnerAdoptsRunningJob(t *testing.T) {
+	taskPod := newTestTaskPod()
+	specHash, err := hashPodSpec(taskPod.Spec)
+	require.NoError(t, err)
+	k8sClient := fake.NewSimpleClientset(newTestJob(specHash), newTestJobPod(taskPod.Spec))
+
+	// the job completes after it was adopted
+	gets := 0
+	k8sClient.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets < 2 {
+			return false, nil, nil
+		}
+		return true, newTestJob(specHash, jobComplete), nil
+	})
+
+	var lines []string
+	err = NewJobRunner(k8sClient, JobOptions{BackoffLimit: 1}).RunPod(context.TODO(), "default", "upgrade", taskPod, WithLogHandler(func(line string) {
+		lines = append(lines, line)
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fake logs"}, lines, "the logs of the adopted job should be followed")
+	assert.Zero(t, countJobActions(k8sClient, "delete"), "a running job with the same spec should be adopted")
+	assert.Zero(t, countJobActions(k8sClient, "create"))
+}
+
+func TestJobRunnerReplacesJobs(t *testing.T) {
+	taskPod := newTestTaskPod()
+	specHash, err := hashPodSpec(taskPod.Spec)
+	require.NoError(t, err)
+
+	tests := map[string]*batchv1.Job{
+		"completed":    newTestJob(specHash, jobComplete),
+		"failed":       newTestJob(specHash, batchv1.JobCondition{Type: batchv1.JobFailed, Status: v1.ConditionTrue}),
+		"spec changed": newTestJob("other"),
+	}
+	for name, job := range tests {
+		t.Run(name, func(t *testing.T) {
+			k8sClient := fake.NewSimpleClientset(job, newTestJobPod(taskPod.Spec))
+			created := completeCreatedJobs(k8sClient)
+
+			err := NewJobRunner(k8sClient, JobOptions{BackoffLimit: 1}).RunPod(context.TODO(), "default", "upgrade", taskPod)
+			require.NoError(t, err)
+			assert.Equal(t, 1, countJobActions(k8sClient, "delete"))
+			assert.Equal(t, 1, *created, "the job should be recreated so the task runs again")
+
+			recreated, err := k8sClient.BatchV1().Jobs("default").Get(context.TODO(), "upgrade", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, specHash, recreated.Annotations[specHashAnnotation])
+		})
+	}
+}
+
+func TestJobRunnerNewJob(t *testing.T) {
+	runner := NewJobRunner(fake.NewSimpleClientset(), JobOptions{BackoffLimit: 3})
+	job := runner.newJob("default", "upgrade", "1234", newTestTaskPod())
+
+	assert.Equal(t, int32(3), *job.Spec.BackoffLimit)
+	assert.Equal(t, "1234", job.Annotations[specHashAnnotation])
+	assert.Equal(t, v1.RestartPolicyNever, job.Spec.Template.Spec.RestartPolicy)
+	assert.Nil(t, job.Spec.TTLSecondsAfterFinished)
+}
@@ -0,0 +1,242 @@
+package podrunner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
+)
+
+// logTailer streams the logs of a single container, resuming from the last received line when the connection drops
+type logTailer struct {
+	client        *Client
+	namespace     string
+	podName       string
+	containerName string
+	prefix        string
+	logFile       io.Writer
+	logHandlers   []func(line string)
+
+	// lastTimestamp is the timestamp of the last received line, and linesAtLastTimestamp the number of received lines
+	// with exactly that timestamp
+	lastTimestamp        time.Time
+	linesAtLastTimestamp int
+	// replaying is set while the lines already received before a reconnect are sent again, replayedAtLastTimestamp
+	// counts the replayed lines with the last timestamp
+	replaying               bool
+	replayedAtLastTimestamp int
+}
+
+// tailPodLogs streams the logs of the init containers and the containers of the pod, in order, until they have terminated
+func (c *Client) tailPodLogs(ctx context.Context, namespace, podName string, logHandlers []func(line string)) error {
+	pod, err := c.k8sClient.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	var logFile io.Writer
+	if c.options.LogDir != "" {
+		file, err := c.openLogFile(podName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		logFile = file
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		tailer := c.newLogTailer(namespace, podName, container.Name, fmt.Sprintf("%s/%s", podName, container.Name), logFile, logHandlers)
+		if err := tailer.tail(ctx); err != nil {
+			return err
+		}
+	}
+	for _, container := range pod.Spec.Containers {
+		prefix := podName
+		if len(pod.Spec.Containers) > 1 {
+			prefix = fmt.Sprintf("%s/%s", podName, container.Name)
+		}
+		tailer := c.newLogTailer(namespace, podName, container.Name, prefix, logFile, logHandlers)
+		if err := tailer.tail(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) openLogFile(podName string) (*os.File, error) {
+	if err := os.MkdirAll(c.options.LogDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory %q: %w", c.options.LogDir, err)
+	}
+	path := filepath.Join(c.options.LogDir, podName+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file %q: %w", path, err)
+	}
+	return file, nil
+}
+
+func (c *Client) newLogTailer(namespace, podName, containerName, prefix string, logFile io.Writer, logHandlers []func(line string)) *logTailer {
+	return &logTailer{
+		client:        c,
+		namespace:     namespace,
+		podName:       podName,
+		containerName: containerName,
+		prefix:        prefix,
+		logFile:       logFile,
+		logHandlers:   logHandlers,
+	}
+}
+
+// tail follows the logs until the container has terminated. When the stream ends while the container is still running,
+// the stream is reopened from the timestamp of the last received line.
+func (t *logTailer) tail(ctx context.Context) error {
+	for {
+		state, err := t.containerState(ctx)
+		if err != nil {
+			return err
+		}
+
+		if state.Terminated != nil {
+			// read whatever was logged since the last received line, without following
+			if err := t.stream(ctx, false); err != nil && ctx.Err() == nil {
+				t.client.logger.Warn("failed to read the remaining logs", "namespace", t.namespace, "pod", t.podName, "container", t.containerName, "error", err)
+			}
+			return ctx.Err()
+		}
+
+		err = t.stream(ctx, true)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			t.client.logger.Warn("log stream was interrupted, reconnecting", "namespace", t.namespace, "pod", t.podName, "container", t.containerName, "error", err)
+		}
+
+		// avoid reconnecting in a tight loop
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(1 * time.Second):
+			continue
+		}
+	}
+}
+
+// containerState waits, using the watch of the pod, until the container is running or has terminated and returns its
+// state. A container that will never start, because the pod has already finished, is reported as terminated.
+func (t *logTailer) containerState(ctx context.Context) (v1.ContainerState, error) {
+	var state v1.ContainerState
+	err := t.client.waitForPod(ctx, t.namespace, t.podName, func(pod *v1.Pod) (bool, error) {
+		for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			if status.Name == t.containerName && (status.State.Running != nil || status.State.Terminated != nil) {
+				state = status.State
+				return true, nil
+			}
+		}
+		if isPodFinished(pod) {
+			state = v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}}
+			return true, nil
+		}
+		// a container that will never start would otherwise be waited on forever
+		var failure *PodFailedError
+		if err := t.client.checkPod(ctx, pod); errors.As(err, &failure) && failure.Stuck {
+			return false, err
+		}
+		return false, nil
+	})
+	return state, err
+}
+
+func (t *logTailer) stream(ctx context.Context, follow bool) error {
+	options := &v1.PodLogOptions{
+		Container:  t.containerName,
+		Follow:     follow,
+		Timestamps: true,
+	}
+	if !t.lastTimestamp.IsZero() {
+		// SinceTime has a precision of seconds, already received lines are skipped in handleLine
+		options.SinceTime = &metav1.Time{Time: t.lastTimestamp}
+	}
+
+	podLogs, err := t.client.k8sClient.CoreV1().Pods(t.namespace).GetLogs(t.podName, options).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer podLogs.Close()
+	t.resume()
+
+	reader := bufio.NewScanner(podLogs)
+	reader.Buffer(make([]byte, 64*1024), 1024*1024)
+	for reader.Scan() {
+		t.handleLine(ctx, reader.Text())
+	}
+	return reader.Err()
+}
+
+// resume starts skipping the lines received before, a new stream repeats them from the start of the second of the
+// last received line
+func (t *logTailer) resume() {
+	t.replaying = !t.lastTimestamp.IsZero()
+	t.replayedAtLastTimestamp = 0
+}
+
+func (t *logTailer) handleLine(ctx context.Context, rawLine string) {
+	timestamp, line, ok := parseTimestampedLine(rawLine)
+	if ok {
+		if t.replaying {
+			switch {
+			case timestamp.Before(t.lastTimestamp):
+				return
+			case timestamp.Equal(t.lastTimestamp) && t.replayedAtLastTimestamp < t.linesAtLastTimestamp:
+				// lines may share a timestamp, only as many as were received before are skipped
+				t.replayedAtLastTimestamp++
+				return
+			}
+			t.replaying = false
+		}
+		switch {
+		case timestamp.Equal(t.lastTimestamp):
+			t.linesAtLastTimestamp++
+		case timestamp.After(t.lastTimestamp):
+			t.lastTimestamp = timestamp
+			t.linesAtLastTimestamp = 1
+		}
+	} else {
+		timestamp = time.Now().UTC()
+	}
+
+	progress.Log(ctx, t.podName, t.containerName, t.prefix, line)
+	if t.logFile != nil {
+		fmt.Fprintf(t.logFile, "%s [%s] %s\n", timestamp.Format(time.RFC3339Nano), t.containerName, line)
+	}
+	for _, handler := range t.logHandlers {
+		handler(line)
+	}
+}
+
+// parseTimestampedLine splits a log line requested with Timestamps: true into the timestamp and the log line
+func parseTimestampedLine(rawLine string) (time.Time, string, bool) {
+	rawTimestamp, line, found := strings.Cut(rawLine, " ")
+	if !found {
+		rawTimestamp = rawLine
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, rawTimestamp)
+	if err != nil {
+		return time.Time{}, rawLine, false
+	}
+	return timestamp, line, true
+}
+
+func isPodFinished(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
@@ -0,0 +1,80 @@
+package podrunner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLogTailerSkipsReceivedLines(t *testing.T) {
+	var lines []string
+	tailer := NewPodRunner(fake.NewSimpleClientset()).newLogTailer("default", "upgrade", "task", "upgrade", nil, []func(string){
+		func(line string) { lines = append(lines, line) },
+	})
+
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.100000000Z first")
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.200000000Z second")
+	// after reconnecting with SinceTime, lines within the same second are received again
+	tailer.resume()
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.100000000Z first")
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.200000000Z second")
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:01.000000000Z third")
+
+	assert.Equal(t, []string{"first", "second", "third"}, lines)
+}
+
+func TestLogTailerKeepsLinesWithTheSameTimestamp(t *testing.T) {
+	var lines []string
+	tailer := NewPodRunner(fake.NewSimpleClientset()).newLogTailer("default", "upgrade", "task", "upgrade", nil, []func(string){
+		func(line string) { lines = append(lines, line) },
+	})
+
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.100000000Z BEGIN")
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.100000000Z END")
+	assert.Equal(t, []string{"BEGIN", "END"}, lines)
+
+	// the replay after a reconnect skips the received lines, but not a new line with the same timestamp
+	tailer.resume()
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.100000000Z BEGIN")
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.100000000Z END")
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.100000000Z TABLE|new|app|public.users|10|")
+	tailer.handleLine(context.Background(), "2024-01-01T10:00:00.100000000Z TABLE|new|app|public.orders|5|")
+	assert.Equal(t, []string{"BEGIN", "END", "TABLE|new|app|public.users|10|", "TABLE|new|app|public.orders|5|"}, lines)
+}
+
+func TestTailPodLogsWritesLogFile(t *testing.T) {
+	logDir := t.TempDir()
+	k8sClient := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "upgrade", Namespace: "default"},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "prepare"}},
+			Containers:     []v1.Container{{Name: "upgrade-postgres"}},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodSucceeded,
+			InitContainerStatuses: []v1.ContainerStatus{{
+				Name:  "prepare",
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}},
+			}},
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:  "upgrade-postgres",
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}},
+			}},
+		},
+	})
+
+	err := NewPodRunnerWithOptions(k8sClient, Options{LogDir: logDir}).tailPodLogs(context.TODO(), "default", "upgrade", nil)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(logDir, "upgrade.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "[prepare] fake logs")
+	assert.Contains(t, string(data), "[upgrade-postgres] fake logs")
+}
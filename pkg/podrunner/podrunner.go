@@ -1,54 +1,136 @@
 package podrunner
 
 import (
-	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientset "k8s.io/client-go/kubernetes"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/progress"
 )
 
+// Runner runs a task pod to completion, streaming its logs
+type Runner interface {
+	RunPod(ctx context.Context, namespace, name string, taskPod v1.Pod, opts ...RunOption) error
+}
+
 type Client struct {
-	k8sClient clientset.Interface
+	k8sClient    clientset.Interface
+	options      Options
+	logger       *slog.Logger
+	resyncPeriod time.Duration
+}
+
+// Options configures the behaviour of the pod runner
+type Options struct {
+	// LogDir is the directory the logs of every task pod are written to, optional
+	LogDir string
+	// StuckTimeout is how long a pod may be pending, for example because it can not be scheduled or its volumes can
+	// not be attached, before the task fails. Defaults to DefaultStuckTimeout.
+	StuckTimeout time.Duration
+	// KeepOnFailure leaves a failed task pod in place for debugging, instead of deleting it
+	KeepOnFailure bool
+	// Logger receives the diagnostic logs of the runner, they are discarded when nil
+	Logger *slog.Logger
 }
 
 func NewPodRunner(clusterK8sClient clientset.Interface) *Client {
+	return NewPodRunnerWithOptions(clusterK8sClient, Options{})
+}
+
+func NewPodRunnerWithOptions(clusterK8sClient clientset.Interface, options Options) *Client {
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
 	return &Client{
-		k8sClient: clusterK8sClient,
+		k8sClient:    clusterK8sClient,
+		options:      options,
+		logger:       logger,
+		resyncPeriod: defaultResyncPeriod,
+	}
+}
+
+// RunOption customizes a single RunPod call
+type RunOption func(*runOptions)
+
+type runOptions struct {
+	logHandlers []func(line string)
+	podHandlers []func(pod *v1.Pod)
+}
+
+// WithLogHandler calls handler for every log line of the task pod. Log lines are still printed.
+func WithLogHandler(handler func(line string)) RunOption {
+	return func(o *runOptions) {
+		o.logHandlers = append(o.logHandlers, handler)
+	}
+}
+
+// WithCompletedPodHandler calls handler with the task pod once it has completed successfully, before it is removed.
+// For example to read the image digests from the container statuses.
+func WithCompletedPodHandler(handler func(pod *v1.Pod)) RunOption {
+	return func(o *runOptions) {
+		o.podHandlers = append(o.podHandlers, handler)
+	}
+}
+
+func (o *runOptions) handleCompletedPod(pod *v1.Pod) {
+	for _, handler := range o.podHandlers {
+		handler(pod)
 	}
 }
 
-func (c *Client) RunPod(ctx context.Context, namespace, name string, taskPod v1.Pod) error {
+func newRunOptions(opts []RunOption) *runOptions {
+	options := &runOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+func (c *Client) RunPod(ctx context.Context, namespace, name string, taskPod v1.Pod, opts ...RunOption) (err error) {
+	options := newRunOptions(opts)
+
 	podsApi := c.k8sClient.CoreV1().Pods(namespace)
 
 	if err := c.cleanUpTask(ctx, namespace, name); err != nil && !kubeerrors.IsNotFound(err) {
 		return fmt.Errorf("failed to delete existing task pod %q: %w", name, err)
 	}
 
-	defer c.cleanUpTask(ctx, namespace, name)
+	defer func() {
+		if err != nil && c.options.KeepOnFailure {
+			printKeptPodCommands(ctx, namespace, name, taskPod)
+			return
+		}
+		c.cleanUpTask(ctx, namespace, name)
+	}()
 
-	_, err := podsApi.Create(ctx, &taskPod, metav1.CreateOptions{})
+	_, err = podsApi.Create(ctx, &taskPod, metav1.CreateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to create job %q: %w", name, err)
 	}
+	c.logger.Debug("created task pod", "namespace", namespace, "pod", name)
 
 	err = c.waitForPodToStart(ctx, namespace, name)
 	if err != nil {
 		return err
 	}
-	err = c.tailPodLogs(ctx, namespace, name)
+	err = c.tailPodLogs(ctx, namespace, name, options.logHandlers)
 	if err != nil {
 		return err
 	}
-	err = c.waitForPodToComplete(ctx, namespace, name)
+	completedPod, err := c.waitForPodToComplete(ctx, namespace, name)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("task pod %q has completed\n", name)
+	options.handleCompletedPod(completedPod)
+	c.logger.Info("task pod has completed", "namespace", namespace, "pod", name)
 
 	// Clean-up once the job has been completed
 	if err := c.cleanUpTask(ctx, namespace, name); err != nil && !kubeerrors.IsNotFound(err) {
@@ -57,6 +139,32 @@ func (c *Client) RunPod(ctx context.Context, namespace, name string, taskPod v1.
 	return nil
 }
 
+// StartPod creates the pod, replacing an existing pod with the same name, and waits until it is running.
+// The pod is not deleted, which is up to the caller.
+func (c *Client) StartPod(ctx context.Context, namespace, name string, taskPod v1.Pod) error {
+	if err := c.cleanUpTask(ctx, namespace, name); err != nil {
+		return err
+	}
+	if err := c.waitForPodToBeDeleted(ctx, namespace, name); err != nil {
+		return err
+	}
+	if _, err := c.k8sClient.CoreV1().Pods(namespace).Create(ctx, &taskPod, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create pod %q: %w", name, err)
+	}
+	return c.waitForPodToStart(ctx, namespace, name)
+}
+
+// printKeptPodCommands prints the kubectl commands to inspect and remove a task pod that was kept after a failure
+func printKeptPodCommands(ctx context.Context, namespace, name string, taskPod v1.Pod) {
+	progress.Printf(ctx, "keeping failed task pod %q for debugging, inspect it using:\n", name)
+	progress.Printf(ctx, "  kubectl -n %s describe pod %s\n", namespace, name)
+	for _, container := range append(taskPod.Spec.InitContainers, taskPod.Spec.Containers...) {
+		progress.Printf(ctx, "  kubectl -n %s logs %s -c %s\n", namespace, name, container.Name)
+	}
+	progress.Printf(ctx, "remove it once done using:\n")
+	progress.Printf(ctx, "  kubectl -n %s delete pod %s\n", namespace, name)
+}
+
 func (c *Client) cleanUpTask(ctx context.Context, namespace, jobName string) error {
 	upgradeNodeJob := c.k8sClient.CoreV1().Pods(namespace)
 
@@ -69,74 +177,71 @@ func (c *Client) cleanUpTask(ctx context.Context, namespace, jobName string) err
 	return nil
 }
 
-func (c *Client) tailPodLogs(ctx context.Context, namespace, jobName string) error {
-	req := c.k8sClient.CoreV1().Pods(namespace).GetLogs(jobName, &v1.PodLogOptions{
-		Follow: true,
-	})
-
-	podLogs, err := req.Stream(ctx)
-	if err != nil {
-		return err
-	}
-	defer podLogs.Close()
-
-	reader := bufio.NewScanner(podLogs)
-	var logLine string
+func (c *Client) waitForPodToBeDeleted(ctx context.Context, namespace, name string) error {
 	for {
+		_, err := c.k8sClient.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if kubeerrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		default:
-			if reader.Scan() {
-				logLine = reader.Text()
-				fmt.Printf("[%v]: %v\n", jobName, logLine)
-			} else {
-				return reader.Err()
-			}
+		case <-time.After(1 * time.Second):
+			continue
 		}
 	}
 }
 
+// waitForPodToStart waits until a container of the pod has started and reports how long that took
 func (c *Client) waitForPodToStart(ctx context.Context, namespace, jobName string) error {
-	for {
-		pod, err := c.k8sClient.CoreV1().Pods(namespace).Get(ctx, jobName, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
-		for _, containerStatus := range pod.Status.ContainerStatuses {
+	start := time.Now()
+	err := c.waitForPod(ctx, namespace, jobName, func(pod *v1.Pod) (bool, error) {
+		for _, containerStatus := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
 			if containerStatus.State.Running != nil || containerStatus.State.Terminated != nil {
-				return nil
+				return true, nil
 			}
 		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(1 * time.Second):
-			continue
-		}
+		return false, c.checkPod(ctx, pod)
+	})
+	if err != nil {
+		return err
 	}
+	c.logger.Debug("task pod has started", "namespace", namespace, "pod", jobName, "wait", time.Since(start))
+	progress.Measure(ctx, progress.MeasurementPodWaitSeconds, time.Since(start).Seconds(), map[string]string{"pod": jobName})
+	return nil
 }
 
-func (c *Client) waitForPodToComplete(ctx context.Context, namespace, jobName string) error {
-	for {
-		pod, err := c.k8sClient.CoreV1().Pods(namespace).Get(ctx, jobName, metav1.GetOptions{})
-		if err != nil {
-			return err
+// waitForPodToComplete waits until the pod has completed successfully and returns it
+func (c *Client) waitForPodToComplete(ctx context.Context, namespace, jobName string) (*v1.Pod, error) {
+	var completedPod *v1.Pod
+	err := c.waitForPod(ctx, namespace, jobName, func(pod *v1.Pod) (bool, error) {
+		if pod.Status.Phase == v1.PodSucceeded {
+			completedPod = pod
+			return true, nil
 		}
-		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if containerStatus.State.Terminated != nil {
-				if containerStatus.State.Terminated.ExitCode == 0 {
-					return nil
-				} else {
-					return fmt.Errorf("unexpected exit code: %d", containerStatus.State.Terminated.ExitCode)
-				}
-			}
+		if err := c.checkPod(ctx, pod); err != nil {
+			return false, err
 		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(10 * time.Second):
-			continue
+		if hasSucceededContainer(pod.Status.ContainerStatuses) {
+			completedPod = pod
+			return true, nil
+		}
+		if pod.Status.Phase == v1.PodFailed {
+			return false, fmt.Errorf("pod %q failed: %s", jobName, pod.Status.Message)
+		}
+		return false, nil
+	})
+	return completedPod, err
+}
+
+func hasSucceededContainer(statuses []v1.ContainerStatus) bool {
+	for _, status := range statuses {
+		if status.State.Terminated != nil && status.State.Terminated.ExitCode == 0 {
+			return true
 		}
 	}
+	return false
 }
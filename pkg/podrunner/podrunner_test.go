@@ -0,0 +1,41 @@
+package podrunner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRunPodKeepOnFailure(t *testing.T) {
+	for _, keepOnFailure := range []bool{true, false} {
+		k8sClient := fake.NewSimpleClientset()
+		k8sClient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			pod := action.(k8stesting.CreateAction).GetObject().(*v1.Pod)
+			pod.Status.Phase = v1.PodFailed
+			pod.Status.Reason = "Evicted"
+			return false, nil, nil
+		})
+
+		runner := NewPodRunnerWithOptions(k8sClient, Options{KeepOnFailure: keepOnFailure})
+		err := runner.RunPod(context.Background(), "default", "upgrade", v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "upgrade", Namespace: "default"},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "upgrade-postgres"}}},
+		})
+		require.Error(t, err)
+
+		_, err = k8sClient.CoreV1().Pods("default").Get(context.Background(), "upgrade", metav1.GetOptions{})
+		if keepOnFailure {
+			assert.NoError(t, err, "the failed pod should be kept")
+		} else {
+			assert.True(t, kubeerrors.IsNotFound(err), "the failed pod should be removed")
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package podrunner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// defaultResyncPeriod is how often the pod is fetched again while watching it. It covers missed watch events and
+// conditions that depend on time, such as a pod that has been pending for too long.
+const defaultResyncPeriod = 30 * time.Second
+
+// podCondition reports whether waiting for the pod is done
+type podCondition func(pod *v1.Pod) (bool, error)
+
+// waitForPod waits until condition is done for the pod. The pod is watched using a field selector on its name, the
+// condition is evaluated for every change of the pod and at least once every resync period.
+func (c *Client) waitForPod(ctx context.Context, namespace, name string, condition podCondition) error {
+	podsApi := c.k8sClient.CoreV1().Pods(namespace)
+	for {
+		pod, err := podsApi.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if done, err := condition(pod); err != nil || done {
+			return err
+		}
+
+		done, err := c.watchPod(ctx, namespace, name, pod.ResourceVersion, condition)
+		if err != nil || done {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// watchPod evaluates condition for every change of the pod, until the resync period has passed or the watch has ended
+func (c *Client) watchPod(ctx context.Context, namespace, name, resourceVersion string, condition podCondition) (bool, error) {
+	resyncCtx, cancel := context.WithTimeout(ctx, c.resyncPeriod)
+	defer cancel()
+
+	watcher, err := c.k8sClient.CoreV1().Pods(namespace).Watch(resyncCtx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		// fall back to fetching the pod every resync period
+		c.logger.Warn("failed to watch pod, retrying", "namespace", namespace, "pod", name, "retryIn", c.resyncPeriod, "error", err)
+		<-resyncCtx.Done()
+		return false, nil
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-resyncCtx.Done():
+			return false, nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok || event.Type == watch.Error {
+				// the watch expired or was closed by the API server, avoid reconnecting in a tight loop
+				select {
+				case <-resyncCtx.Done():
+				case <-time.After(1 * time.Second):
+				}
+				return false, nil
+			}
+			pod, isPod := event.Object.(*v1.Pod)
+			if !isPod || pod.Name != name {
+				continue
+			}
+			if event.Type == watch.Deleted {
+				return false, fmt.Errorf("pod %q was deleted", name)
+			}
+			if done, err := condition(pod); err != nil || done {
+				return done, err
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package podrunner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newWatchedPod(phase v1.PodPhase, state v1.ContainerState) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "upgrade", Namespace: "default", CreationTimestamp: metav1.NewTime(time.Now())},
+		Status: v1.PodStatus{
+			Phase:             phase,
+			ContainerStatuses: []v1.ContainerStatus{{Name: "upgrade-postgres", State: state}},
+		},
+	}
+}
+
+func TestWaitForPodToCompleteWatchesPod(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(newWatchedPod(v1.PodRunning, v1.ContainerState{Running: &v1.ContainerStateRunning{}}))
+
+	gets := 0
+	k8sClient.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return false, nil, nil
+	})
+	watcher := watch.NewFake()
+	var fieldSelector string
+	k8sClient.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		fieldSelector = action.(k8stesting.WatchActionImpl).WatchRestrictions.Fields.String()
+		return true, watcher, nil
+	})
+
+	go func() {
+		watcher.Modify(newWatchedPod(v1.PodRunning, v1.ContainerState{Running: &v1.ContainerStateRunning{}}))
+		watcher.Modify(newWatchedPod(v1.PodSucceeded, v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0}}))
+	}()
+
+	runner := NewPodRunner(k8sClient)
+	runner.resyncPeriod = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pod, err := runner.waitForPodToComplete(ctx, "default", "upgrade")
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodSucceeded, pod.Status.Phase)
+	assert.Equal(t, "metadata.name=upgrade", fieldSelector)
+	assert.Equal(t, 1, gets, "the pod should only be fetched once, changes are received through the watch")
+}
+
+func TestWaitForPodToCompleteReportsFailureFromWatch(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(newWatchedPod(v1.PodRunning, v1.ContainerState{Running: &v1.ContainerStateRunning{}}))
+	watcher := watch.NewFake()
+	k8sClient.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watcher, nil))
+
+	go watcher.Modify(newWatchedPod(v1.PodFailed, v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}}))
+
+	runner := NewPodRunner(k8sClient)
+	runner.resyncPeriod = time.Minute
+	_, err := runner.waitForPodToComplete(context.Background(), "default", "upgrade")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "OOMKilled")
+}
+
+func TestWaitForPodToStartResyncsWithoutWatchEvents(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(newWatchedPod(v1.PodPending, v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}))
+	// a watch that never delivers events, the runner has to fall back to fetching the pod
+	k8sClient.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watch.NewFake(), nil))
+
+	runner := NewPodRunner(k8sClient)
+	runner.resyncPeriod = 50 * time.Millisecond
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		pod := newWatchedPod(v1.PodRunning, v1.ContainerState{Running: &v1.ContainerStateRunning{}})
+		_, _ = k8sClient.CoreV1().Pods("default").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, runner.waitForPodToStart(ctx, "default", "upgrade"))
+}
+
+func TestLogTailerWaitsForContainerUsingWatch(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(newWatchedPod(v1.PodPending, v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}))
+	gets := 0
+	k8sClient.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return false, nil, nil
+	})
+	watcher := watch.NewFake()
+	k8sClient.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watcher, nil))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		watcher.Modify(newWatchedPod(v1.PodRunning, v1.ContainerState{Running: &v1.ContainerStateRunning{}}))
+	}()
+
+	runner := NewPodRunner(k8sClient)
+	runner.resyncPeriod = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	state, err := runner.newLogTailer("default", "upgrade", "upgrade-postgres", "upgrade", nil, nil).containerState(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, state.Running)
+	assert.Equal(t, 1, gets, "the pod should only be fetched once while its container is waiting, changes are received through the watch")
+}
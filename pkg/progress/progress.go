@@ -0,0 +1,147 @@
+// Package progress reports the progress of an upgrade, either as the human readable output of the tool or as a
+// stream of newline delimited JSON events for pipelines.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventType is the kind of a progress event
+type EventType string
+
+const (
+	EventPhaseStart  EventType = "phase_start"
+	EventPhaseEnd    EventType = "phase_end"
+	EventMessage     EventType = "message"
+	EventLog         EventType = "log"
+	EventTable       EventType = "table"
+	EventMeasurement EventType = "measurement"
+)
+
+// Phases of an upgrade
+const (
+	PhaseUpgrade         = "upgrade"
+	PhaseScaleDown       = "scale_down"
+	PhaseExtensionCheck  = "extension_check"
+	PhasePGUpgrade       = "pg_upgrade"
+	PhaseVerify          = "verify"
+	PhaseSwitchVolumes   = "switch_volumes"
+	PhasePostUpgradeHook = "post_upgrade_hook"
+)
+
+// Measurements reported during an upgrade
+const (
+	// MeasurementUpgradeInfo is always 1, the labels from_version and to_version are the postgres versions of the upgrade
+	MeasurementUpgradeInfo = "upgrade_info"
+	// MeasurementDataDirectoryBytes is the size of the data directory, the label stage is either before or after
+	MeasurementDataDirectoryBytes = "data_directory_bytes"
+	// MeasurementCopiedBytes is the number of bytes pg_upgrade wrote to the new data directory
+	MeasurementCopiedBytes = "copied_bytes"
+	// MeasurementPodWaitSeconds is the time a task pod took to start, the label pod is the name of the pod
+	MeasurementPodWaitSeconds = "pod_wait_seconds"
+)
+
+// Event is a single progress event
+type Event struct {
+	Time  time.Time `json:"time"`
+	Type  EventType `json:"type"`
+	Phase string    `json:"phase,omitempty"`
+	// Objects are the Kubernetes objects the event is about, by kind
+	Objects map[string]string `json:"objects,omitempty"`
+	Message string            `json:"message,omitempty"`
+
+	// Pod, Container and Line are set for log lines of task pods
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	Line      string `json:"line,omitempty"`
+	// Source is the prefix of a log line in the human readable output
+	Source string `json:"-"`
+
+	// Header and Rows are set for tables
+	Header []string   `json:"header,omitempty"`
+	Rows   [][]string `json:"rows,omitempty"`
+
+	// Measurement, Value and Labels are set for measurements, Value is a pointer so measurements of zero are reported
+	Measurement string            `json:"measurement,omitempty"`
+	Value       *float64          `json:"value,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	// DurationSeconds and Error are set when a phase has ended
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// Reporter receives the progress events of an upgrade, it must be safe for concurrent use
+type Reporter interface {
+	Report(event Event)
+}
+
+type reporterKey struct{}
+type phaseKey struct{}
+
+// WithReporter returns a context that reports progress to reporter
+func WithReporter(ctx context.Context, reporter Reporter) context.Context {
+	return context.WithValue(ctx, reporterKey{}, reporter)
+}
+
+// FromContext returns the reporter of the context, progress is discarded when none was set
+func FromContext(ctx context.Context) Reporter {
+	if reporter, ok := ctx.Value(reporterKey{}).(Reporter); ok {
+		return reporter
+	}
+	return defaultReporter
+}
+
+var defaultReporter Reporter = discardReporter{}
+
+// currentPhase returns the innermost phase the context was tagged with
+func currentPhase(ctx context.Context) string {
+	phase, _ := ctx.Value(phaseKey{}).(string)
+	return phase
+}
+
+func report(ctx context.Context, event Event) {
+	event.Time = time.Now().UTC()
+	if event.Phase == "" {
+		event.Phase = currentPhase(ctx)
+	}
+	FromContext(ctx).Report(event)
+}
+
+// Printf reports a message, in the human readable output it is printed as is
+func Printf(ctx context.Context, format string, args ...any) {
+	report(ctx, Event{Type: EventMessage, Message: strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")})
+}
+
+// Log reports a log line of a container of a task pod. Source is the prefix of the line in the human readable output.
+func Log(ctx context.Context, pod, container, source, line string) {
+	report(ctx, Event{Type: EventLog, Pod: pod, Container: container, Source: source, Line: line})
+}
+
+// Table reports tabular results, such as the result of a check
+func Table(ctx context.Context, header []string, rows [][]string) {
+	report(ctx, Event{Type: EventTable, Header: header, Rows: rows})
+}
+
+// Measure reports a measurement, such as the size of a data directory
+func Measure(ctx context.Context, measurement string, value float64, labels map[string]string) {
+	report(ctx, Event{Type: EventMeasurement, Measurement: measurement, Value: &value, Labels: labels})
+}
+
+// StartPhase reports the start of a phase and returns a context tagged with the phase, so events reported using the
+// context belong to the phase. The returned function reports the end of the phase with its result.
+func StartPhase(ctx context.Context, phase string, objects map[string]string) (context.Context, func(err error)) {
+	start := time.Now()
+	report(ctx, Event{Type: EventPhaseStart, Phase: phase, Objects: objects})
+	phaseCtx := context.WithValue(ctx, phaseKey{}, phase)
+	return phaseCtx, func(err error) {
+		event := Event{Type: EventPhaseEnd, Phase: phase, Objects: objects, DurationSeconds: time.Since(start).Seconds()}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		report(ctx, event)
+	}
+}
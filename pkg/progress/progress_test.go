@@ -0,0 +1,88 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONReporter(t *testing.T) {
+	var out bytes.Buffer
+	ctx := WithReporter(context.Background(), NewJSONReporter(&out))
+
+	phaseCtx, endPhase := StartPhase(ctx, PhasePGUpgrade, map[string]string{"pod": "pg-upgrade-data-0"})
+	Printf(phaseCtx, "running pg_upgrade for %q\n", "data-0")
+	Log(phaseCtx, "pg-upgrade-data-0", "prepare", "pg-upgrade-data-0/prepare", "database size:")
+	endPhase(errors.New("unexpected exit code: 1"))
+	Printf(ctx, "done\n")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 5)
+
+	events := make([]Event, 0, len(lines))
+	for _, line := range lines {
+		var event Event
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		events = append(events, event)
+	}
+
+	assert.Equal(t, EventPhaseStart, events[0].Type)
+	assert.Equal(t, PhasePGUpgrade, events[0].Phase)
+	assert.Equal(t, map[string]string{"pod": "pg-upgrade-data-0"}, events[0].Objects)
+
+	assert.Equal(t, EventMessage, events[1].Type)
+	assert.Equal(t, PhasePGUpgrade, events[1].Phase)
+	assert.Equal(t, `running pg_upgrade for "data-0"`, events[1].Message)
+
+	assert.Equal(t, EventLog, events[2].Type)
+	assert.Equal(t, PhasePGUpgrade, events[2].Phase)
+	assert.Equal(t, "prepare", events[2].Container)
+	assert.Equal(t, "database size:", events[2].Line)
+	assert.Empty(t, events[2].Source)
+
+	assert.Equal(t, EventPhaseEnd, events[3].Type)
+	assert.Equal(t, "unexpected exit code: 1", events[3].Error)
+
+	assert.Equal(t, EventMessage, events[4].Type)
+	assert.Empty(t, events[4].Phase)
+}
+
+func TestHumanReporter(t *testing.T) {
+	var out bytes.Buffer
+	ctx := WithReporter(context.Background(), NewHumanReporter(&out))
+
+	phaseCtx, endPhase := StartPhase(ctx, PhaseVerify, nil)
+	Printf(phaseCtx, "scaling down postgres statefulset...\n")
+	Log(phaseCtx, "verify-data-0", "verify", "verify-data-0", "comparing tables")
+	endPhase(nil)
+
+	assert.Equal(t, "scaling down postgres statefulset...\n[verify-data-0]: comparing tables\n", out.String())
+}
+
+func TestTee(t *testing.T) {
+	human := &bytes.Buffer{}
+	jsonOut := &bytes.Buffer{}
+	ctx := WithReporter(context.Background(), Tee{NewHumanReporter(human), NewJSONReporter(jsonOut)})
+
+	Printf(ctx, "hello\n")
+	Measure(ctx, MeasurementCopiedBytes, 1024, nil)
+	Measure(ctx, MeasurementPodWaitSeconds, 0, nil)
+
+	assert.Equal(t, "hello\n", human.String())
+	lines := strings.Split(strings.TrimSpace(jsonOut.String()), "\n")
+	require.Len(t, lines, 3)
+	var event Event
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+	assert.Equal(t, EventMeasurement, event.Type)
+	assert.Equal(t, MeasurementCopiedBytes, event.Measurement)
+	require.NotNil(t, event.Value)
+	assert.Equal(t, float64(1024), *event.Value)
+	assert.Contains(t, lines[2], `"value":0`, "measurements of zero should be reported")
+	assert.NotContains(t, lines[0], `"value"`)
+}
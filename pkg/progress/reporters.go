@@ -0,0 +1,65 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containerinfra/kube-pg-upgrade/pkg/table"
+)
+
+// HumanReporter prints messages, log lines and tables as the human readable output of the tool. Phases are not printed.
+type HumanReporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewHumanReporter(out io.Writer) *HumanReporter {
+	return &HumanReporter{out: out}
+}
+
+func (r *HumanReporter) Report(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch event.Type {
+	case EventMessage:
+		fmt.Fprintln(r.out, event.Message)
+	case EventLog:
+		fmt.Fprintf(r.out, "[%v]: %v\n", event.Source, event.Line)
+	case EventTable:
+		table.Fprint(r.out, event.Header, event.Rows)
+	}
+}
+
+// JSONReporter writes every event as a single line of JSON
+type JSONReporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewJSONReporter(out io.Writer) *JSONReporter {
+	return &JSONReporter{encoder: json.NewEncoder(out)}
+}
+
+func (r *JSONReporter) Report(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// a failing writer must not fail the upgrade
+	_ = r.encoder.Encode(event)
+}
+
+// discardReporter drops all events
+type discardReporter struct{}
+
+func (discardReporter) Report(Event) {}
+
+// Tee reports every event to all reporters
+type Tee []Reporter
+
+func (t Tee) Report(event Event) {
+	for _, reporter := range t {
+		reporter.Report(event)
+	}
+}
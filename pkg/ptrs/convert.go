@@ -14,6 +14,10 @@ func Int32(v int32) *int32 {
 	return &v
 }
 
+func Float64(v float64) *float64 {
+	return &v
+}
+
 func String(v string) *string {
 	return &v
 }
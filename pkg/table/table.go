@@ -1,13 +1,22 @@
 package table
 
 import (
+	"io"
 	"os"
 
 	"github.com/olekukonko/tablewriter"
 )
 
+// Print writes the table to stdout.
+//
+// Deprecated: use Fprint.
 func Print(header []string, body [][]string) {
-	table := tablewriter.NewWriter(os.Stdout)
+	Fprint(os.Stdout, header, body)
+}
+
+// Fprint writes the table to out
+func Fprint(out io.Writer, header []string, body [][]string) {
+	table := tablewriter.NewWriter(out)
 	if len(header) > 0 {
 		table.SetHeader(header)
 	}
@@ -2,6 +2,8 @@ package version
 
 import (
 	"fmt"
+	"io"
+	"os"
 )
 
 type VersionInfo struct {
@@ -22,10 +24,17 @@ func Init(version, commit, date, builtBy string) {
 	}
 }
 
-// Print version info
+// Print writes the version info to stdout.
+//
+// Deprecated: use Fprint.
 func Print() {
-	fmt.Printf("Version information\nVersion: %s, Commit: %s\n", Version(), Commit())
-	fmt.Printf("Build date: %s, Build by: %s\n", BuildDate(), BuiltBy())
+	Fprint(os.Stdout)
+}
+
+// Fprint writes the version info to out
+func Fprint(out io.Writer) {
+	fmt.Fprintf(out, "Version information\nVersion: %s, Commit: %s\n", Version(), Commit())
+	fmt.Fprintf(out, "Build date: %s, Build by: %s\n", BuildDate(), BuiltBy())
 }
 
 // Commit returns git commit